@@ -1,13 +1,14 @@
 package client
 
 import (
-	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/go-zoox/logger"
@@ -23,8 +24,31 @@ type Client interface {
 	Close() error
 	Resize() error
 	Send(key []byte) error
-	//
+	SendEOF() error
+	// ReadOnly reports whether the server acknowledged the connection as
+	// read-only; its input is refused.
+	ReadOnly() bool
+	// OnExit is called once with the exit code of the session, or
+	// ExitCodeConnectionClosed / ExitCodeTransportFailure when the connection
+	// ended without one.
 	OnExit(func(code int, message string))
+	// OnConnected is called once the server acknowledged the first connect.
+	OnConnected(func(sessionID string))
+	// OnOutput is called with every TypeOutput payload; p must not be retained.
+	OnOutput(func(p []byte))
+	// OnError is called for TypeError frames (as *ServerError) and transport
+	// failures (heartbeat timeout, read errors, failed reconnect attempts).
+	OnError(func(err error))
+	// OnDisconnected is called when the current WebSocket is lost.
+	OnDisconnected(func(err error))
+	// OnReconnected is called when a reconnect resumed the session.
+	OnReconnected(func(sessionID string))
+	// OnHeartbeat is called for every server heartbeat.
+	OnHeartbeat(func())
+	// OnInput is called with every payload passed to Send; p must not be retained.
+	OnInput(func(p []byte))
+	// OnResize is called with the terminal size sent by Resize.
+	OnResize(func(columns, rows int))
 }
 
 type Config struct {
@@ -38,9 +62,46 @@ type Config struct {
 	//
 	Container string
 	Image     string
+	// SSHHost, SSHPort, SSHUser and the credentials pick the target of the ssh
+	// driver; the server only accepts hosts it allows.
+	SSHHost       string
+	SSHPort       int
+	SSHUser       string
+	SSHPassword   string
+	SSHPrivateKey string
+	// ReadOnly asks the server for a read-only connection: output is shown but
+	// input is refused with an error.
+	ReadOnly bool
 	//
 	Username string
 	Password string
+	// Token is sent as a bearer token (JWT), taking precedence over Username/Password.
+	Token string
+	//
+	// Proxy is the proxy URL the WebSocket dial tunnels through, e.g.
+	// http://proxy:3128 (HTTP CONNECT) or socks5://proxy:1080. Empty means
+	// HTTPS_PROXY / HTTP_PROXY / ALL_PROXY from the environment (NO_PROXY honored).
+	Proxy         string
+	ProxyUsername string
+	ProxyPassword string
+	//
+	// TLSInsecureSkipVerify disables server certificate verification for wss://.
+	TLSInsecureSkipVerify bool
+	// TLSCAFile is a PEM bundle trusted in addition to the system roots.
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile present a client certificate (mutual TLS).
+	TLSCertFile string
+	TLSKeyFile  string
+	//
+	// HeartbeatTimeout treats the server as gone when no frame arrives within this
+	// duration (the server sends a heartbeat every 13 seconds, so use at least 30s).
+	// Zero disables dead-server detection.
+	HeartbeatTimeout time.Duration
+	// Reconnect re-dials and resumes the session after a transport failure instead
+	// of exiting with ExitCodeTransportFailure. ReconnectAttempts bounds the retries
+	// (default 5).
+	Reconnect         bool
+	ReconnectAttempts int
 	//
 	Stdout io.Writer
 	Stderr io.Writer
@@ -52,12 +113,45 @@ type client struct {
 	stdout io.Writer
 	stderr io.Writer
 	//
+	addr    string
+	headers http.Header
+	//
 	closeCh   chan struct{}
 	messageCh chan []byte
+	// connectCh gets nil for the server's connect ack, or the TypeExit /
+	// TypeError that refused the connect.
+	connectCh chan error
+	//
+	exitOnce sync.Once
+	events   events
 	//
-	exitCh chan *ExitError
+	sendMu      sync.Mutex
+	pendingLine bool
+	//
+	mu        sync.Mutex
+	conn      websocket.Conn
+	sessionID string
+	readOnly  bool
+	lastSeen  time.Time
+	closed    bool
 }
 
+// ExitCodeConnectionClosed is reported to OnExit when the WebSocket closes before
+// the server sent TypeExit, the same code ssh uses for transport failures. The
+// WebSocket close code, reported as the exit code before, is in the message.
+const ExitCodeConnectionClosed = 255
+
+// ExitCodeTransportFailure is reported to OnExit when the server stops responding
+// (see Config.HeartbeatTimeout) and the session could not be resumed.
+const ExitCodeTransportFailure = 254
+
+// ErrHeartbeatTimeout is passed to OnError when no server frame arrived within
+// Config.HeartbeatTimeout.
+var ErrHeartbeatTimeout = errors.New("server heartbeat timeout")
+
+// ErrClosed is returned by Send and Resize after Close.
+var ErrClosed = errors.New("client closed")
+
 type ExitError struct {
 	Code    int
 	Message string
@@ -85,8 +179,7 @@ func New(cfg *Config) Client {
 		//
 		closeCh:   make(chan struct{}),
 		messageCh: make(chan []byte),
-		//
-		exitCh: make(chan *ExitError),
+		connectCh: make(chan error, 1),
 	}
 }
 
@@ -106,49 +199,71 @@ func (c *client) Connect() error {
 	}
 
 	headers := http.Header{}
-	if c.cfg.Username != "" || c.cfg.Password != "" {
+	if c.cfg.Token != "" {
+		headers.Set("Authorization", fmt.Sprintf("Bearer %s", c.cfg.Token))
+	} else if c.cfg.Username != "" || c.cfg.Password != "" {
 		headers.Set("Authorization", fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(c.cfg.Username+":"+c.cfg.Password))))
 	}
 
-	wc, err := websocket.NewClient(func(opt *websocket.ClientOption) {
-		opt.Context = context.Background()
-		opt.Addr = u.String()
-		opt.Headers = headers
-		opt.ConnectTimeout = 10 * time.Second
-	})
-	if err != nil {
+	c.addr = u.String()
+	c.headers = headers
+
+	go c.writeLoop()
+
+	if err := c.dial(false); err != nil {
 		return err
 	}
 
-	connectCh := make(chan struct{})
+	logger.Debugf("connected to %s", u.String())
+
+	return nil
+}
+
+// dial opens a WebSocket, sends TypeConnect (resuming c.sessionID when set) and
+// waits for the server ack. It is used for the first connect and for reconnects.
+// A TypeExit or TypeError before the ack fails it with an *ExitError or a
+// *ServerError.
+func (c *client) dial(reconnect bool) error {
+	dialer, err := newDialer(c.cfg)
+	if err != nil {
+		return err
+	}
+	wc := newWSClient(dialer, c.addr, c.headers, 10*time.Second)
 
 	wc.OnClose(func(conn conn.Conn, code int, message string) error {
-		c.exitCh <- &ExitError{
-			Code:    code,
-			Message: "terminal connection closed\n",
+		if !c.detach(conn) {
+			return nil
 		}
+
+		c.emitDisconnected(fmt.Errorf("connection closed (code: %d, message: %s)", code, message))
+		c.exit(ExitCodeConnectionClosed, fmt.Sprintf("terminal connection closed (code: %d)\n", code))
+		return nil
+	})
+
+	wc.OnError(func(conn conn.Conn, err error) error {
+		c.transportFailure(conn, err)
 		return nil
 	})
 
 	wc.OnConnect(func(conn websocket.Conn) error {
-		go func() {
-			for {
-				select {
-				case <-c.closeCh:
-					close(c.messageCh)
-					conn.Close()
-					return
-				case msg := <-c.messageCh:
-					if err := conn.WriteTextMessage(msg); err != nil {
-						logger.Errorf("failed to write message: %s", err)
-						return
-					}
-				}
-			}
-		}()
+		c.mu.Lock()
+		if c.closed {
+			// Close was called while dialing
+			c.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		c.conn = conn
+		c.lastSeen = time.Now()
+		sessionID := c.sessionID
+		c.mu.Unlock()
+
+		go c.watchHeartbeat(conn)
 
 		if c.cfg.Image != "" {
 			c.cfg.Container = "docker"
+		} else if c.cfg.SSHHost != "" && c.cfg.Container == "" {
+			c.cfg.Container = "ssh"
 		}
 
 		msg := &message.Message{}
@@ -166,67 +281,271 @@ func (c *client) Connect() error {
 			//
 			Username: c.cfg.Username,
 			Password: c.cfg.Password,
+			//
+			SessionID: sessionID,
+			ReadOnly:  c.cfg.ReadOnly,
+			//
+			SSHHost:       c.cfg.SSHHost,
+			SSHPort:       c.cfg.SSHPort,
+			SSHUser:       c.cfg.SSHUser,
+			SSHPassword:   c.cfg.SSHPassword,
+			SSHPrivateKey: c.cfg.SSHPrivateKey,
 		})
 		if err := msg.Serialize(); err != nil {
 			return err
 		}
 
-		// if err := conn.WriteTextMessage(msg.Msg()); err != nil {
-		// 	return err
-		// }
-		c.messageCh <- msg.Msg()
+		select {
+		case c.messageCh <- msg.Msg():
+		case <-c.closeCh:
+			conn.Close()
+		}
 
 		return nil
 	})
 
-	wc.OnBinaryMessage(func(conn websocket.Conn, rawMsg []byte) error {
-		msg, err := message.Deserialize(rawMsg)
+	wc.OnBinaryMessage(c.handleMessage)
+
+	// drop a TypeError of the previous connection
+	select {
+	case <-c.connectCh:
+	default:
+	}
+
+	if err := wc.Connect(); err != nil {
+		return err
+	}
+
+	// wait for connect
+	select {
+	case err := <-c.connectCh:
 		if err != nil {
-			c.stderr.Write([]byte(fmt.Sprintf("failed to deserialize message: %s\n", err)))
+			return err
+		}
+	case <-c.closeCh:
+		return ErrClosed
+	}
+
+	c.mu.Lock()
+	sessionID := c.sessionID
+	c.mu.Unlock()
+	if reconnect {
+		c.emitReconnected(sessionID)
+	} else {
+		c.emitConnected(sessionID)
+	}
+
+	return nil
+}
+
+func (c *client) handleMessage(conn websocket.Conn, rawMsg []byte) error {
+	c.mu.Lock()
+	if c.conn == conn {
+		c.lastSeen = time.Now()
+	}
+	c.mu.Unlock()
+
+	msg, err := message.Deserialize(rawMsg)
+	if err != nil {
+		c.stderr.Write([]byte(fmt.Sprintf("failed to deserialize message: %s\n", err)))
+		return nil
+	}
+
+	switch msg.Type() {
+	case message.TypeConnect:
+		if data := msg.Connect(); data != nil && data.SessionID != "" {
+			c.mu.Lock()
+			c.sessionID = data.SessionID
+			c.readOnly = data.ReadOnly
+			c.mu.Unlock()
+		}
+
+		c.notifyConnect(nil)
+	case message.TypeOutput:
+		c.stdout.Write(msg.Output())
+		c.emitOutput(msg.Output())
+	case message.TypeHeartBeat:
+		c.emitHeartbeat()
+
+		msg := &message.Message{}
+		msg.SetType(message.TypeHeartBeat)
+		if err := msg.Serialize(); err != nil {
+			c.stderr.Write([]byte(fmt.Sprintf("failed to serialize message: %s\n", err)))
 			return nil
 		}
 
-		switch msg.Type() {
-		case message.TypeConnect:
-			connectCh <- struct{}{}
-		case message.TypeOutput:
-			c.stdout.Write(msg.Output())
-		case message.TypeHeartBeat:
-			msg := &message.Message{}
-			msg.SetType(message.TypeHeartBeat)
-			if err := msg.Serialize(); err != nil {
-				c.stderr.Write([]byte(fmt.Sprintf("failed to serialize message: %s\n", err)))
-				return nil
-			}
+		c.send(msg.Msg())
+	case message.TypeExit:
+		data := msg.Exit()
+
+		c.exit(data.Code, data.Message)
+		c.notifyConnect(&ExitError{Code: data.Code, Message: data.Message})
+	case message.TypeError:
+		data := msg.Error()
+		c.stderr.Write([]byte(fmt.Sprintf("error: %s\n", data.Message)))
+		c.emitError(&ServerError{Message: data.Message})
+		c.notifyConnect(&ServerError{Message: data.Message})
+	default:
+		c.stderr.Write([]byte(fmt.Sprintf("unknown message type: %v\n", msg.Type())))
+	}
 
-			c.messageCh <- msg.Msg()
-		case message.TypeExit:
-			data := msg.Exit()
+	return nil
+}
+
+// notifyConnect passes the result of a connect to dial; it is dropped when dial
+// is not waiting for one.
+func (c *client) notifyConnect(err error) {
+	select {
+	case c.connectCh <- err:
+	default:
+	}
+}
 
-			c.exitCh <- &ExitError{
-				Code:    data.Code,
-				Message: data.Message,
+// writeLoop is the only writer; it follows the current connection so reconnect can
+// swap the WebSocket underneath Send. Frames sent while disconnected are dropped.
+func (c *client) writeLoop() {
+	for {
+		select {
+		case <-c.closeCh:
+			c.mu.Lock()
+			conn := c.conn
+			c.conn = nil
+			c.closed = true
+			c.mu.Unlock()
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		case msg := <-c.messageCh:
+			c.mu.Lock()
+			conn := c.conn
+			c.mu.Unlock()
+			if conn == nil {
+				logger.Debugf("dropping message while disconnected")
+				continue
+			}
+			if err := conn.WriteTextMessage(msg); err != nil {
+				logger.Errorf("failed to write message: %s", err)
 			}
-		case message.TypeError:
-			data := msg.Error()
-			c.stderr.Write([]byte(fmt.Sprintf("error: %s\n", data.Message)))
-		default:
-			c.stderr.Write([]byte(fmt.Sprintf("unknown message type: %v\n", msg.Type())))
 		}
+	}
+}
 
+// send queues msg for writeLoop, failing with ErrClosed once the client is
+// closed.
+func (c *client) send(msg []byte) error {
+	select {
+	case c.messageCh <- msg:
 		return nil
-	})
+	case <-c.closeCh:
+		return ErrClosed
+	}
+}
 
-	if err := wc.Connect(); err != nil {
-		return err
+// watchHeartbeat reports a transport failure when conn stays silent longer than
+// Config.HeartbeatTimeout.
+func (c *client) watchHeartbeat(conn websocket.Conn) {
+	timeout := c.cfg.HeartbeatTimeout
+	if timeout <= 0 {
+		return
 	}
 
-	// wait for connect
-	<-connectCh
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			current := c.conn == conn
+			silent := time.Since(c.lastSeen)
+			c.mu.Unlock()
+			if !current {
+				return
+			}
+			if silent > timeout {
+				c.transportFailure(conn, fmt.Errorf("%w: no frame for %s", ErrHeartbeatTimeout, silent.Truncate(time.Second)))
+				return
+			}
+		}
+	}
+}
 
-	logger.Debugf("connected to %s", u.String())
+// detach drops conn as the current connection; it reports false when conn was
+// already replaced or detached, so each connection fails at most once.
+func (c *client) detach(conn websocket.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil || c.conn != conn {
+		return false
+	}
+	c.conn = nil
+	return true
+}
 
-	return nil
+func (c *client) transportFailure(conn websocket.Conn, err error) {
+	if !c.detach(conn) {
+		return
+	}
+	conn.Close()
+
+	logger.Debugf("transport failure: %s", err)
+	c.emitError(err)
+	c.emitDisconnected(err)
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return
+	}
+
+	if !c.cfg.Reconnect {
+		c.exit(ExitCodeTransportFailure, fmt.Sprintf("terminal connection lost: %s\n", err))
+		return
+	}
+
+	go c.reconnect()
+}
+
+func (c *client) reconnect() {
+	attempts := c.cfg.ReconnectAttempts
+	if attempts <= 0 {
+		attempts = 5
+	}
+
+	backoff := time.Second
+	for i := 1; i <= attempts; i++ {
+		select {
+		case <-c.closeCh:
+			return
+		case <-time.After(backoff):
+		}
+
+		logger.Debugf("reconnecting to %s (attempt %d/%d)", c.addr, i, attempts)
+		err := c.dial(true)
+		if err == nil {
+			logger.Debugf("reconnected to %s", c.addr)
+			return
+		}
+
+		c.emitError(fmt.Errorf("reconnect attempt %d/%d: %w", i, attempts, err))
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+
+	c.exit(ExitCodeTransportFailure, fmt.Sprintf("terminal connection lost: gave up after %d reconnect attempts\n", attempts))
+}
+
+func (c *client) exit(code int, message string) {
+	c.exitOnce.Do(func() {
+		c.emitExit(&ExitError{
+			Code:    code,
+			Message: message,
+		})
+	})
 }
 
 func (c *client) Close() error {
@@ -254,12 +573,24 @@ func (c *client) Resize() error {
 		return err
 	}
 
-	c.messageCh <- msg.Msg()
+	if err := c.send(msg.Msg()); err != nil {
+		return err
+	}
+	c.emitResize(columns, rows)
 
 	return nil
 }
 
 func (c *client) Send(key []byte) error {
+	if len(key) == 0 {
+		return nil
+	}
+
+	c.sendMu.Lock()
+	last := key[len(key)-1]
+	c.pendingLine = last != '\n' && last != '\r'
+	c.sendMu.Unlock()
+
 	msg := &message.Message{}
 	msg.SetType(message.TypeKey)
 	msg.SetKey(key)
@@ -267,13 +598,28 @@ func (c *client) Send(key []byte) error {
 		return err
 	}
 
-	c.messageCh <- msg.Msg()
+	if err := c.send(msg.Msg()); err != nil {
+		return err
+	}
+	c.emitInput(key)
 	return nil
 }
 
-func (c *client) OnExit(cb func(code int, message string)) {
-	go func() {
-		exitErr := <-c.exitCh
-		cb(exitErr.Code, exitErr.Message)
-	}()
+func (c *client) ReadOnly() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.readOnly
+}
+
+// SendEOF signals end of input the way a TTY does: VEOF (Ctrl+D) on an empty line.
+// A pending partial line is flushed first by an extra Ctrl+D.
+func (c *client) SendEOF() error {
+	c.sendMu.Lock()
+	pending := c.pendingLine
+	c.sendMu.Unlock()
+
+	if pending {
+		return c.Send([]byte{4, 4})
+	}
+	return c.Send([]byte{4})
 }
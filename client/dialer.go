@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-zoox/websocket/conn"
+	"github.com/go-zoox/websocket/event"
+	gorilla "github.com/gorilla/websocket"
+)
+
+// newDialer returns the WebSocket dialer for cfg: a copy of gorilla.DefaultDialer
+// with the proxy and TLS settings of cfg, so concurrent clients never share them.
+func newDialer(cfg *Config) (*gorilla.Dialer, error) {
+	proxy, err := proxyFunc(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := tlsClientConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := *gorilla.DefaultDialer
+	dialer.Proxy = proxy
+	dialer.TLSClientConfig = tlsConfig
+	return &dialer, nil
+}
+
+// wsClient is the subset of go-zoox/websocket's client used by the terminal
+// client, dialing with its own dialer: websocket.Client always dials with the
+// process-wide gorilla.DefaultDialer.
+type wsClient struct {
+	dialer  *gorilla.Dialer
+	addr    string
+	headers http.Header
+	timeout time.Duration
+	//
+	errors   []func(conn conn.Conn, err error) error
+	closes   []func(conn conn.Conn, code int, message string) error
+	connects []func(conn conn.Conn) error
+	messages []func(conn conn.Conn, message []byte) error
+}
+
+func newWSClient(dialer *gorilla.Dialer, addr string, headers http.Header, timeout time.Duration) *wsClient {
+	return &wsClient{dialer: dialer, addr: addr, headers: headers, timeout: timeout}
+}
+
+func (c *wsClient) OnError(cb func(conn conn.Conn, err error) error) {
+	c.errors = append(c.errors, cb)
+}
+
+func (c *wsClient) OnClose(cb func(conn conn.Conn, code int, message string) error) {
+	c.closes = append(c.closes, cb)
+}
+
+func (c *wsClient) OnConnect(cb func(conn conn.Conn) error) {
+	c.connects = append(c.connects, cb)
+}
+
+func (c *wsClient) OnBinaryMessage(cb func(conn conn.Conn, message []byte) error) {
+	c.messages = append(c.messages, cb)
+}
+
+// Connect dials the server, emits connect and reads messages until the
+// connection fails or closes. Pings are answered by the gorilla default handler.
+func (c *wsClient) Connect() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	raw, response, err := c.dialer.DialContext(ctx, c.addr, c.headers)
+	if err != nil {
+		if response == nil || response.Body == nil {
+			return fmt.Errorf("failed to connect at %s (error: %s)", c.addr, err)
+		}
+
+		body, errB := io.ReadAll(response.Body)
+		if errB != nil {
+			return fmt.Errorf("failed to connect at %s (status: %s, error: %s)", c.addr, response.Status, err)
+		}
+		return fmt.Errorf("failed to connect at %s (status: %d, response: %s, error: %v)", c.addr, response.StatusCode, string(body), err)
+	}
+
+	wc := conn.New(context.Background(), raw, nil)
+	for _, cb := range c.errors {
+		wc.OnError(func(err error) error { return cb(wc, err) })
+	}
+	for _, cb := range c.closes {
+		wc.OnClose(func(code int, message string) error { return cb(wc, code, message) })
+	}
+	for _, cb := range c.messages {
+		wc.OnMessage(func(typ int, message []byte) error {
+			if typ != conn.BinaryMessage {
+				return nil
+			}
+			return cb(wc, message)
+		})
+	}
+	for _, cb := range c.connects {
+		wc.OnConnect(func() error { return cb(wc) })
+	}
+
+	wc.Emit(event.TypeConnect, &event.PayloadConnect{})
+
+	go func() {
+		for {
+			typ, message, err := raw.ReadMessage()
+			if err != nil {
+				if v, ok := err.(*gorilla.CloseError); ok {
+					wc.Emit(event.TypeClose, &event.PayloadClose{Code: v.Code, Message: v.Text})
+					return
+				}
+
+				wc.Emit(event.TypeError, &event.PayloadError{Error: err})
+				return
+			}
+
+			wc.Emit(event.TypeMessage, &event.PayloadMessage{Type: typ, Message: message})
+		}
+	}()
+
+	return nil
+}
+
+// tlsClientConfig builds the TLS config for wss:// servers, nil when cfg has no TLS settings.
+func tlsClientConfig(cfg *Config) (*tls.Config, error) {
+	if !cfg.TLSInsecureSkipVerify && cfg.TLSCAFile == "" && cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.TLSInsecureSkipVerify,
+	}
+
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls ca file: %s", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in tls ca file %s", cfg.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, fmt.Errorf("tls client certificate requires both cert file and key file")
+		}
+
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/terminal/server"
+	"github.com/go-zoox/zoox"
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestClient_tlsCAFile(t *testing.T) {
+	app := zoox.New()
+	app.Use(server.Middleware(server.MiddlewareOptions{
+		Config:      &server.Config{Shell: "/bin/sh"},
+		DisablePage: true,
+	}))
+	srv := httptest.NewTLSServer(app)
+	defer srv.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := "wss" + strings.TrimPrefix(srv.URL, "https") + "/ws"
+
+	untrusted := New(&Config{Server: addr, Stdout: io.Discard, Stderr: io.Discard})
+	if err := untrusted.Connect(); err == nil {
+		untrusted.Close()
+		t.Fatal("expected certificate verification to fail without the CA")
+	}
+
+	c := New(&Config{
+		Server:    addr,
+		Command:   "exit 5",
+		TLSCAFile: caFile,
+		Stdout:    io.Discard,
+		Stderr:    io.Discard,
+	})
+	exitCh := make(chan int, 1)
+	c.OnExit(func(code int, message string) { exitCh <- code })
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case code := <-exitCh:
+		if code != 5 {
+			t.Fatalf("exit code = %d, want 5", code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for exit")
+	}
+}
+
+func TestNewDialer_private(t *testing.T) {
+	dialer, err := newDialer(&Config{Proxy: "socks5://127.0.0.1:1080", TLSInsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dialer == gorilla.DefaultDialer || dialer.Proxy == nil || dialer.TLSClientConfig == nil || !dialer.TLSClientConfig.InsecureSkipVerify {
+		t.Fatalf("dialer = %+v", dialer)
+	}
+	if gorilla.DefaultDialer.TLSClientConfig != nil {
+		t.Fatal("gorilla.DefaultDialer was changed")
+	}
+}
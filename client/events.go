@@ -0,0 +1,182 @@
+package client
+
+import "sync"
+
+// ServerError is passed to OnError for TypeError frames sent by the server.
+type ServerError struct {
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+// events holds the registered lifecycle callbacks. Every On* method appends, so
+// several listeners (e.g. a logger and a UI) can observe the same client.
+// Callbacks run on the connection's event goroutine and should not block.
+type events struct {
+	mu sync.RWMutex
+
+	exits         []func(code int, message string)
+	connecteds    []func(sessionID string)
+	outputs       []func(p []byte)
+	errors        []func(err error)
+	disconnecteds []func(err error)
+	reconnecteds  []func(sessionID string)
+	heartbeats    []func()
+	inputs        []func(p []byte)
+	resizes       []func(columns, rows int)
+
+	// exit is kept so OnExit registered after the session ended still fires.
+	exit *ExitError
+}
+
+func (c *client) OnExit(cb func(code int, message string)) {
+	c.events.mu.Lock()
+	c.events.exits = append(c.events.exits, cb)
+	exit := c.events.exit
+	c.events.mu.Unlock()
+
+	if exit != nil {
+		go cb(exit.Code, exit.Message)
+	}
+}
+
+func (c *client) OnConnected(cb func(sessionID string)) {
+	c.events.mu.Lock()
+	c.events.connecteds = append(c.events.connecteds, cb)
+	c.events.mu.Unlock()
+}
+
+func (c *client) OnOutput(cb func(p []byte)) {
+	c.events.mu.Lock()
+	c.events.outputs = append(c.events.outputs, cb)
+	c.events.mu.Unlock()
+}
+
+func (c *client) OnError(cb func(err error)) {
+	c.events.mu.Lock()
+	c.events.errors = append(c.events.errors, cb)
+	c.events.mu.Unlock()
+}
+
+func (c *client) OnDisconnected(cb func(err error)) {
+	c.events.mu.Lock()
+	c.events.disconnecteds = append(c.events.disconnecteds, cb)
+	c.events.mu.Unlock()
+}
+
+func (c *client) OnReconnected(cb func(sessionID string)) {
+	c.events.mu.Lock()
+	c.events.reconnecteds = append(c.events.reconnecteds, cb)
+	c.events.mu.Unlock()
+}
+
+func (c *client) OnHeartbeat(cb func()) {
+	c.events.mu.Lock()
+	c.events.heartbeats = append(c.events.heartbeats, cb)
+	c.events.mu.Unlock()
+}
+
+func (c *client) OnInput(cb func(p []byte)) {
+	c.events.mu.Lock()
+	c.events.inputs = append(c.events.inputs, cb)
+	c.events.mu.Unlock()
+}
+
+func (c *client) OnResize(cb func(columns, rows int)) {
+	c.events.mu.Lock()
+	c.events.resizes = append(c.events.resizes, cb)
+	c.events.mu.Unlock()
+}
+
+func (c *client) emitExit(exit *ExitError) {
+	c.events.mu.Lock()
+	c.events.exit = exit
+	cbs := c.events.exits
+	c.events.mu.Unlock()
+
+	for _, cb := range cbs {
+		go cb(exit.Code, exit.Message)
+	}
+}
+
+func (c *client) emitConnected(sessionID string) {
+	c.events.mu.RLock()
+	cbs := c.events.connecteds
+	c.events.mu.RUnlock()
+
+	for _, cb := range cbs {
+		cb(sessionID)
+	}
+}
+
+func (c *client) emitOutput(p []byte) {
+	c.events.mu.RLock()
+	cbs := c.events.outputs
+	c.events.mu.RUnlock()
+
+	for _, cb := range cbs {
+		cb(p)
+	}
+}
+
+func (c *client) emitError(err error) {
+	c.events.mu.RLock()
+	cbs := c.events.errors
+	c.events.mu.RUnlock()
+
+	for _, cb := range cbs {
+		cb(err)
+	}
+}
+
+func (c *client) emitDisconnected(err error) {
+	c.events.mu.RLock()
+	cbs := c.events.disconnecteds
+	c.events.mu.RUnlock()
+
+	for _, cb := range cbs {
+		cb(err)
+	}
+}
+
+func (c *client) emitReconnected(sessionID string) {
+	c.events.mu.RLock()
+	cbs := c.events.reconnecteds
+	c.events.mu.RUnlock()
+
+	for _, cb := range cbs {
+		cb(sessionID)
+	}
+}
+
+func (c *client) emitHeartbeat() {
+	c.events.mu.RLock()
+	cbs := c.events.heartbeats
+	c.events.mu.RUnlock()
+
+	for _, cb := range cbs {
+		cb()
+	}
+}
+
+func (c *client) emitInput(p []byte) {
+	c.events.mu.RLock()
+	cbs := c.events.inputs
+	c.events.mu.RUnlock()
+
+	for _, cb := range cbs {
+		cb(p)
+	}
+}
+
+func (c *client) emitResize(columns, rows int) {
+	c.events.mu.RLock()
+	cbs := c.events.resizes
+	c.events.mu.RUnlock()
+
+	for _, cb := range cbs {
+		cb(columns, rows)
+	}
+}
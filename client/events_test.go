@@ -0,0 +1,152 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/terminal/server"
+	"github.com/go-zoox/zoox"
+)
+
+// newTestServer serves the terminal over a plain httptest server and returns its ws URL.
+func newTestServer(t *testing.T) string {
+	t.Helper()
+
+	app := zoox.New()
+	app.Use(server.Middleware(server.MiddlewareOptions{
+		Config:      &server.Config{Shell: "/bin/sh"},
+		DisablePage: true,
+	}))
+	srv := httptest.NewServer(app)
+	t.Cleanup(srv.Close)
+
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+func TestClientEvents(t *testing.T) {
+	c := New(&Config{
+		Server:  newTestServer(t),
+		Command: "echo hello-events; exit 7",
+		Stdout:  io.Discard,
+		Stderr:  io.Discard,
+	})
+
+	var mu sync.Mutex
+	var sessionID string
+	var out1, out2 strings.Builder
+	c.OnConnected(func(id string) {
+		mu.Lock()
+		sessionID = id
+		mu.Unlock()
+	})
+	c.OnOutput(func(p []byte) {
+		mu.Lock()
+		out1.Write(p)
+		mu.Unlock()
+	})
+	c.OnOutput(func(p []byte) {
+		mu.Lock()
+		out2.Write(p)
+		mu.Unlock()
+	})
+
+	exitCh := make(chan int, 2)
+	c.OnExit(func(code int, message string) { exitCh <- code })
+	c.OnExit(func(code int, message string) { exitCh <- code })
+
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case code := <-exitCh:
+			if code != 7 {
+				t.Fatalf("exit code = %d, want 7", code)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for exit")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if sessionID == "" {
+		t.Fatal("OnConnected did not receive a session id")
+	}
+	if !strings.Contains(out1.String(), "hello-events") || out1.String() != out2.String() {
+		t.Fatalf("outputs = %q / %q", out1.String(), out2.String())
+	}
+}
+
+func TestClient_ReadOnly(t *testing.T) {
+	srv := newTestServer(t)
+	for _, readOnly := range []bool{false, true} {
+		c := New(&Config{
+			Server:   srv,
+			Command:  "sleep 10",
+			ReadOnly: readOnly,
+			Stdout:   io.Discard,
+			Stderr:   io.Discard,
+		})
+		if err := c.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		if c.ReadOnly() != readOnly {
+			t.Errorf("ReadOnly() = %v, want %v", c.ReadOnly(), readOnly)
+		}
+		c.Close()
+	}
+}
+
+func TestClient_sendAfterClose(t *testing.T) {
+	c := New(&Config{
+		Server:  newTestServer(t),
+		Command: "sleep 10",
+		Stdout:  io.Discard,
+		Stderr:  io.Discard,
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Send([]byte("ls\r")) }()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("Send after Close = %v, want ErrClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send blocked after Close")
+	}
+}
+
+func TestClient_connectRefused(t *testing.T) {
+	c := New(&Config{
+		Server:    newTestServer(t),
+		Container: "no-such-driver",
+		Stdout:    io.Discard,
+		Stderr:    io.Discard,
+	})
+	defer c.Close()
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.Connect() }()
+	select {
+	case err := <-errCh:
+		var exitErr *ExitError
+		if !errors.As(err, &exitErr) || exitErr.Code != 1 {
+			t.Fatalf("Connect = %v, want an *ExitError with code 1", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Connect did not return after the server refused it")
+	}
+}
@@ -0,0 +1,324 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrExpectTimeout is returned when no pattern matched before the timeout.
+var ErrExpectTimeout = errors.New("expect timeout")
+
+// ErrExpectEOF is returned when the session exited before a pattern matched.
+var ErrExpectEOF = errors.New("expect: session exited")
+
+// DefaultTranscriptLimit is the output kept for Transcript and RawTranscript
+// unless SetTranscriptLimit changes it.
+const DefaultTranscriptLimit = 1 << 20
+
+// Match is a successful Expect result.
+type Match struct {
+	// Text is the matched text; Groups holds the submatches (Groups[0] == Text).
+	Text   string
+	Groups []string
+	// Before is the unmatched output between the previous match and this one.
+	Before string
+}
+
+// Expect drives a remote terminal the way expect(1) does: it buffers the output
+// stream with ANSI escape sequences stripped, and each Expect call consumes the
+// output up to and including its match. Consumed output is released; the
+// transcripts keep the last DefaultTranscriptLimit bytes.
+//
+//	e := client.NewExpect(c) // before c.Connect, so no output is missed
+//	c.Connect()
+//	e.Expect(`\$ $`, 5*time.Second)
+//	e.SendLine("psql")
+//	e.Expect(`postgres=#`, 10*time.Second)
+type Expect struct {
+	c Client
+
+	mu         sync.Mutex
+	raw        tailBuffer   // output, escape sequences included
+	transcript tailBuffer   // output, escape sequences stripped
+	text       bytes.Buffer // unconsumed output, escape sequences stripped
+	partial    []byte       // incomplete escape sequence carried to the next chunk
+	changed    chan struct{}
+	exited     bool
+	exitCode   int
+	exitReason string
+}
+
+// NewExpect attaches an Expect to c through OnOutput and OnExit.
+func NewExpect(c Client) *Expect {
+	e := &Expect{
+		c:          c,
+		raw:        tailBuffer{limit: DefaultTranscriptLimit},
+		transcript: tailBuffer{limit: DefaultTranscriptLimit},
+		changed:    make(chan struct{}),
+	}
+
+	c.OnOutput(e.write)
+	c.OnExit(func(code int, message string) {
+		e.mu.Lock()
+		e.exited = true
+		e.exitCode = code
+		e.exitReason = message
+		e.notifyLocked()
+		e.mu.Unlock()
+	})
+
+	return e
+}
+
+func (e *Expect) write(p []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.raw.Write(p)
+
+	data := p
+	if len(e.partial) != 0 {
+		data = append(e.partial, p...)
+	}
+	stripped, rest := stripANSI(data)
+	e.partial = append([]byte(nil), rest...)
+	e.text.Write(stripped)
+	e.transcript.Write(stripped)
+
+	e.notifyLocked()
+}
+
+func (e *Expect) notifyLocked() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// Expect waits until pattern (a regular expression) matches the unconsumed output.
+func (e *Expect) Expect(pattern string, timeout time.Duration) (*Match, error) {
+	_, m, err := e.ExpectAny(timeout, pattern)
+	return m, err
+}
+
+// ExpectAny waits until one of patterns matches and returns its index. When several
+// match, the one matching earliest in the output wins.
+func (e *Expect) ExpectAny(timeout time.Duration, patterns ...string) (int, *Match, error) {
+	if len(patterns) == 0 {
+		return -1, nil, fmt.Errorf("expect: no pattern")
+	}
+
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return -1, nil, fmt.Errorf("expect: invalid pattern %q: %s", p, err)
+		}
+		res[i] = re
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		e.mu.Lock()
+		if idx, m := e.matchLocked(res); m != nil {
+			e.mu.Unlock()
+			return idx, m, nil
+		}
+		if e.exited {
+			e.mu.Unlock()
+			return -1, nil, fmt.Errorf("%w (exit code: %d) %s", ErrExpectEOF, e.exitCode, e.exitReason)
+		}
+		changed := e.changed
+		e.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return -1, nil, fmt.Errorf("%w after %s waiting for %q, last output: %q", ErrExpectTimeout, timeout, patterns, e.tail(200))
+		}
+	}
+}
+
+func (e *Expect) matchLocked(res []*regexp.Regexp) (int, *Match) {
+	buf := e.text.Bytes()
+
+	best, bestLoc := -1, []int(nil)
+	for i, re := range res {
+		loc := re.FindSubmatchIndex(buf)
+		if loc == nil {
+			continue
+		}
+		if bestLoc == nil || loc[0] < bestLoc[0] {
+			best, bestLoc = i, loc
+		}
+	}
+	if bestLoc == nil {
+		return -1, nil
+	}
+
+	m := &Match{
+		Text:   string(buf[bestLoc[0]:bestLoc[1]]),
+		Before: string(buf[:bestLoc[0]]),
+	}
+	for i := 0; i < len(bestLoc); i += 2 {
+		if bestLoc[i] < 0 {
+			m.Groups = append(m.Groups, "")
+			continue
+		}
+		m.Groups = append(m.Groups, string(buf[bestLoc[i]:bestLoc[i+1]]))
+	}
+
+	e.text.Next(bestLoc[1])
+	return best, m
+}
+
+func (e *Expect) tail(n int) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	buf := e.text.Bytes()
+	if len(buf) > n {
+		buf = buf[len(buf)-n:]
+	}
+	return string(buf)
+}
+
+// Send writes s to the terminal as typed input.
+func (e *Expect) Send(s string) error {
+	return e.c.Send([]byte(s))
+}
+
+// SendLine writes line followed by Enter.
+func (e *Expect) SendLine(line string) error {
+	return e.c.Send([]byte(line + "\r"))
+}
+
+// SendControl sends Ctrl+key, e.g. SendControl('c') for an interrupt or
+// SendControl('d') for EOF.
+func (e *Expect) SendControl(key byte) error {
+	switch {
+	case key >= 'a' && key <= 'z':
+		key -= 'a' - 'A'
+	case key < '@' || key > '_':
+		return fmt.Errorf("expect: no control character for %q", key)
+	}
+	return e.c.Send([]byte{key & 0x1f})
+}
+
+// SetTranscriptLimit keeps the last n bytes of output for the transcripts; 0
+// turns them off.
+func (e *Expect) SetTranscriptLimit(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.raw.SetLimit(n)
+	e.transcript.SetLimit(n)
+}
+
+// Transcript returns what the terminal printed so far, escape sequences
+// stripped, up to the transcript limit.
+func (e *Expect) Transcript() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return string(e.transcript.Bytes())
+}
+
+// RawTranscript returns what the terminal printed so far, byte for byte, up to
+// the transcript limit.
+func (e *Expect) RawTranscript() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]byte(nil), e.raw.Bytes()...)
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	limit int
+	buf   []byte
+}
+
+func (b *tailBuffer) Write(p []byte) {
+	if b.limit <= 0 {
+		return
+	}
+	b.buf = append(b.buf, p...)
+	// trim in batches, so writes stay cheap
+	if len(b.buf) > 2*b.limit {
+		b.buf = append([]byte(nil), b.buf[len(b.buf)-b.limit:]...)
+	}
+}
+
+// Bytes returns the kept bytes; they are valid until the next Write.
+func (b *tailBuffer) Bytes() []byte {
+	if len(b.buf) > b.limit {
+		return b.buf[len(b.buf)-b.limit:]
+	}
+	return b.buf
+}
+
+func (b *tailBuffer) SetLimit(n int) {
+	b.limit = n
+	if n <= 0 {
+		b.buf = nil
+		return
+	}
+	b.buf = append([]byte(nil), b.Bytes()...)
+}
+
+// stripANSI removes escape sequences (CSI, OSC, two-byte ESC) and control
+// characters other than \t, \n and \r from p. An escape sequence cut off at the
+// end of p is returned as rest so it can be completed by the next chunk.
+func stripANSI(p []byte) (out, rest []byte) {
+	out = make([]byte, 0, len(p))
+	for i := 0; i < len(p); i++ {
+		b := p[i]
+		if b != 0x1b {
+			if b < 0x20 && b != '\t' && b != '\n' && b != '\r' || b == 0x7f {
+				continue
+			}
+			out = append(out, b)
+			continue
+		}
+
+		if i+1 >= len(p) {
+			return out, p[i:]
+		}
+		switch p[i+1] {
+		case '[': // CSI: parameters, then a final byte in 0x40-0x7e
+			j := i + 2
+			for j < len(p) && (p[j] < 0x40 || p[j] > 0x7e) {
+				j++
+			}
+			if j >= len(p) {
+				return out, p[i:]
+			}
+			i = j
+		case ']', 'P', '_', '^': // OSC / DCS / APC / PM: until BEL or ST (ESC \)
+			j := i + 2
+			for ; j < len(p); j++ {
+				if p[j] == 0x07 {
+					break
+				}
+				if p[j] == 0x1b && j+1 < len(p) && p[j+1] == '\\' {
+					j++
+					break
+				}
+			}
+			if j >= len(p) {
+				return out, p[i:]
+			}
+			i = j
+		case '(', ')', '*', '+', '#', '%': // charset selection and friends take one more byte
+			if i+2 >= len(p) {
+				return out, p[i:]
+			}
+			i += 2
+		default:
+			i++
+		}
+	}
+	return out, nil
+}
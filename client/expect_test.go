@@ -0,0 +1,111 @@
+package client
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestStripANSI(t *testing.T) {
+	cases := []struct {
+		in, out, rest string
+	}{
+		{"plain\r\n", "plain\r\n", ""},
+		{"\x1b[1;32mgreen\x1b[0m", "green", ""},
+		{"\x1b]0;title\x07$ ", "$ ", ""},
+		{"\x1b]133;A\x1b\\$ ", "$ ", ""},
+		{"\x1b(Bok\x07", "ok", ""},
+		{"abc\x1b[3", "abc", "\x1b[3"},
+		{"abc\x1b", "abc", "\x1b"},
+	}
+	for _, tc := range cases {
+		out, rest := stripANSI([]byte(tc.in))
+		if string(out) != tc.out || string(rest) != tc.rest {
+			t.Errorf("stripANSI(%q) = %q, %q; want %q, %q", tc.in, out, rest, tc.out, tc.rest)
+		}
+	}
+}
+
+func TestExpect_splitEscapeSequence(t *testing.T) {
+	e := &Expect{changed: make(chan struct{})}
+	e.write([]byte("hello \x1b[1"))
+	e.write([]byte(";31mworld\x1b[0m"))
+
+	m, err := e.Expect(`hello world`, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Text != "hello world" {
+		t.Fatalf("match = %q", m.Text)
+	}
+}
+
+func TestExpect_session(t *testing.T) {
+	c := New(&Config{
+		Server: newTestServer(t),
+		Stdout: io.Discard,
+		Stderr: io.Discard,
+	})
+	e := NewExpect(c)
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := e.SendLine("echo answer=$((6*7))"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := e.Expect(`answer=(\d+)\r?\n`, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Groups[1] != "42" {
+		t.Fatalf("groups = %q", m.Groups)
+	}
+
+	if _, err := e.Expect(`never-printed`, 200*time.Millisecond); !errors.Is(err, ErrExpectTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+
+	e.SendLine("echo second")
+	idx, _, err := e.ExpectAny(10*time.Second, `first\r?\n`, `second\r?\n`)
+	if err != nil || idx != 1 {
+		t.Fatalf("ExpectAny = %d, %v", idx, err)
+	}
+
+	e.SendLine("exit 3")
+	if _, err := e.Expect(`never-printed`, 10*time.Second); !errors.Is(err, ErrExpectEOF) {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	if e.Transcript() == "" || len(e.RawTranscript()) == 0 {
+		t.Fatal("empty transcript")
+	}
+}
+
+func TestExpect_releasesOutput(t *testing.T) {
+	e := &Expect{changed: make(chan struct{}), raw: tailBuffer{limit: 8}, transcript: tailBuffer{limit: 8}}
+	for i := 0; i < 1000; i++ {
+		e.write([]byte("\x1b[1mline\x1b[0m\n"))
+		if _, err := e.Expect(`line\n`, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.write([]byte("last\n"))
+
+	if e.text.String() != "last\n" {
+		t.Fatalf("unconsumed output = %q", e.text.String())
+	}
+	if got := e.Transcript(); got != "ne\nlast\n" {
+		t.Fatalf("transcript = %q", got)
+	}
+	if len(e.RawTranscript()) != 8 || cap(e.raw.buf) > 64 {
+		t.Fatalf("raw transcript = %q (cap %d)", e.RawTranscript(), cap(e.raw.buf))
+	}
+
+	e.SetTranscriptLimit(0)
+	e.write([]byte("more\n"))
+	if e.Transcript() != "" || e.RawTranscript() != nil {
+		t.Fatal("transcript kept with limit 0")
+	}
+}
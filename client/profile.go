@@ -0,0 +1,202 @@
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-zoox/fs"
+	"github.com/go-zoox/fs/type/yaml"
+)
+
+// Profile is a named set of connection defaults, so a server and its credentials
+// can be referred to by name instead of repeating flags:
+//
+//	profiles:
+//	  prod-db:
+//	    server: wss://db.example.com/ws
+//	    username: admin
+//	    password_env: PROD_DB_PASSWORD
+//	    shell: /bin/bash
+//	    image: postgres:16
+//	    env:
+//	      PGDATABASE: app
+//	    tls:
+//	      ca_file: ~/.config/terminal/prod-ca.pem
+type Profile struct {
+	Server string `yaml:"server"`
+	//
+	Username string `yaml:"username"`
+	// Password is stored in plain text; prefer one of the references below.
+	Password string `yaml:"password"`
+	// PasswordEnv names an environment variable holding the password.
+	PasswordEnv string `yaml:"password_env"`
+	// PasswordFile is a file whose first line is the password.
+	PasswordFile string `yaml:"password_file"`
+	// PasswordCommand is run with sh -c, its first output line is the password,
+	// e.g. `pass show terminal/prod-db`.
+	PasswordCommand string `yaml:"password_command"`
+	//
+	// Token is a bearer token (JWT); TokenEnv, TokenFile and TokenCommand
+	// reference it like the password fields.
+	Token        string `yaml:"token"`
+	TokenEnv     string `yaml:"token_env"`
+	TokenFile    string `yaml:"token_file"`
+	TokenCommand string `yaml:"token_command"`
+	//
+	Shell       string            `yaml:"shell"`
+	WorkDir     string            `yaml:"workdir"`
+	User        string            `yaml:"user"`
+	Image       string            `yaml:"image"`
+	Environment map[string]string `yaml:"env"`
+	//
+	Proxy string `yaml:"proxy"`
+	//
+	TLS ProfileTLS `yaml:"tls"`
+}
+
+// ProfileTLS is the TLS section of a Profile, see the TLS fields of Config.
+type ProfileTLS struct {
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+}
+
+type profilesFile struct {
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// DefaultProfilesPath is the profiles file used when none is given:
+// ~/.config/terminal/client.yml.
+func DefaultProfilesPath() string {
+	return fs.JoinConfigDir("terminal", "client.yml")
+}
+
+// LoadProfile reads the profile named name from the profiles file at path.
+func LoadProfile(path, name string) (*Profile, error) {
+	if !fs.IsExist(path) {
+		return nil, fmt.Errorf("profile %s: config file %s does not exist", name, path)
+	}
+
+	var file profilesFile
+	if err := yaml.Read(path, &file); err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %s", path, err)
+	}
+
+	profile, ok := file.Profiles[name]
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("profile %s not found in %s", name, path)
+	}
+
+	return profile, nil
+}
+
+// Apply fills the fields cfg leaves empty from the profile, so explicitly set
+// values always win. Environment variables are merged, with cfg taking precedence
+// per key. The profile password is only used together with the profile username,
+// and the profile token only when cfg has no basic credentials (Username,
+// Password or a user in the Server URL). Secrets are resolved here, so
+// password_command / token_command run only when the value is needed.
+func (p *Profile) Apply(cfg *Config) error {
+	basicAuth := cfg.Username != "" || cfg.Password != ""
+	if u, err := url.Parse(cfg.Server); err == nil && u.User != nil {
+		basicAuth = true
+	}
+
+	setDefault(&cfg.Server, p.Server)
+	setDefault(&cfg.Shell, p.Shell)
+	setDefault(&cfg.WorkDir, p.WorkDir)
+	setDefault(&cfg.User, p.User)
+	setDefault(&cfg.Image, p.Image)
+	setDefault(&cfg.Proxy, p.Proxy)
+
+	if len(p.Environment) != 0 {
+		env := map[string]string{}
+		for k, v := range p.Environment {
+			env[k] = v
+		}
+		for k, v := range cfg.Environment {
+			env[k] = v
+		}
+		cfg.Environment = env
+	}
+
+	if cfg.Password == "" && (cfg.Username == "" || cfg.Username == p.Username) {
+		password, err := resolveSecret("password", p.Password, p.PasswordEnv, p.PasswordFile, p.PasswordCommand)
+		if err != nil {
+			return err
+		}
+		cfg.Password = password
+	}
+	setDefault(&cfg.Username, p.Username)
+
+	if cfg.Token == "" && !basicAuth {
+		token, err := resolveSecret("token", p.Token, p.TokenEnv, p.TokenFile, p.TokenCommand)
+		if err != nil {
+			return err
+		}
+		cfg.Token = token
+	}
+
+	if p.TLS.InsecureSkipVerify {
+		cfg.TLSInsecureSkipVerify = true
+	}
+	setDefault(&cfg.TLSCAFile, expandHome(p.TLS.CAFile))
+	setDefault(&cfg.TLSCertFile, expandHome(p.TLS.CertFile))
+	setDefault(&cfg.TLSKeyFile, expandHome(p.TLS.KeyFile))
+
+	return nil
+}
+
+// resolveSecret returns the first configured of an inline value, an environment
+// variable, the first line of a file or the first output line of a command.
+func resolveSecret(kind, value, env, file, command string) (string, error) {
+	switch {
+	case value != "":
+		return value, nil
+	case env != "":
+		secret, ok := os.LookupEnv(env)
+		if !ok {
+			return "", fmt.Errorf("%s env %s is not set", kind, env)
+		}
+		return secret, nil
+	case file != "":
+		data, err := os.ReadFile(expandHome(file))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s file: %s", kind, err)
+		}
+		return firstLine(string(data)), nil
+	case command != "":
+		cmd := exec.Command("sh", "-c", command)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("%s command failed: %s", kind, err)
+		}
+		return firstLine(string(out)), nil
+	}
+
+	return "", nil
+}
+
+func setDefault(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return strings.TrimSuffix(line, "\r")
+}
+
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(fs.HomeDir(), path[1:])
+	}
+	return path
+}
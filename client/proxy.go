@@ -0,0 +1,93 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyFunc returns the proxy resolver for the WebSocket dial. An explicit
+// cfg.Proxy (http://, https://, socks5:// or socks5h://) wins; otherwise
+// HTTPS_PROXY / HTTP_PROXY are consulted by scheme, falling back to ALL_PROXY,
+// and NO_PROXY is honored. ProxyUsername / ProxyPassword override any userinfo
+// in the proxy URL.
+func proxyFunc(cfg *Config) (func(*http.Request) (*url.URL, error), error) {
+	if cfg.Proxy != "" {
+		u, err := parseProxyURL(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		return http.ProxyURL(withProxyAuth(u, cfg)), nil
+	}
+
+	fn := proxyFromEnvironment()
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := fn(req)
+		if err != nil || u == nil {
+			return u, err
+		}
+		return withProxyAuth(u, cfg), nil
+	}, nil
+}
+
+func withProxyAuth(u *url.URL, cfg *Config) *url.URL {
+	if cfg.ProxyUsername == "" && cfg.ProxyPassword == "" {
+		return u
+	}
+	u2 := *u
+	u2.User = url.UserPassword(cfg.ProxyUsername, cfg.ProxyPassword)
+	return &u2
+}
+
+func proxyFromEnvironment() func(*http.Request) (*url.URL, error) {
+	all := getenvAny("ALL_PROXY", "all_proxy")
+	cfg := &httpproxy.Config{
+		HTTPProxy:  getenvAny("HTTP_PROXY", "http_proxy"),
+		HTTPSProxy: getenvAny("HTTPS_PROXY", "https_proxy"),
+		NoProxy:    getenvAny("NO_PROXY", "no_proxy"),
+	}
+	if cfg.HTTPProxy == "" {
+		cfg.HTTPProxy = all
+	}
+	if cfg.HTTPSProxy == "" {
+		cfg.HTTPSProxy = all
+	}
+
+	fn := cfg.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return fn(req.URL)
+	}
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		// host:port without a scheme means an HTTP CONNECT proxy, same as curl.
+		if u2, err2 := url.Parse("http://" + raw); err2 == nil && u2.Host != "" {
+			u, err = u2, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy address %q: %s", raw, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, options: http, https, socks5, socks5h", u.Scheme)
+	}
+
+	return u, nil
+}
+
+func getenvAny(keys ...string) string {
+	for _, k := range keys {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,306 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Session log formats.
+const (
+	// SessionLogRaw writes the output byte for byte between script(1) style
+	// "Script started/done" lines; replay it with cat.
+	SessionLogRaw = "raw"
+	// SessionLogText writes timestamped lines with escape sequences stripped.
+	SessionLogText = "text"
+	// SessionLogAsciicast writes an asciicast v2 recording for asciinema play.
+	SessionLogAsciicast = "asciicast"
+)
+
+// SessionLogConfig configures a SessionLog.
+type SessionLogConfig struct {
+	// Format is SessionLogRaw (default), SessionLogText or SessionLogAsciicast.
+	Format string
+	// Input also records what was sent with Send (keystrokes, piped stdin).
+	// Not supported by SessionLogRaw.
+	Input bool
+	// Title describes the session, e.g. the server url.
+	Title string
+	// Columns and Rows are the initial terminal size for asciicast (default 80x24).
+	Columns int
+	Rows    int
+}
+
+// SessionLog keeps a local transcript of a session, independent of any
+// server-side recording:
+//
+//	l, _ := client.OpenSessionLog("session.cast", &client.SessionLogConfig{Format: client.SessionLogAsciicast})
+//	l.Attach(c)
+type SessionLog struct {
+	w   io.WriteCloser
+	cfg *SessionLogConfig
+
+	mu        sync.Mutex
+	start     time.Time
+	partial   []byte // text: incomplete escape sequence; asciicast: incomplete UTF-8 rune
+	midLine   bool   // text: the last output line is not terminated yet
+	closed    bool
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// OpenSessionLog creates the log file at path (mode 0600, transcripts may contain
+// secrets). Raw and text logs are appended to; an asciicast file holds a single
+// recording and is truncated.
+func OpenSessionLog(path string, cfg *SessionLogConfig) (*SessionLog, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if cfg.Format == SessionLogAsciicast {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session log: %s", err)
+	}
+
+	l, err := NewSessionLog(f, cfg)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewSessionLog writes the log header to w and returns the log.
+func NewSessionLog(w io.WriteCloser, cfg *SessionLogConfig) (*SessionLog, error) {
+	c := *cfg
+	if c.Format == "" {
+		c.Format = SessionLogRaw
+	}
+	if c.Columns == 0 || c.Rows == 0 {
+		c.Columns, c.Rows = 80, 24
+	}
+
+	switch c.Format {
+	case SessionLogRaw:
+		if c.Input {
+			return nil, fmt.Errorf("input logging requires the text or asciicast format")
+		}
+	case SessionLogText, SessionLogAsciicast:
+	default:
+		return nil, fmt.Errorf("invalid session log format %q, options: raw, text, asciicast", c.Format)
+	}
+
+	l := &SessionLog{
+		w:     w,
+		cfg:   &c,
+		start: time.Now(),
+	}
+	if err := l.writeHeader(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Attach records c's output, input (when enabled) and resizes, and finishes the
+// log when the session exits.
+func (l *SessionLog) Attach(c Client) {
+	c.OnOutput(l.Output)
+	if l.cfg.Input {
+		c.OnInput(l.Input)
+	}
+	c.OnResize(l.Resize)
+	c.OnExit(func(code int, message string) {
+		l.Exit(code)
+	})
+}
+
+func (l *SessionLog) writeHeader() error {
+	switch l.cfg.Format {
+	case SessionLogRaw:
+		_, err := fmt.Fprintf(l.w, "Script started on %s [SERVER=%q]\n", l.start.Format("2006-01-02 15:04:05-07:00"), l.cfg.Title)
+		return err
+	case SessionLogText:
+		_, err := fmt.Fprintf(l.w, "%s [start] %s\n", timestamp(l.start), l.cfg.Title)
+		return err
+	default:
+		header, err := json.Marshal(map[string]interface{}{
+			"version":   2,
+			"width":     l.cfg.Columns,
+			"height":    l.cfg.Rows,
+			"timestamp": l.start.Unix(),
+			"title":     l.cfg.Title,
+			"env": map[string]string{
+				"TERM":  os.Getenv("TERM"),
+				"SHELL": os.Getenv("SHELL"),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = l.w.Write(append(header, '\n'))
+		return err
+	}
+}
+
+// Output records a TypeOutput payload.
+func (l *SessionLog) Output(p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+
+	switch l.cfg.Format {
+	case SessionLogRaw:
+		l.w.Write(p)
+	case SessionLogText:
+		data := p
+		if len(l.partial) != 0 {
+			data = append(l.partial, p...)
+		}
+		stripped, rest := stripANSI(data)
+		l.partial = append([]byte(nil), rest...)
+		l.writeTextLocked(stripped)
+	default:
+		data := p
+		if len(l.partial) != 0 {
+			data = append(l.partial, p...)
+		}
+		complete, rest := splitUTF8(data)
+		l.partial = append([]byte(nil), rest...)
+		if len(complete) != 0 {
+			l.writeEventLocked("o", string(complete))
+		}
+	}
+}
+
+// Input records a payload sent to the server.
+func (l *SessionLog) Input(p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+
+	switch l.cfg.Format {
+	case SessionLogText:
+		l.endLineLocked()
+		fmt.Fprintf(l.w, "%s [input] %q\n", timestamp(time.Now()), p)
+	case SessionLogAsciicast:
+		l.writeEventLocked("i", string(p))
+	}
+}
+
+// Resize records a terminal size change (asciicast only).
+func (l *SessionLog) Resize(columns, rows int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.cfg.Format != SessionLogAsciicast {
+		return
+	}
+
+	l.writeEventLocked("r", fmt.Sprintf("%dx%d", columns, rows))
+}
+
+// Exit writes the footer with the exit code and closes the log. It is safe to
+// call more than once; only the first call has an effect.
+func (l *SessionLog) Exit(code int) error {
+	return l.close(func() {
+		now := time.Now()
+		switch l.cfg.Format {
+		case SessionLogRaw:
+			fmt.Fprintf(l.w, "\nScript done on %s [COMMAND_EXIT_CODE=\"%d\"]\n", now.Format("2006-01-02 15:04:05-07:00"), code)
+		case SessionLogText:
+			l.endLineLocked()
+			fmt.Fprintf(l.w, "%s [exit] %d\n", timestamp(now), code)
+		}
+	})
+}
+
+// Close closes the log without recording an exit code.
+func (l *SessionLog) Close() error {
+	return l.close(func() {
+		if l.cfg.Format == SessionLogText {
+			l.endLineLocked()
+		}
+	})
+}
+
+func (l *SessionLog) close(footer func()) error {
+	l.closeOnce.Do(func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		footer()
+		l.closed = true
+		l.closeErr = l.w.Close()
+	})
+	return l.closeErr
+}
+
+// writeTextLocked writes stripped output, starting every line with a timestamp.
+func (l *SessionLog) writeTextLocked(p []byte) {
+	now := timestamp(time.Now())
+
+	var buf bytes.Buffer
+	for _, b := range p {
+		if !l.midLine {
+			buf.WriteString(now)
+			buf.WriteByte(' ')
+			l.midLine = true
+		}
+
+		switch b {
+		case '\r': // the PTY ends lines with \r\n
+		case '\n':
+			buf.WriteByte('\n')
+			l.midLine = false
+		default:
+			buf.WriteByte(b)
+		}
+	}
+	l.w.Write(buf.Bytes())
+}
+
+func (l *SessionLog) endLineLocked() {
+	if l.midLine {
+		l.w.Write([]byte{'\n'})
+		l.midLine = false
+	}
+}
+
+func (l *SessionLog) writeEventLocked(kind, data string) {
+	event, err := json.Marshal([]interface{}{
+		float64(time.Since(l.start).Microseconds()) / 1e6,
+		kind,
+		data,
+	})
+	if err != nil {
+		return
+	}
+	l.w.Write(append(event, '\n'))
+}
+
+func timestamp(t time.Time) string {
+	return t.Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// splitUTF8 returns p without a trailing incomplete UTF-8 sequence, and that sequence.
+func splitUTF8(p []byte) (complete, rest []byte) {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if !utf8.FullRune(p[i:]) {
+			return p[:i], p[i:]
+		}
+		break
+	}
+	return p, nil
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type nopWriteCloser struct {
+	bytes.Buffer
+}
+
+func (w *nopWriteCloser) Close() error { return nil }
+
+func TestSessionLog_text(t *testing.T) {
+	w := &nopWriteCloser{}
+	l, err := NewSessionLog(w, &SessionLogConfig{Format: SessionLogText, Input: true, Title: "ws://host/ws"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.Output([]byte("$ \x1b[1"))
+	l.Input([]byte("ls\r"))
+	l.Output([]byte(";32mfoo\x1b[0m\r\nbar"))
+	l.Output([]byte("\r\n"))
+	l.Exit(3)
+	l.Output([]byte("after close"))
+
+	ts := `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}\S* `
+	want := regexp.MustCompile(`^` + ts + `\[start\] ws://host/ws\n` +
+		ts + `\$ \n` +
+		ts + `\[input\] "ls\\r"\n` +
+		ts + `foo\n` +
+		ts + `bar\n` +
+		ts + `\[exit\] 3\n$`)
+	if !want.MatchString(w.String()) {
+		t.Fatalf("log =\n%s", w.String())
+	}
+}
+
+func TestSessionLog_asciicast(t *testing.T) {
+	w := &nopWriteCloser{}
+	l, err := NewSessionLog(w, &SessionLogConfig{Format: SessionLogAsciicast, Columns: 120, Rows: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// "é" split across two frames must not be recorded as two invalid halves
+	l.Output([]byte("h\xc3"))
+	l.Output([]byte("\xa9llo"))
+	l.Resize(100, 30)
+	l.Close()
+
+	lines := strings.Split(strings.TrimSpace(w.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("lines = %q", lines)
+	}
+
+	var header map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatal(err)
+	}
+	if header["version"] != float64(2) || header["width"] != float64(120) || header["height"] != float64(40) {
+		t.Fatalf("header = %v", header)
+	}
+
+	var data []string
+	for _, line := range lines[1:] {
+		var event []interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, event[1].(string)+":"+event[2].(string))
+	}
+	if strings.Join(data, ",") != "o:h,o:éllo,r:100x30" {
+		t.Fatalf("events = %q", data)
+	}
+}
+
+func TestSessionLog_rawRejectsInput(t *testing.T) {
+	if _, err := NewSessionLog(&nopWriteCloser{}, &SessionLogConfig{Input: true}); err == nil {
+		t.Fatal("expected error for input logging in raw format")
+	}
+}
@@ -0,0 +1,274 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/go-zoox/cli"
+	"github.com/go-zoox/terminal/client"
+	"golang.org/x/term"
+)
+
+// clientExecCommand runs a single command remotely for use in scripts:
+//
+//	cat dump.sql | terminal client exec -s ws://host:8838/ws -c 'psql'
+//
+// Piped stdin reaches the remote command byte for byte and ends with EOF (see
+// execPipeCommand), and the process exits with the remote exit code.
+func clientExecCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "exec",
+		Usage:     "run a command non-interactively and exit with its exit code",
+		ArgsUsage: "[@profile] [command]",
+		Flags:     clientFlags(),
+		Action: func(ctx *cli.Context) (err error) {
+			cfg, err := newClientConfig(ctx)
+			if err != nil {
+				return err
+			}
+			if _, args := clientArgs(ctx); cfg.Command == "" && len(args) != 0 {
+				cfg.Command = args[0]
+			}
+			if cfg.Command == "" {
+				return fmt.Errorf("command is required, use --command, --scriptfile or pass it as argument")
+			}
+
+			servers, err := clientServers(ctx)
+			if err != nil {
+				return err
+			}
+			if len(servers) > 1 {
+				return runFanOut(ctx, cfg, servers)
+			}
+
+			stdinFd := int(os.Stdin.Fd())
+			stdinIsTTY := term.IsTerminal(stdinFd)
+			stdoutIsTTY := term.IsTerminal(int(os.Stdout.Fd()))
+
+			var ready chan struct{}
+			if !stdinIsTTY {
+				ready = make(chan struct{})
+				stdout := cfg.Stdout
+				if stdout == nil {
+					stdout = os.Stdout
+				}
+				cfg.Command = execPipeCommand(cfg.Command)
+				cfg.Stdout = &execReadyWriter{w: stdout, ready: ready}
+			}
+
+			c := client.New(cfg)
+
+			sessionLog, err := openClientSessionLog(ctx, cfg)
+			if err != nil {
+				return err
+			}
+			if sessionLog != nil {
+				sessionLog.Attach(c)
+				defer sessionLog.Close()
+			}
+
+			exitCh := make(chan int, 1)
+			c.OnExit(func(code int, message string) {
+				if message != "" {
+					os.Stderr.Write([]byte(message))
+				}
+				exitCh <- code
+			})
+			c.OnError(func(err error) {
+				var serverErr *client.ServerError
+				if errors.As(err, &serverErr) {
+					return
+				}
+				os.Stderr.Write([]byte(fmt.Sprintf("connection error: %s\n", err)))
+			})
+
+			if err := c.Connect(); err != nil {
+				return err
+			}
+			defer c.Close()
+
+			var oldState *term.State
+			if stdinIsTTY && stdoutIsTTY {
+				if err := c.Resize(); err != nil {
+					return err
+				}
+				go watchResize(c)
+
+				oldState, err = term.MakeRaw(stdinFd)
+				if err != nil {
+					return err
+				}
+				defer term.Restore(stdinFd, oldState)
+			}
+
+			go func() {
+				forward := execForwardInput
+				if !stdinIsTTY {
+					forward = func(c client.Client, r io.Reader) error {
+						return execForwardPipe(c, r, ready)
+					}
+				}
+				if err := forward(c, os.Stdin); err != nil {
+					os.Stderr.Write([]byte(fmt.Sprintf("failed to send input: %s\n", err)))
+				}
+			}()
+
+			code := <-exitCh
+			// os.Exit skips deferred calls; give the terminal back first.
+			if sessionLog != nil {
+				sessionLog.Exit(code)
+			}
+			c.Close()
+			if oldState != nil {
+				term.Restore(stdinFd, oldState)
+			}
+			os.Exit(code)
+			return nil
+		},
+	}
+}
+
+// execForwardInput copies the keys typed on the local terminal r to the remote
+// terminal.
+func execForwardInput(c client.Client, r io.Reader) error {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			// Send does not retain the slice past serialization.
+			if errS := c.Send(buf[:n]); errS != nil {
+				return errS
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// execPipeReady is printed by execPipeCommand once the remote terminal stopped
+// echoing; execReadyWriter drops it from the output.
+const execPipeReady = "\x1b]1337;TerminalExecReady\x07"
+
+// execPipeCommand wraps command for piped stdin. The remote terminal would echo
+// the input, turn \n into \r\n, cut long lines and take ^C or ^D in the data as
+// signals, so echo and output processing are turned off and the input is sent
+// base64 encoded in short lines to base64 -d, whose output is the stdin of
+// command; ^D after the last line ends it.
+func execPipeCommand(command string) string {
+	return "stty -echo -opost; printf '\\033]1337;TerminalExecReady\\007'; base64 -d | {\n" + command + "\n}"
+}
+
+// execReadyWriter writes the output to w without execPipeReady, and closes
+// ready when it was seen.
+type execReadyWriter struct {
+	w     io.Writer
+	ready chan struct{}
+
+	mu   sync.Mutex
+	seen bool
+	// held is output that may be the start of execPipeReady.
+	held []byte
+}
+
+func (e *execReadyWriter) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.seen {
+		return e.w.Write(p)
+	}
+
+	buf := append(e.held, p...)
+	e.held = nil
+	if i := bytes.Index(buf, []byte(execPipeReady)); i >= 0 {
+		e.seen = true
+		close(e.ready)
+		_, err := e.w.Write(append(buf[:i:i], buf[i+len(execPipeReady):]...))
+		return len(p), err
+	}
+
+	keep := 0
+	for n := len(execPipeReady) - 1; n > 0; n-- {
+		if bytes.HasSuffix(buf, []byte(execPipeReady[:n])) {
+			keep = n
+			break
+		}
+	}
+	e.held = append(e.held, buf[len(buf)-keep:]...)
+	_, err := e.w.Write(buf[:len(buf)-keep])
+	return len(p), err
+}
+
+// execForwardPipe sends r to the command of execPipeCommand once ready is
+// closed, base64 encoded in lines, and ends it with EOF.
+func execForwardPipe(c client.Client, r io.Reader, ready <-chan struct{}) error {
+	<-ready
+
+	lines := &execLineWriter{c: c}
+	enc := base64.NewEncoder(base64.StdEncoding, lines)
+	buf := make([]byte, 3072)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			enc.Write(buf[:n])
+			if errS := lines.Flush(); errS != nil {
+				return errS
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	enc.Close()
+	if err := lines.Flush(); err != nil {
+		return err
+	}
+	return c.SendEOF()
+}
+
+// execLineWriter collects base64 text in lines of 76 characters, well below the
+// line limit of the remote terminal, and sends them with Flush.
+type execLineWriter struct {
+	c      client.Client
+	buf    []byte
+	column int
+}
+
+func (w *execLineWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		w.buf = append(w.buf, b)
+		if w.column++; w.column == 76 {
+			w.buf = append(w.buf, '\n')
+			w.column = 0
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends the collected text, ending a partial line (base64 -d ignores
+// newlines).
+func (w *execLineWriter) Flush() error {
+	if w.column > 0 {
+		w.buf = append(w.buf, '\n')
+		w.column = 0
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.c.Send(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
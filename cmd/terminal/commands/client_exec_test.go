@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/terminal/client"
+	"github.com/go-zoox/terminal/server"
+	"github.com/go-zoox/zoox"
+)
+
+func TestExecPipe_binaryStdin(t *testing.T) {
+	app := zoox.New()
+	app.Use(server.Middleware(server.MiddlewareOptions{
+		Config:      &server.Config{Shell: "/bin/sh"},
+		DisablePage: true,
+	}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	// every byte value, ^C and ^D, \r\n and a line longer than the terminal's limit
+	var input bytes.Buffer
+	for i := 0; i < 256; i++ {
+		input.WriteByte(byte(i))
+	}
+	input.WriteString("\x03\x04\r\n")
+	input.WriteString(strings.Repeat("x", 5000))
+
+	var stdout syncBuffer
+	ready := make(chan struct{})
+	c := client.New(&client.Config{
+		Server:  "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws",
+		Command: execPipeCommand("cat"),
+		Stdout:  &execReadyWriter{w: &stdout, ready: ready},
+		Stderr:  &stdout,
+	})
+	exitCh := make(chan int, 1)
+	c.OnExit(func(code int, message string) { exitCh <- code })
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	go execForwardPipe(c, bytes.NewReader(input.Bytes()), ready)
+
+	select {
+	case code := <-exitCh:
+		if code != 0 {
+			t.Fatalf("exit code = %d, output %q", code, stdout.Bytes())
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out, output %q", stdout.Bytes())
+	}
+	if out := stdout.Bytes(); !bytes.Equal(out, input.Bytes()) {
+		t.Fatalf("output (%d bytes) = %q", len(out), out)
+	}
+}
@@ -0,0 +1,256 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-zoox/cli"
+	"github.com/go-zoox/terminal/client"
+)
+
+// fanOutResult is one row of the summary table.
+type fanOutResult struct {
+	host     string
+	code     int
+	duration time.Duration
+	err      error
+}
+
+// runFanOut runs cfg.Command on every server concurrently (bounded by --concurrency),
+// writes each host's output prefixed or grouped per --output, prints a summary
+// table and exits 0 only when every host exited 0.
+func runFanOut(ctx *cli.Context, cfg *client.Config, servers []string) error {
+	if cfg.Command == "" {
+		return fmt.Errorf("running on multiple servers requires --command or --scriptfile")
+	}
+
+	if ctx.String("log-file") != "" {
+		return fmt.Errorf("--log-file is not supported when running on multiple servers")
+	}
+
+	mode := ctx.String("output")
+	if mode != "prefix" && mode != "group" {
+		return fmt.Errorf("invalid --output %q, options: prefix, group", mode)
+	}
+
+	timeout, err := time.ParseDuration(ctx.String("host-timeout"))
+	if err != nil {
+		return fmt.Errorf("invalid --host-timeout: %w", err)
+	}
+
+	concurrency := ctx.Int("concurrency")
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	var outMu sync.Mutex
+	results := make([]*fanOutResult, len(servers))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, server := range servers {
+		wg.Add(1)
+		go func(i int, server string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			host := fanOutHostLabel(server)
+			hostCfg := *cfg
+			hostCfg.Server = server
+
+			var stdout, stderr io.Writer
+			var group *syncBuffer
+			if mode == "prefix" {
+				stdout = newPrefixWriter(&outMu, os.Stdout, host)
+				stderr = newPrefixWriter(&outMu, os.Stderr, host)
+			} else {
+				group = &syncBuffer{}
+				stdout, stderr = group, group
+			}
+			hostCfg.Stdout = stdout
+			hostCfg.Stderr = stderr
+
+			start := time.Now()
+			code, err := runOnHost(&hostCfg, timeout)
+			results[i] = &fanOutResult{
+				host:     host,
+				code:     code,
+				duration: time.Since(start),
+				err:      err,
+			}
+
+			if pw, ok := stdout.(*prefixWriter); ok {
+				pw.Flush()
+				stderr.(*prefixWriter).Flush()
+			}
+			if group != nil {
+				out := group.Bytes()
+				outMu.Lock()
+				fmt.Fprintf(os.Stdout, "==> %s <==\n", host)
+				os.Stdout.Write(out)
+				if n := len(out); n > 0 && out[n-1] != '\n' {
+					os.Stdout.Write([]byte("\n"))
+				}
+				outMu.Unlock()
+			}
+		}(i, server)
+	}
+	wg.Wait()
+
+	failed := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "HOST\tEXIT\tDURATION\tERROR")
+	for _, r := range results {
+		errText := ""
+		if r.err != nil {
+			errText = r.err.Error()
+		}
+		if r.code != 0 || r.err != nil {
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", r.host, r.code, r.duration.Round(time.Millisecond), errText)
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+	return nil
+}
+
+// runOnHost runs cfg.Command on one server and returns its exit code.
+func runOnHost(cfg *client.Config, timeout time.Duration) (int, error) {
+	c := client.New(cfg)
+
+	exitCh := make(chan int, 1)
+	c.OnExit(func(code int, message string) {
+		if message != "" {
+			cfg.Stderr.Write([]byte(message))
+		}
+		exitCh <- code
+	})
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+
+	connectErr := make(chan error, 1)
+	go func() {
+		connectErr <- c.Connect()
+	}()
+
+	select {
+	case err := <-connectErr:
+		if err != nil {
+			return client.ExitCodeConnectionClosed, err
+		}
+	case <-timeoutCh:
+		// stops the dial or the wait for the server, and drops the connection
+		c.Close()
+		return client.ExitCodeTransportFailure, fmt.Errorf("timeout after %s", timeout)
+	}
+	defer c.Close()
+
+	// the command gets no stdin, like `ssh host cmd </dev/null`
+	if err := c.SendEOF(); err != nil {
+		return client.ExitCodeConnectionClosed, err
+	}
+
+	select {
+	case code := <-exitCh:
+		return code, nil
+	case <-timeoutCh:
+		return client.ExitCodeTransportFailure, fmt.Errorf("timeout after %s", timeout)
+	}
+}
+
+func fanOutHostLabel(server string) string {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return server
+	}
+	return u.Host
+}
+
+// prefixWriter writes complete lines as "[host] line" to w, serialized by mu so
+// concurrent hosts do not interleave within a line.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix []byte
+
+	bufMu sync.Mutex
+	buf   []byte
+}
+
+func newPrefixWriter(mu *sync.Mutex, w io.Writer, host string) *prefixWriter {
+	return &prefixWriter{
+		mu:     mu,
+		w:      w,
+		prefix: []byte(fmt.Sprintf("[%s] ", host)),
+	}
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.bufMu.Lock()
+	defer p.bufMu.Unlock()
+
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes a trailing partial line.
+func (p *prefixWriter) Flush() {
+	p.bufMu.Lock()
+	defer p.bufMu.Unlock()
+
+	if len(p.buf) == 0 {
+		return
+	}
+	p.writeLine(append(p.buf, '\n'))
+	p.buf = nil
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.w.Write(p.prefix)
+	p.w.Write(line)
+}
+
+// syncBuffer collects a host's output in group mode; output and exit callbacks
+// write from different goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
@@ -0,0 +1,47 @@
+package commands
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/terminal/client"
+	gorilla "github.com/gorilla/websocket"
+)
+
+func TestRunOnHost_timeoutClosesConnection(t *testing.T) {
+	// a server that never acknowledges the connect
+	closed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&gorilla.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	code, err := runOnHost(&client.Config{
+		Server:  "ws" + strings.TrimPrefix(srv.URL, "http"),
+		Command: "true",
+		Stdout:  io.Discard,
+		Stderr:  io.Discard,
+	}, 200*time.Millisecond)
+	if code != client.ExitCodeTransportFailure || err == nil {
+		t.Fatalf("runOnHost() = %d, %v", code, err)
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection of the timed out host left open")
+	}
+}
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-zoox/cli"
@@ -20,6 +21,21 @@ func RegistryServer(app *cli.MultipleProgram) {
 				EnvVars: []string{"PORT"},
 				Value:   8838,
 			},
+			&cli.StringFlag{
+				Name:    "tls-cert",
+				Usage:   "TLS certificate file (PEM) to serve https/wss, reloaded when it changes",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_TLS_CERT"},
+			},
+			&cli.StringFlag{
+				Name:    "tls-key",
+				Usage:   "TLS private key file (PEM), reloaded when it changes",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_TLS_KEY"},
+			},
+			&cli.StringFlag{
+				Name:    "tls-client-ca",
+				Usage:   "CA file (PEM) to require and verify client certificates (mutual TLS)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_TLS_CLIENT_CA"},
+			},
 			&cli.StringFlag{
 				Name:    "shell",
 				Usage:   "specify terminal shell",
@@ -53,6 +69,69 @@ func RegistryServer(app *cli.MultipleProgram) {
 				Usage:   "Password for Basic Auth",
 				EnvVars: []string{"GO_ZOOX_TERMINAL_PASSWORD"},
 			},
+			&cli.StringFlag{
+				Name:    "htpasswd-file",
+				Usage:   "htpasswd file (bcrypt) for multi-user Basic Auth, entries may add per-user session defaults: user:hash:user=alice,driver=host,image=...,shell=...,workdir=...,read_only=true,admin=true",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_HTPASSWD_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "jwt-secret",
+				Usage:   "HS256 secret for bearer token (JWT) auth",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_JWT_SECRET"},
+			},
+			&cli.StringFlag{
+				Name:    "jwt-jwks-file",
+				Usage:   "JWKS file with RSA/EC public keys for RS256/ES256 bearer token (JWT) auth",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_JWT_JWKS_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "jwt-issuer",
+				Usage:   "required JWT iss claim",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_JWT_ISSUER"},
+			},
+			&cli.StringFlag{
+				Name:    "jwt-audience",
+				Usage:   "required JWT aud claim",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_JWT_AUDIENCE"},
+			},
+			&cli.IntFlag{
+				Name:    "lockout-max-failures",
+				Usage:   "failed logins of a username from an IP before it is locked out",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_LOCKOUT_MAX_FAILURES"},
+				Value:   5,
+			},
+			&cli.IntFlag{
+				Name:    "lockout-max-ip-failures",
+				Usage:   "failed logins from an IP over all usernames before the IP is locked out, default: 4 × --lockout-max-failures",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_LOCKOUT_MAX_IP_FAILURES"},
+			},
+			&cli.IntFlag{
+				Name:    "lockout-max-user-failures",
+				Usage:   "failed logins of a username from all IPs before it is locked out, default: 4 × --lockout-max-failures",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_LOCKOUT_MAX_USER_FAILURES"},
+			},
+			&cli.StringFlag{
+				Name:    "lockout-duration",
+				Usage:   "first lockout, doubled with every further failure (e.g. 30s, 1m)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_LOCKOUT_DURATION"},
+				Value:   "30s",
+			},
+			&cli.StringFlag{
+				Name:    "lockout-max-duration",
+				Usage:   "longest lockout (e.g. 15m, 1h)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_LOCKOUT_MAX_DURATION"},
+				Value:   "15m",
+			},
+			&cli.BoolFlag{
+				Name:    "disable-lockout",
+				Usage:   "disable the brute-force lockout of failed logins",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_DISABLE_LOCKOUT"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "allowed-origin",
+				Usage:   "origin browsers may open the terminal from, e.g. https://*.example.com (repeatable), default: same-origin",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_ALLOWED_ORIGINS"},
+			},
 			&cli.StringFlag{
 				Name:    "driver",
 				Usage:   "Driver runtime, options: host, docker, kubernetes, ssh, default: host",
@@ -65,6 +144,62 @@ func RegistryServer(app *cli.MultipleProgram) {
 				EnvVars: []string{"GO_ZOOX_TERMINAL_DRIVER_IMAGE"},
 				Value:   "whatwewant/zmicro:v1",
 			},
+			&cli.StringSliceFlag{
+				Name:    "driver-plugin-dir",
+				Usage:   "directory with driver plugin executables (terminal-driver-<name>), default: PATH for --driver only (repeatable)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_DRIVER_PLUGIN_DIRS"},
+			},
+			&cli.StringFlag{
+				Name:    "ssh-host",
+				Usage:   "target host of the ssh driver",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_HOST"},
+			},
+			&cli.IntFlag{
+				Name:    "ssh-port",
+				Usage:   "target port of the ssh driver",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_PORT"},
+				Value:   22,
+			},
+			&cli.StringFlag{
+				Name:    "ssh-user",
+				Usage:   "login user of the ssh driver, default: --user",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_USER"},
+			},
+			&cli.StringFlag{
+				Name:    "ssh-password",
+				Usage:   "login password of the ssh driver",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_PASSWORD"},
+			},
+			&cli.StringFlag{
+				Name:    "ssh-private-key-file",
+				Usage:   "private key file of the ssh driver",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_PRIVATE_KEY_FILE"},
+			},
+			&cli.StringFlag{
+				Name:    "ssh-private-key-passphrase",
+				Usage:   "passphrase of the ssh private key",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_PRIVATE_KEY_PASSPHRASE"},
+			},
+			&cli.StringFlag{
+				Name:    "ssh-known-hosts",
+				Usage:   "known_hosts file to verify ssh host keys, default: ~/.ssh/known_hosts",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_KNOWN_HOSTS"},
+			},
+			&cli.BoolFlag{
+				Name:    "ssh-insecure-ignore-host-key",
+				Usage:   "do not verify ssh host keys (testing only)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_INSECURE_IGNORE_HOST_KEY"},
+			},
+			&cli.StringFlag{
+				Name:    "ssh-jump-host",
+				Usage:   "jump host ([user@]host[:port]) to reach the ssh target through",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_JUMP_HOST"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "ssh-allowed-host",
+				Usage:   "host or host:port clients may choose as ssh target, * wildcards allowed (repeatable)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SSH_ALLOWED_HOSTS"},
+			},
 			&cli.BoolFlag{
 				Name:    "disable-history",
 				Usage:   "Disable history",
@@ -82,22 +217,234 @@ func RegistryServer(app *cli.MultipleProgram) {
 				EnvVars: []string{"GO_ZOOX_TERMINAL_SESSION_IDLE_RETENTION"},
 				Value:   "60s",
 			},
+			&cli.StringFlag{
+				Name:    "audit-log",
+				Usage:   "write an audit log of auth, connect, resize, disconnect and exit events (JSON lines) to this file",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_AUDIT_LOG"},
+			},
+			&cli.IntFlag{
+				Name:    "audit-log-max-size",
+				Usage:   "rotate the audit log when it reaches this size in MiB",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_AUDIT_LOG_MAX_SIZE"},
+				Value:   100,
+			},
+			&cli.IntFlag{
+				Name:    "audit-log-max-backups",
+				Usage:   "number of rotated audit log files to keep",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_AUDIT_LOG_MAX_BACKUPS"},
+				Value:   5,
+			},
+			&cli.BoolFlag{
+				Name:    "command-log",
+				Usage:   "log the command lines submitted in sessions (server log and audit log)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_COMMAND_LOG"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "command-deny",
+				Usage:   "block command lines matching this regular expression (repeatable)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_COMMAND_DENY"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "command-allow",
+				Usage:   "only run command lines matching one of these regular expressions (repeatable)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_COMMAND_ALLOW"},
+			},
+			&cli.BoolFlag{
+				Name:    "redact-secrets",
+				Usage:   "mask well-known secrets (API tokens, JWTs, private keys, password=...) in the reconnect replay and logs",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_REDACT_SECRETS"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "redact",
+				Usage:   "also mask output matching this regular expression, only its first capture group when it has one (repeatable)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_REDACT"},
+			},
+			&cli.BoolFlag{
+				Name:    "redact-client-output",
+				Usage:   "send the redacted output to clients too",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_REDACT_CLIENT_OUTPUT"},
+			},
+			&cli.BoolFlag{
+				Name:    "sandbox",
+				Usage:   "run host sessions in a sandbox: new PID and mount namespaces, private /tmp, no capabilities, non-root --user; other drivers are refused (Linux, needs root)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX"},
+			},
+			&cli.StringFlag{
+				Name:    "sandbox-cpu-time",
+				Usage:   "CPU time limit of each sandboxed process (e.g. 10m), 0 means no limit",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX_CPU_TIME"},
+				Value:   "0",
+			},
+			&cli.IntFlag{
+				Name:    "sandbox-memory",
+				Usage:   "address space limit of each sandboxed process in MiB, 0 means no limit",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX_MEMORY"},
+			},
+			&cli.IntFlag{
+				Name:    "sandbox-open-files",
+				Usage:   "open files limit of each sandboxed process, 0 means no limit",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX_OPEN_FILES"},
+			},
+			&cli.IntFlag{
+				Name:    "sandbox-processes",
+				Usage:   "process limit of the sandboxed user, 0 means no limit",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX_PROCESSES"},
+			},
+			&cli.BoolFlag{
+				Name:    "sandbox-isolate-network",
+				Usage:   "give each sandbox its own network namespace with only loopback",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX_ISOLATE_NETWORK"},
+			},
+			&cli.BoolFlag{
+				Name:    "sandbox-read-only-root",
+				Usage:   "make the filesystem read-only in the sandbox, except /tmp, /dev and --sandbox-writable",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX_READ_ONLY_ROOT"},
+			},
+			&cli.StringSliceFlag{
+				Name:    "sandbox-writable",
+				Usage:   "path that stays writable with --sandbox-read-only-root (repeatable)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX_WRITABLE"},
+			},
+			&cli.IntFlag{
+				Name:    "sandbox-tmp-size",
+				Usage:   "size of the private /tmp in MiB",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SANDBOX_TMP_SIZE"},
+				Value:   64,
+			},
+			&cli.BoolFlag{
+				Name:    "ephemeral-home",
+				Usage:   "give each host session a fresh temporary directory as HOME and workdir, removed when the session ends",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_EPHEMERAL_HOME"},
+			},
+			&cli.StringFlag{
+				Name:    "ephemeral-home-dir",
+				Usage:   "directory to create the session homes in, default: the system temp dir",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_EPHEMERAL_HOME_DIR"},
+			},
+			&cli.StringFlag{
+				Name:    "ephemeral-home-template",
+				Usage:   "directory copied into each session home (e.g. dotfiles)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_EPHEMERAL_HOME_TEMPLATE"},
+			},
+			&cli.BoolFlag{
+				Name:    "ephemeral-home-keep-on-failure",
+				Usage:   "keep the session home when the session exits with a non-zero status, for debugging",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_EPHEMERAL_HOME_KEEP_ON_FAILURE"},
+			},
+			&cli.StringFlag{
+				Name:    "session-env-prefix",
+				Usage:   "prefix of the session context variables (SESSION_ID, USER, AUTH_METHOD, REMOTE_ADDR, USER_AGENT, SERVER_VERSION)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SESSION_ENV_PREFIX"},
+				Value:   "TERMINAL_",
+			},
+			&cli.StringSliceFlag{
+				Name:    "session-env",
+				Usage:   "extra session variable NAME=TEMPLATE, a Go template of the session context, e.g. GIT_AUTHOR_NAME={{.User}} (repeatable)",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SESSION_ENV"},
+			},
+			&cli.BoolFlag{
+				Name:    "shell-integration",
+				Usage:   "start interactive bash sessions with prompt and command markers (OSC 133) to track each command's exit code and duration",
+				EnvVars: []string{"GO_ZOOX_TERMINAL_SHELL_INTEGRATION"},
+			},
 		},
 		Action: func(ctx *cli.Context) (err error) {
 			idleRetention, err := time.ParseDuration(ctx.String("session-idle-retention"))
 			if err != nil {
 				return fmt.Errorf("invalid --session-idle-retention: %w", err)
 			}
+			lockoutDuration, err := time.ParseDuration(ctx.String("lockout-duration"))
+			if err != nil {
+				return fmt.Errorf("invalid --lockout-duration: %w", err)
+			}
+			lockoutMaxDuration, err := time.ParseDuration(ctx.String("lockout-max-duration"))
+			if err != nil {
+				return fmt.Errorf("invalid --lockout-max-duration: %w", err)
+			}
+			sessionEnv := map[string]string{}
+			for _, kv := range ctx.StringSlice("session-env") {
+				name, value, ok := strings.Cut(kv, "=")
+				if !ok {
+					return fmt.Errorf("invalid --session-env %q: want NAME=TEMPLATE", kv)
+				}
+				sessionEnv[name] = value
+			}
+			var sandbox *server.SandboxConfig
+			if ctx.Bool("ephemeral-home") && ctx.String("user") != "" && !ctx.Bool("sandbox") {
+				return fmt.Errorf("--ephemeral-home with --user requires --sandbox")
+			}
+			if ctx.Bool("sandbox") {
+				cpuTime, err := time.ParseDuration(ctx.String("sandbox-cpu-time"))
+				if err != nil {
+					return fmt.Errorf("invalid --sandbox-cpu-time: %w", err)
+				}
+				sandbox = &server.SandboxConfig{
+					CPUTime:        cpuTime,
+					Memory:         int64(ctx.Int("sandbox-memory")) * 1024 * 1024,
+					OpenFiles:      uint64(ctx.Int("sandbox-open-files")),
+					Processes:      uint64(ctx.Int("sandbox-processes")),
+					IsolateNetwork: ctx.Bool("sandbox-isolate-network"),
+					ReadOnlyRoot:   ctx.Bool("sandbox-read-only-root"),
+					WritablePaths:  ctx.StringSlice("sandbox-writable"),
+					TmpSize:        int64(ctx.Int("sandbox-tmp-size")) * 1024 * 1024,
+				}
+			}
 			s := server.NewHTTPServer(&server.HTTPServerConfig{
-				Port:     ctx.Int64("port"),
+				Port: ctx.Int64("port"),
+				//
+				TLSCertFile:     ctx.String("tls-cert"),
+				TLSKeyFile:      ctx.String("tls-key"),
+				TLSClientCAFile: ctx.String("tls-client-ca"),
+				//
 				Shell:    ctx.String("shell"),
 				User:     ctx.String("user"),
 				Username: ctx.String("username"),
 				Password: ctx.String("password"),
 				//
+				HTPasswdFile: ctx.String("htpasswd-file"),
+				//
+				JWTSecret:   ctx.String("jwt-secret"),
+				JWTJWKSFile: ctx.String("jwt-jwks-file"),
+				JWTIssuer:   ctx.String("jwt-issuer"),
+				JWTAudience: ctx.String("jwt-audience"),
+				//
+				Lockout: &server.LockoutConfig{
+					Disabled:        ctx.Bool("disable-lockout"),
+					MaxFailures:     ctx.Int("lockout-max-failures"),
+					MaxIPFailures:   ctx.Int("lockout-max-ip-failures"),
+					MaxUserFailures: ctx.Int("lockout-max-user-failures"),
+					Lockout:         lockoutDuration,
+					MaxLockout:      lockoutMaxDuration,
+				},
+				//
+				AllowedOrigins: ctx.StringSlice("allowed-origin"),
+				//
 				Driver:      ctx.String("driver"),
 				DriverImage: ctx.String("driver-image"),
 				//
+				DriverPluginDirs: ctx.StringSlice("driver-plugin-dir"),
+				Sandbox:          sandbox,
+				//
+				EphemeralHome:              ctx.Bool("ephemeral-home"),
+				EphemeralHomeDir:           ctx.String("ephemeral-home-dir"),
+				EphemeralHomeTemplate:      ctx.String("ephemeral-home-template"),
+				EphemeralHomeKeepOnFailure: ctx.Bool("ephemeral-home-keep-on-failure"),
+				//
+				SessionEnvPrefix: ctx.String("session-env-prefix"),
+				SessionEnv:       sessionEnv,
+				ShellIntegration: ctx.Bool("shell-integration"),
+				//
+				SSHHost:                  ctx.String("ssh-host"),
+				SSHPort:                  ctx.Int("ssh-port"),
+				SSHUser:                  ctx.String("ssh-user"),
+				SSHPassword:              ctx.String("ssh-password"),
+				SSHPrivateKeyFile:        ctx.String("ssh-private-key-file"),
+				SSHPrivateKeyPassphrase:  ctx.String("ssh-private-key-passphrase"),
+				SSHKnownHostsFile:        ctx.String("ssh-known-hosts"),
+				SSHInsecureIgnoreHostKey: ctx.Bool("ssh-insecure-ignore-host-key"),
+				SSHJumpHost:              ctx.String("ssh-jump-host"),
+				SSHAllowedHosts:          ctx.StringSlice("ssh-allowed-host"),
+				//
 				InitCommand: ctx.String("init-command"),
 				WorkDir:     ctx.String("workdir"),
 				//
@@ -106,6 +453,18 @@ func RegistryServer(app *cli.MultipleProgram) {
 				ReadOnly: ctx.Bool("read-only"),
 				//
 				SessionIdleRetention: idleRetention,
+				//
+				AuditLogFile:       ctx.String("audit-log"),
+				AuditLogMaxSize:    int64(ctx.Int("audit-log-max-size")) * 1024 * 1024,
+				AuditLogMaxBackups: ctx.Int("audit-log-max-backups"),
+				//
+				CommandLog:           ctx.Bool("command-log"),
+				CommandDenyPatterns:  ctx.StringSlice("command-deny"),
+				CommandAllowPatterns: ctx.StringSlice("command-allow"),
+				//
+				RedactSecrets:      ctx.Bool("redact-secrets"),
+				RedactPatterns:     ctx.StringSlice("redact"),
+				RedactClientOutput: ctx.Bool("redact-client-output"),
 			})
 
 			return s.Run()
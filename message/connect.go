@@ -15,6 +15,17 @@ type Connect struct {
 	Password string `json:"password"`
 	//
 	SessionID string `json:"session_id"`
+	//
+	// ReadOnly asks for a read-only connection; in the server's reply it tells
+	// whether the connection is read-only.
+	ReadOnly bool `json:"read_only"`
+	//
+	// SSH target of the ssh driver; the server decides which hosts are allowed.
+	SSHHost       string `json:"ssh_host"`
+	SSHPort       int    `json:"ssh_port"`
+	SSHUser       string `json:"ssh_user"`
+	SSHPassword   string `json:"ssh_password"`
+	SSHPrivateKey string `json:"ssh_private_key"`
 }
 
 func (m *Message) Connect() *Connect {
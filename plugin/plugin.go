@@ -0,0 +1,156 @@
+// Package plugin implements external driver plugins for the terminal server.
+//
+// For a driver name that is neither built in nor registered in
+// server.Config.Drivers, the server runs the executable ExecutablePrefix+name
+// (e.g. terminal-driver-nspawn) and talks to it over stdin and stdout. Each
+// frame is a 4-byte big-endian length followed by a serialized message.Message:
+//
+//  1. the server sends TypeConnect with the session's settings,
+//  2. the plugin answers TypeConnect once the terminal runs, or TypeError and exits,
+//  3. the server sends TypeKey (input) and TypeResize, the plugin sends TypeOutput,
+//  4. the plugin sends TypeExit when the terminal exits, then exits itself.
+//
+// The server closes stdin to end the session early. Plugin stderr goes to the
+// server log. Serve implements the plugin side for a terminal.Terminal.
+package plugin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-zoox/command/errors"
+	"github.com/go-zoox/command/terminal"
+	"github.com/go-zoox/terminal/message"
+)
+
+// ExecutablePrefix is prepended to the driver name to find the plugin executable.
+const ExecutablePrefix = "terminal-driver-"
+
+// MaxFrameSize bounds the size of a frame.
+const MaxFrameSize = 1 << 20
+
+// WriteMessage serializes msg and writes it as one frame.
+func WriteMessage(w io.Writer, msg *message.Message) error {
+	if err := msg.Serialize(); err != nil {
+		return err
+	}
+
+	frame := make([]byte, 4+len(msg.Msg()))
+	binary.BigEndian.PutUint32(frame, uint32(len(msg.Msg())))
+	copy(frame[4:], msg.Msg())
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadMessage reads one frame. It returns io.EOF when r ends between frames.
+func ReadMessage(r io.Reader) (*message.Message, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n == 0 || n > MaxFrameSize {
+		return nil, fmt.Errorf("invalid plugin frame size %d", n)
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return message.Deserialize(raw)
+}
+
+// ConnectFunc starts the terminal of a session.
+type ConnectFunc func(cfg *message.Connect) (terminal.Terminal, error)
+
+// Serve runs the plugin side of the protocol on stdin and stdout. Call it from
+// the plugin's main:
+//
+//	func main() {
+//		if err := plugin.Serve(connect); err != nil {
+//			os.Exit(1)
+//		}
+//	}
+func Serve(connect ConnectFunc) error {
+	return ServeIO(os.Stdin, os.Stdout, connect)
+}
+
+// ServeIO runs one session on r and w: it reads the connect request, starts the
+// terminal with connect and relays input, resizes, output and the exit code
+// until the terminal exits or r ends.
+func ServeIO(r io.Reader, w io.Writer, connect ConnectFunc) error {
+	msg, err := ReadMessage(r)
+	if err != nil {
+		return err
+	}
+	if msg.Type() != message.TypeConnect {
+		return fmt.Errorf("expected connect, got message type %q", msg.Type())
+	}
+
+	session, err := connect(msg.Connect())
+	if err != nil {
+		reply := &message.Message{}
+		reply.SetType(message.TypeError)
+		reply.SetError(&message.Error{Message: err.Error()})
+		WriteMessage(w, reply)
+		return err
+	}
+
+	ack := &message.Message{}
+	ack.SetType(message.TypeConnect)
+	ack.SetConnect(&message.Connect{})
+	if err := WriteMessage(w, ack); err != nil {
+		session.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			msg, err := ReadMessage(r)
+			if err != nil {
+				// the server is gone or ended the session
+				session.Close()
+				return
+			}
+
+			switch msg.Type() {
+			case message.TypeKey:
+				session.Write(msg.Key())
+			case message.TypeResize:
+				session.Resize(msg.Resize().Rows, msg.Resize().Columns)
+			}
+		}
+	}()
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := session.Read(buf)
+		if n > 0 {
+			output := &message.Message{}
+			output.SetType(message.TypeOutput)
+			output.SetOutput(buf[:n])
+			if err := WriteMessage(w, output); err != nil {
+				session.Close()
+				return err
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	exit := &message.Exit{}
+	if err := session.Wait(); err != nil {
+		exit.Code = -1
+		exit.Message = err.Error()
+		if exitErr, ok := err.(*errors.ExitError); ok {
+			exit.Code = exitErr.ExitCode()
+		}
+	}
+	reply := &message.Message{}
+	reply.SetType(message.TypeExit)
+	reply.SetExit(exit)
+	return WriteMessage(w, reply)
+}
@@ -0,0 +1,110 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/go-zoox/command/terminal"
+	"github.com/go-zoox/terminal/message"
+)
+
+func TestReadMessage(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	msg := &message.Message{}
+	msg.SetType(message.TypeResize)
+	msg.SetResize(&message.Resize{Rows: 40, Columns: 120})
+	if err := WriteMessage(&buf, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type() != message.TypeResize || got.Resize().Rows != 40 || got.Resize().Columns != 120 {
+		t.Fatalf("message = %+v", got.Resize())
+	}
+	if _, err := ReadMessage(&buf); err != io.EOF {
+		t.Fatalf("ReadMessage at end = %v, want io.EOF", err)
+	}
+
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], MaxFrameSize+1)
+	if _, err := ReadMessage(bytes.NewReader(size[:])); err == nil {
+		t.Fatal("oversized frame accepted")
+	}
+}
+
+// pipeTerminal outputs what is written to it and exits when closed.
+type pipeTerminal struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func (p *pipeTerminal) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p *pipeTerminal) Write(b []byte) (int, error) { return p.w.Write(b) }
+func (p *pipeTerminal) Close() error                { return p.w.Close() }
+func (p *pipeTerminal) Resize(rows, cols int) error { return nil }
+func (p *pipeTerminal) ExitCode() int               { return 0 }
+func (p *pipeTerminal) Wait() error                 { return nil }
+
+func TestServeIO(t *testing.T) {
+	t.Parallel()
+
+	serverIn, pluginOut := io.Pipe()
+	pluginIn, serverOut := io.Pipe()
+
+	var shell string
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeIO(pluginIn, pluginOut, func(cfg *message.Connect) (terminal.Terminal, error) {
+			shell = cfg.Shell
+			r, w := io.Pipe()
+			return &pipeTerminal{r: r, w: w}, nil
+		})
+	}()
+
+	send := func(typ message.Type, key string) {
+		msg := &message.Message{}
+		msg.SetType(typ)
+		msg.SetConnect(&message.Connect{Shell: "/bin/zsh"})
+		msg.SetKey([]byte(key))
+		if err := WriteMessage(serverOut, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(want message.Type) *message.Message {
+		msg, err := ReadMessage(serverIn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type() != want {
+			t.Fatalf("message type = %q, want %q", msg.Type(), want)
+		}
+		return msg
+	}
+
+	send(message.TypeConnect, "")
+	read(message.TypeConnect)
+	if shell != "/bin/zsh" {
+		t.Fatalf("shell = %q", shell)
+	}
+
+	send(message.TypeKey, "hi")
+	if out := read(message.TypeOutput); string(out.Output()) != "hi" {
+		t.Fatalf("output = %q", out.Output())
+	}
+
+	// closing the plugin's stdin ends the session
+	serverOut.Close()
+	if exit := read(message.TypeExit); exit.Exit().Code != 0 {
+		t.Fatalf("exit = %+v", exit.Exit())
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
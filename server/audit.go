@@ -0,0 +1,235 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/logger"
+)
+
+// Audit event types.
+const (
+	AuditEventAuthSuccess = "auth_success"
+	AuditEventAuthFailure = "auth_failure"
+	AuditEventConnect     = "connect"
+	AuditEventReconnect   = "reconnect"
+	AuditEventShareJoin   = "share_join"
+	AuditEventResize      = "resize"
+	AuditEventDisconnect  = "disconnect"
+	AuditEventIdleEvict   = "idle_evict"
+	AuditEventExit        = "exit"
+	AuditEventCommand     = "command"
+	// AuditEventShellCommand is a command finished in the session, as marked
+	// by shell integration (see ShellCommand).
+	AuditEventShellCommand = "shell_command"
+)
+
+// AuditEvent is one line of the audit log.
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"`
+
+	SessionID  string `json:"session_id,omitempty"`
+	ConnID     string `json:"conn_id,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+	AuthMethod string `json:"auth_method,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	// Username is the attempted username of auth_failure.
+	Username string `json:"username,omitempty"`
+	// Connect is the resolved session config of connect.
+	Connect *AuditConnect `json:"connect,omitempty"`
+	// Columns and Rows are the terminal size of resize.
+	Columns int `json:"columns,omitempty"`
+	Rows    int `json:"rows,omitempty"`
+	// ExitCode is set for exit, and for shell_command when the shell reported it.
+	ExitCode *int `json:"exit_code,omitempty"`
+	// BytesIn (client input) and BytesOut (terminal output) are the session
+	// totals so far, set for disconnect, idle_evict and exit.
+	BytesIn  int64 `json:"bytes_in,omitempty"`
+	BytesOut int64 `json:"bytes_out,omitempty"`
+	// Command is the submitted line of command, the command line of
+	// shell_command.
+	Command string `json:"command,omitempty"`
+	// DurationMS and Cwd are the run time and working directory of
+	// shell_command.
+	DurationMS int64  `json:"duration_ms,omitempty"`
+	Cwd        string `json:"cwd,omitempty"`
+	// Blocked is set for a command refused by the command rules.
+	Blocked bool `json:"blocked,omitempty"`
+	// Reason describes failures, disconnects and blocked commands.
+	Reason string `json:"reason,omitempty"`
+}
+
+// AuditConnect is the ConnectConfig of a new session. Only the names of the
+// environment variables are recorded, their values may be secrets.
+type AuditConnect struct {
+	Driver            string   `json:"driver"`
+	Shell             string   `json:"shell,omitempty"`
+	User              string   `json:"user,omitempty"`
+	WorkDir           string   `json:"workdir,omitempty"`
+	Image             string   `json:"image,omitempty"`
+	InitCommand       string   `json:"init_command,omitempty"`
+	SSHTarget         string   `json:"ssh_target,omitempty"`
+	Environment       []string `json:"environment,omitempty"`
+	ReadOnly          bool     `json:"read_only,omitempty"`
+	IsHistoryDisabled bool     `json:"history_disabled,omitempty"`
+}
+
+func newAuditConnect(cfg *ConnectConfig) *AuditConnect {
+	env := make([]string, 0, len(cfg.Environment))
+	for k := range cfg.Environment {
+		env = append(env, k)
+	}
+	sort.Strings(env)
+
+	var sshTarget string
+	if cfg.Driver == "ssh" {
+		sshTarget = cfg.sshTarget()
+	}
+
+	return &AuditConnect{
+		Driver:            cfg.Driver,
+		Shell:             cfg.Shell,
+		User:              cfg.User,
+		WorkDir:           cfg.WorkDir,
+		Image:             cfg.Image,
+		InitCommand:       cfg.InitCommand,
+		SSHTarget:         sshTarget,
+		Environment:       env,
+		ReadOnly:          cfg.ReadOnly,
+		IsHistoryDisabled: cfg.IsHistoryDisabled,
+	}
+}
+
+// withIdentity sets the identity fields of the event.
+func (e *AuditEvent) withIdentity(id *Identity, ok bool) *AuditEvent {
+	if ok {
+		e.Subject = id.Subject
+		e.AuthMethod = id.Method
+	}
+	return e
+}
+
+// auditLog writes AuditEvents as JSON lines. A nil *auditLog discards events.
+type auditLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newAuditLog returns the audit log configured in cfg, nil when none is.
+func newAuditLog(cfg *Config) (*auditLog, error) {
+	if cfg.AuditWriter != nil {
+		return &auditLog{w: cfg.AuditWriter}, nil
+	}
+	if cfg.AuditLogFile == "" {
+		return nil, nil
+	}
+
+	f, err := openRotatingFile(cfg.AuditLogFile, cfg.AuditLogMaxSize, cfg.AuditLogMaxBackups)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %s", err)
+	}
+	return &auditLog{w: f}, nil
+}
+
+// Log writes event, stamping its time.
+func (a *auditLog) Log(event *AuditEvent) {
+	if a == nil {
+		return
+	}
+
+	event.Time = time.Now().UTC()
+	line, err := json.Marshal(event)
+	if err != nil {
+		logger.Errorf("[audit] failed to encode %s event: %s", event.Type, err)
+		return
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.w.Write(line); err != nil {
+		logger.Errorf("[audit] failed to write %s event: %s", event.Type, err)
+	}
+}
+
+// rotatingFile is an append-only file rotated to path.1, path.2, ... once it
+// would grow beyond maxSize.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if maxSize <= 0 {
+		maxSize = 100 * 1024 * 1024
+	}
+	if maxBackups <= 0 {
+		maxBackups = 5
+	}
+
+	r := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// Write is called with the audit log lock held and writes whole lines.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			// keep writing to the current file rather than losing events
+			logger.Errorf("[audit] failed to rotate %s: %s", r.path, err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	r.file.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	renameErr := os.Rename(r.path, r.path+".1")
+
+	if err := r.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/zoox"
+)
+
+// auditBuffer collects audit lines written from several goroutines.
+type auditBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *auditBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *auditBuffer) events(t *testing.T) []AuditEvent {
+	t.Helper()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid audit line %q: %s", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := []byte(strings.Repeat("x", 39) + "\n")
+	for i := 0; i < 10; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"audit.log", "audit.log.1", "audit.log.2"} {
+		info, err := os.Stat(filepath.Join(filepath.Dir(path), name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 100 || info.Size()%40 != 0 {
+			t.Errorf("%s: size %d", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more backups than configured: %v", err)
+	}
+}
+
+func TestMiddleware_auditsAuth(t *testing.T) {
+	t.Parallel()
+
+	out := &auditBuffer{}
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{
+		Config:   &Config{AuditWriter: out},
+		Username: "admin",
+		Password: "admin",
+	}))
+
+	for _, pass := range []string{"guess", "admin"} {
+		req := httptest.NewRequest("GET", "/ws", nil)
+		req.SetBasicAuth("admin", pass)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		app.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	// page requests are not audited
+	req := httptest.NewRequest("GET", "/", nil)
+	req.SetBasicAuth("admin", "admin")
+	app.ServeHTTP(httptest.NewRecorder(), req)
+
+	events := out.events(t)
+	if len(events) != 2 {
+		t.Fatalf("events = %+v", events)
+	}
+	if e := events[0]; e.Type != AuditEventAuthFailure || e.Username != "admin" || e.RemoteAddr == "" {
+		t.Errorf("failure event = %+v", e)
+	}
+	if e := events[1]; e.Type != AuditEventAuthSuccess || e.Subject != "admin" || e.AuthMethod != "basic" || e.Time.IsZero() {
+		t.Errorf("success event = %+v", e)
+	}
+}
+
+func TestSessionRegistry_auditsExit(t *testing.T) {
+	t.Parallel()
+
+	out := &auditBuffer{}
+	reg := newSessionRegistry(SessionRegistryConfig{TTL: time.Hour})
+	reg.audit = &auditLog{w: out}
+
+	sess := &mockTerminal{chunks: [][]byte{[]byte("hello")}, exitCode: 3}
+	id := reg.Register(sess)
+	reg.SetOwner(id, &AuditEvent{Subject: "alice", AuthMethod: "basic"})
+	reg.CountInput(id, 4)
+	reg.AttachWriter(id, &syncBridgeConn{})
+
+	deadline := time.Now().Add(time.Second)
+	for len(out.events(t)) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events := out.events(t)
+	if len(events) != 1 {
+		t.Fatalf("events = %+v", events)
+	}
+	e := events[0]
+	if e.Type != AuditEventExit || e.SessionID != id || e.Subject != "alice" || e.ExitCode == nil || *e.ExitCode != 3 || e.BytesIn != 4 || e.BytesOut != 5 {
+		t.Fatalf("exit event = %+v", e)
+	}
+}
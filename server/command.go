@@ -0,0 +1,390 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-zoox/logger"
+	"github.com/go-zoox/terminal/message"
+	"github.com/go-zoox/websocket"
+)
+
+// commandPolicy decides which submitted command lines may run: a line matching
+// a deny pattern is blocked; when allow patterns are set, a line must match one
+// of them.
+type commandPolicy struct {
+	log   bool
+	deny  []*regexp.Regexp
+	allow []*regexp.Regexp
+}
+
+// newCommandPolicy returns the policy configured in cfg, nil when command
+// logging and rules are off.
+func newCommandPolicy(cfg *Config) (*commandPolicy, error) {
+	if !cfg.CommandLog && len(cfg.CommandDenyPatterns) == 0 && len(cfg.CommandAllowPatterns) == 0 {
+		return nil, nil
+	}
+
+	p := &commandPolicy{log: cfg.CommandLog}
+	for _, pattern := range cfg.CommandDenyPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command deny pattern %q: %s", pattern, err)
+		}
+		p.deny = append(p.deny, re)
+	}
+	for _, pattern := range cfg.CommandAllowPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid command allow pattern %q: %s", pattern, err)
+		}
+		p.allow = append(p.allow, re)
+	}
+	return p, nil
+}
+
+// enforcing reports whether the policy may block lines.
+func (p *commandPolicy) enforcing() bool {
+	return len(p.deny) != 0 || len(p.allow) != 0
+}
+
+// Check returns why line is blocked, empty when it may run. uncertain marks a
+// line edited with history or completion, whose text is not fully known.
+func (p *commandPolicy) Check(line string, uncertain bool) string {
+	for _, re := range p.deny {
+		if re.MatchString(line) {
+			return fmt.Sprintf("matches deny rule %s", re)
+		}
+	}
+
+	if len(p.allow) == 0 {
+		return ""
+	}
+	if uncertain {
+		return "edited with history or completion, cannot be checked against the allow rules"
+	}
+	for _, re := range p.allow {
+		if re.MatchString(line) {
+			return ""
+		}
+	}
+	return "not in the allow rules"
+}
+
+// checkInitCommand returns why the init command of a new session is blocked,
+// empty when it may run.
+func (p *commandPolicy) checkInitCommand(command string) string {
+	if p == nil || strings.TrimSpace(command) == "" {
+		return ""
+	}
+	return p.Check(strings.TrimSpace(command), false)
+}
+
+// logCommand records a line submitted by conn in session id, and tells the user
+// when it was blocked.
+func logCommand(conn websocket.Conn, sessions *sessionRegistry, id string, line submittedLine) {
+	if line.Blocked == "" && !sessions.commands.log {
+		return
+	}
+
+	line.Text = sessions.redact.RedactString(line.Text)
+
+	event := newConnAuditEvent(AuditEventCommand, conn, id)
+	event.Command = line.Text
+	event.Blocked = line.Blocked != ""
+	event.Reason = line.Blocked
+	sessions.audit.Log(event)
+
+	who := event.Subject
+	if who == "" {
+		who = event.RemoteAddr
+	}
+	if line.Blocked == "" {
+		logger.Infof("[session %s] command by %s: %q", id, who, line.Text)
+		return
+	}
+	logger.Warnf("[session %s] blocked command by %s: %q (%s)", id, who, line.Text, line.Blocked)
+
+	msg := &message.Message{}
+	msg.SetType(message.TypeOutput)
+	msg.SetOutput([]byte(fmt.Sprintf("\r\n\x1b[31mcommand blocked by policy: %s\x1b[0m\r\n", line.Blocked)))
+	if err := msg.Serialize(); err != nil {
+		logger.Errorf("[ID: %s] failed to serialize message: %s", conn.ID(), err)
+		return
+	}
+	conn.WriteBinaryMessage(msg.Msg())
+}
+
+// submittedLine is a command line the user submitted with Enter.
+type submittedLine struct {
+	Text string
+	// Uncertain is set when history or completion changed the line.
+	Uncertain bool
+	// Blocked is why the line was not submitted, empty when it was.
+	Blocked string
+}
+
+// blockedLineKeys replace the Enter of a blocked line: end of line, kill the
+// line, then submit the now empty line so the shell prints a fresh prompt.
+var blockedLineKeys = []byte("\x05\x15\r")
+
+// lineEditor reconstructs the command lines typed into a session from the
+// client keystrokes: printable input, backspace / delete, cursor movement,
+// Ctrl-U / Ctrl-W / Ctrl-C and bracketed paste. It is best-effort: the shell's
+// history, completion and other key bindings are not visible to it, lines
+// touched by them are marked uncertain.
+type lineEditor struct {
+	line      []rune
+	cursor    int
+	uncertain bool
+
+	// pending holds an incomplete escape sequence or UTF-8 rune across Feed calls
+	pending []byte
+	// escForwarded is set when the last Feed ended in an ESC, forwarded at once
+	// and parsed again with the next input
+	escForwarded bool
+	inPaste      bool
+}
+
+// Feed processes client input p and returns the bytes to forward to the PTY and
+// the lines submitted in p. check decides whether a line may run (empty) or
+// why it is blocked; a blocked line's Enter is replaced by blockedLineKeys.
+func (e *lineEditor) Feed(p []byte, check func(line string, uncertain bool) string) ([]byte, []submittedLine) {
+	var forward []byte
+	var lines []submittedLine
+
+	data := append(e.pending, p...)
+	e.pending = nil
+	// the ESC forwarded by the last Feed, not to be forwarded again
+	skip := 0
+	if e.escForwarded {
+		data = append([]byte{0x1b}, data...)
+		skip = 1
+		e.escForwarded = false
+	}
+
+	for i := 0; i < len(data); {
+		b := data[i]
+
+		if b == 0x1b {
+			start := max(i, skip)
+			n, complete := e.escape(data[i:])
+			if !complete {
+				if i+1 == len(data) || i < skip {
+					// a lone ESC (e.g. vi command mode) goes out right away
+					forward = append(forward, data[start:i+1]...)
+					e.pending = append([]byte(nil), data[i+1:]...)
+					e.escForwarded = true
+				} else {
+					e.pending = append([]byte(nil), data[i:]...)
+				}
+				break
+			}
+			forward = append(forward, data[start:i+n]...)
+			i += n
+			continue
+		}
+
+		if (b == '\r' || b == '\n') && !e.inPaste {
+			line := submittedLine{Text: string(e.line), Uncertain: e.uncertain}
+			if strings.TrimSpace(line.Text) != "" {
+				line.Blocked = check(strings.TrimSpace(line.Text), line.Uncertain)
+				lines = append(lines, line)
+			}
+			if line.Blocked != "" {
+				forward = append(forward, blockedLineKeys...)
+			} else {
+				forward = append(forward, b)
+			}
+			e.reset()
+			i++
+			continue
+		}
+
+		if b >= 0x80 {
+			if !utf8.FullRune(data[i:]) {
+				e.pending = append([]byte(nil), data[i:]...)
+				break
+			}
+			r, n := utf8.DecodeRune(data[i:])
+			e.insert(r)
+			forward = append(forward, data[i:i+n]...)
+			i += n
+			continue
+		}
+
+		e.control(b)
+		forward = append(forward, b)
+		i++
+	}
+
+	return forward, lines
+}
+
+// escape handles the escape sequence at the start of p, returning its length
+// and false when p ends before the sequence does.
+func (e *lineEditor) escape(p []byte) (int, bool) {
+	if len(p) < 2 {
+		return 0, false
+	}
+
+	switch p[1] {
+	case '[':
+		// CSI: parameters up to a final byte in 0x40..0x7e
+		for j := 2; j < len(p); j++ {
+			if p[j] >= 0x40 && p[j] <= 0x7e {
+				e.csi(string(p[2:j]), p[j])
+				return j + 1, true
+			}
+		}
+		return 0, false
+	case 'O':
+		// SS3: application cursor keys
+		if len(p) < 3 {
+			return 0, false
+		}
+		e.csi("", p[2])
+		return 3, true
+	default:
+		e.uncertain = true
+		if p[1] < 0x20 || p[1] == 0x7f {
+			// ESC before a control key, e.g. vi command mode then Enter: the
+			// key is handled on its own, an Enter still submits the line
+			return 1, true
+		}
+		// Alt+key, e.g. readline word movement: position unknown
+		return 2, true
+	}
+}
+
+func (e *lineEditor) csi(params string, final byte) {
+	switch {
+	case final == '~' && params == "200":
+		e.inPaste = true
+	case final == '~' && params == "201":
+		e.inPaste = false
+	case final == '~' && params == "3":
+		// Delete
+		if e.cursor < len(e.line) {
+			e.line = append(e.line[:e.cursor], e.line[e.cursor+1:]...)
+		}
+	case final == 'C':
+		if e.cursor < len(e.line) {
+			e.cursor++
+		}
+	case final == 'D':
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case final == 'H' || (final == '~' && (params == "1" || params == "7")):
+		e.cursor = 0
+	case final == 'F' || (final == '~' && (params == "4" || params == "8")):
+		e.cursor = len(e.line)
+	case final == 'A' || final == 'B':
+		// history
+		e.uncertain = true
+	}
+}
+
+func (e *lineEditor) control(b byte) {
+	if e.inPaste {
+		if b >= 0x20 || b == '\t' {
+			e.insert(rune(b))
+		} else if b == '\r' || b == '\n' {
+			e.insert('\n')
+		}
+		return
+	}
+
+	switch b {
+	case 0x7f, 0x08: // backspace
+		if e.cursor > 0 {
+			e.line = append(e.line[:e.cursor-1], e.line[e.cursor:]...)
+			e.cursor--
+		}
+	case 0x15: // Ctrl-U
+		e.line = append([]rune(nil), e.line[e.cursor:]...)
+		e.cursor = 0
+	case 0x17: // Ctrl-W
+		start := e.cursor
+		for start > 0 && e.line[start-1] == ' ' {
+			start--
+		}
+		for start > 0 && e.line[start-1] != ' ' {
+			start--
+		}
+		e.line = append(e.line[:start], e.line[e.cursor:]...)
+		e.cursor = start
+	case 0x03: // Ctrl-C
+		e.reset()
+	case 0x01: // Ctrl-A
+		e.cursor = 0
+	case 0x05: // Ctrl-E
+		e.cursor = len(e.line)
+	case 0x02: // Ctrl-B
+		if e.cursor > 0 {
+			e.cursor--
+		}
+	case 0x06: // Ctrl-F
+		if e.cursor < len(e.line) {
+			e.cursor++
+		}
+	default:
+		if b >= 0x20 {
+			e.insert(rune(b))
+		} else {
+			// completion, history (Ctrl-P / Ctrl-N / Ctrl-R), Ctrl-D delete,
+			// Ctrl-K kill, Ctrl-T transpose, Ctrl-Y yank, Ctrl-V quoted insert
+			// and whatever else the shell binds: the line is not known
+			e.uncertain = true
+		}
+	}
+}
+
+func (e *lineEditor) insert(r rune) {
+	e.line = append(e.line, 0)
+	copy(e.line[e.cursor+1:], e.line[e.cursor:])
+	e.line[e.cursor] = r
+	e.cursor++
+}
+
+func (e *lineEditor) reset() {
+	e.line = e.line[:0]
+	e.cursor = 0
+	e.uncertain = false
+}
+
+// Alternate screen switches: full-screen programs (vim, less, top) read keys
+// that are not shell commands.
+var (
+	altScreenOn  = [][]byte{[]byte("\x1b[?1049h"), []byte("\x1b[?1047h"), []byte("\x1b[?47h")}
+	altScreenOff = [][]byte{[]byte("\x1b[?1049l"), []byte("\x1b[?1047l"), []byte("\x1b[?47l")}
+)
+
+// altScreenSwitch reports whether output switches to (1) or from (-1) the
+// alternate screen, 0 when it does neither; the last switch in output wins.
+func altScreenSwitch(output []byte) int {
+	on, off := -1, -1
+	for _, seq := range altScreenOn {
+		if i := bytes.LastIndex(output, seq); i > on {
+			on = i
+		}
+	}
+	for _, seq := range altScreenOff {
+		if i := bytes.LastIndex(output, seq); i > off {
+			off = i
+		}
+	}
+
+	switch {
+	case on > off:
+		return 1
+	case off > on:
+		return -1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestLineEditor(t *testing.T) {
+	t.Parallel()
+
+	allow := func(string, bool) string { return "" }
+
+	for _, tc := range []struct {
+		name      string
+		input     []string
+		want      string
+		uncertain bool
+	}{
+		{"plain", []string{"ls -la\r"}, "ls -la", false},
+		{"split keystrokes", []string{"l", "s", " /", "tmp", "\r"}, "ls /tmp", false},
+		{"backspace", []string{"lss\x7f -l\x08a\r"}, "ls -a", false},
+		{"cursor movement", []string{"echo wrld", "\x1b[D\x1b[D\x1b[D", "o", "\x1b[F!\r"}, "echo world!", false},
+		{"home and delete", []string{"xecho hi\x01\x1b[3~\r"}, "echo hi", false},
+		{"kill line", []string{"rm -rf /\x15echo ok\r"}, "echo ok", false},
+		{"kill word", []string{"echo foo bar\x17baz\r"}, "echo foo baz", false},
+		{"ctrl-c", []string{"rm -rf /\x03", "uptime\r"}, "uptime", false},
+		{"bracketed paste", []string{"\x1b[200~cat <<EOF\r", "hi\rEOF\x1b[201~\r"}, "cat <<EOF\nhi\nEOF", false},
+		{"escape split across reads", []string{"ls\x1b", "[D\x1b", "[3~x\r"}, "lx", false},
+		{"csi split after escape", []string{"ls\x1b", "[", "D\x1b[3~x\r"}, "lx", false},
+		{"escape then enter", []string{"ls\x1b\r"}, "ls", true},
+		{"lone escape then enter", []string{"ls\x1b", "\r"}, "ls", true},
+		{"alt key", []string{"ls\x1bb\r"}, "ls", true},
+		{"utf-8 split across reads", []string{"echo \xe4\xbd", "\xa0\xe5\xa5\xbd\x7f\r"}, "echo 你", false},
+		{"history", []string{"\x1b[A\r"}, "", true},
+		{"completion", []string{"cat /etc/pass\t\r"}, "cat /etc/pass", true},
+		{"delete char", []string{"xls\x01\x04\r"}, "xls", true},
+		{"kill to end", []string{"ls -la\x01\x0b\r"}, "ls -la", true},
+		{"transpose", []string{"sl\x14\r"}, "sl", true},
+		{"yank", []string{"rm -rf /\x17\x19\r"}, "rm -rf ", true},
+		{"quoted insert", []string{"echo \x16\x0f\r"}, "echo ", true},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			e := &lineEditor{}
+			var lines []submittedLine
+			for _, in := range tc.input {
+				_, l := e.Feed([]byte(in), allow)
+				lines = append(lines, l...)
+			}
+
+			if tc.want == "" {
+				if len(lines) != 0 {
+					t.Fatalf("lines = %+v, want none", lines)
+				}
+				return
+			}
+			if len(lines) != 1 || lines[0].Text != tc.want || lines[0].Uncertain != tc.uncertain {
+				t.Fatalf("lines = %+v, want %q (uncertain %v)", lines, tc.want, tc.uncertain)
+			}
+		})
+	}
+}
+
+func TestLineEditor_blocksLines(t *testing.T) {
+	t.Parallel()
+
+	policy, err := newCommandPolicy(&Config{CommandDenyPatterns: []string{`^rm\s+-rf\b`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := &lineEditor{}
+	forward, lines := e.Feed([]byte("ls\rrm -rf /\rpwd\r"), policy.Check)
+	if string(forward) != "ls\rrm -rf /"+string(blockedLineKeys)+"pwd\r" {
+		t.Fatalf("forward = %q", forward)
+	}
+	if len(lines) != 3 || lines[0].Blocked != "" || lines[1].Blocked == "" || lines[2].Blocked != "" {
+		t.Fatalf("lines = %+v", lines)
+	}
+}
+
+func TestLineEditor_escapeBeforeEnter(t *testing.T) {
+	t.Parallel()
+
+	for _, cfg := range []*Config{
+		{CommandAllowPatterns: []string{`^ls\b`}},
+		{CommandDenyPatterns: []string{`^rm\s+-rf\b`}},
+	} {
+		policy, err := newCommandPolicy(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		e := &lineEditor{}
+		forward, lines := e.Feed([]byte("rm -rf /\x1b\r"), policy.Check)
+		if string(forward) != "rm -rf /\x1b"+string(blockedLineKeys) {
+			t.Fatalf("forward = %q", forward)
+		}
+		if len(lines) != 1 || lines[0].Text != "rm -rf /" || lines[0].Blocked == "" {
+			t.Fatalf("lines = %+v", lines)
+		}
+	}
+
+	// a trailing ESC is not held back
+	e := &lineEditor{}
+	if forward, _ := e.Feed([]byte("ls\x1b"), func(string, bool) string { return "" }); string(forward) != "ls\x1b" {
+		t.Fatalf("forward = %q", forward)
+	}
+	if forward, _ := e.Feed([]byte("[D"), func(string, bool) string { return "" }); string(forward) != "[D" {
+		t.Fatalf("forward after ESC = %q", forward)
+	}
+}
+
+func TestCommandPolicy(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newCommandPolicy(&Config{CommandDenyPatterns: []string{"("}}); err == nil {
+		t.Fatal("invalid pattern accepted")
+	}
+	if p, _ := newCommandPolicy(&Config{}); p != nil {
+		t.Fatal("policy without logging or rules")
+	}
+
+	p, err := newCommandPolicy(&Config{
+		CommandDenyPatterns:  []string{`sudo`},
+		CommandAllowPatterns: []string{`^(ls|git|sudo)\b`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		line      string
+		uncertain bool
+		blocked   bool
+	}{
+		{"ls -la", false, false},
+		{"git status", false, false},
+		{"sudo ls", false, true},
+		{"curl example.com", false, true},
+		{"ls -la", true, true},
+	} {
+		if got := p.Check(tc.line, tc.uncertain) != ""; got != tc.blocked {
+			t.Errorf("Check(%q, %v) blocked = %v, want %v", tc.line, tc.uncertain, got, tc.blocked)
+		}
+	}
+
+	if p.checkInitCommand("curl example.com") == "" {
+		t.Error("init command outside the allow rules accepted")
+	}
+	if (*commandPolicy)(nil).checkInitCommand("anything") != "" {
+		t.Error("nil policy blocked an init command")
+	}
+}
+
+func TestAltScreenSwitch(t *testing.T) {
+	t.Parallel()
+
+	if got := altScreenSwitch([]byte("vim\x1b[?1049h\x1b[H")); got != 1 {
+		t.Errorf("enter = %d", got)
+	}
+	if got := altScreenSwitch([]byte("\x1b[?1049h...\x1b[?1049l$ ")); got != -1 {
+		t.Errorf("enter then leave = %d", got)
+	}
+	if got := altScreenSwitch([]byte("plain output")); got != 0 {
+		t.Errorf("plain = %d", got)
+	}
+}
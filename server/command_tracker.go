@@ -0,0 +1,355 @@
+package server
+
+import (
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-zoox/zoox"
+)
+
+// maxShellCommands caps the commands kept per session, the oldest are dropped.
+const maxShellCommands = 1000
+
+// maxMarkerBytes caps an OSC sequence parsed for shell integration markers;
+// longer ones are skipped.
+const maxMarkerBytes = 8192
+
+// ShellCommand is a command tracked by the shell integration markers in the
+// session output (see Config.ShellIntegration).
+type ShellCommand struct {
+	// Seq numbers the commands of the session from 1.
+	Seq int `json:"seq"`
+	// Command is the command line the shell reported, else the echoed input.
+	Command string `json:"command"`
+	// Cwd is the working directory the shell reported at the prompt.
+	Cwd       string    `json:"cwd,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// FinishedAt, ExitCode and DurationMS are set when the command finished;
+	// ExitCode stays unset when the shell did not report it.
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExitCode   *int       `json:"exit_code,omitempty"`
+	DurationMS int64      `json:"duration_ms"`
+}
+
+// shellMark is a shell integration marker: OSC 133 (FinalTerm) or OSC 633
+// (VS Code) A prompt start, B prompt end, C command start, D;<exit> command end,
+// E;<command line>, P;Cwd=<dir>, or an OSC 7 working directory (kind '7').
+type shellMark struct {
+	kind byte
+	arg  string
+}
+
+// parseShellMark returns the marker in an OSC payload.
+func parseShellMark(payload []byte) (shellMark, bool) {
+	code, rest, _ := bytes.Cut(payload, []byte(";"))
+	switch string(code) {
+	case "133", "633":
+		if len(rest) == 0 {
+			return shellMark{}, false
+		}
+		mark := shellMark{kind: rest[0]}
+		if len(rest) > 2 && rest[1] == ';' {
+			mark.arg = string(rest[2:])
+		}
+		return mark, true
+	case "7":
+		u, err := url.Parse(string(rest))
+		if err != nil || u.Scheme != "file" {
+			return shellMark{}, false
+		}
+		return shellMark{kind: '7', arg: u.Path}, true
+	}
+	return shellMark{}, false
+}
+
+// unescapeMarkArg decodes an OSC 633 value: \\ is a backslash, \xAB a byte.
+func unescapeMarkArg(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			if s[i+1] == '\\' {
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+			if s[i+1] == 'x' && i+3 < len(s) {
+				if v, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+					b.WriteByte(byte(v))
+					i += 3
+					continue
+				}
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// markerParser splits terminal output into text and shell integration markers.
+// Sequences may be split across reads.
+type markerParser struct {
+	state    int
+	payload  []byte
+	overflow bool
+}
+
+const (
+	markerText = iota
+	markerEsc
+	markerCSI
+	markerOSC
+	markerOSCEsc
+)
+
+// Feed parses output; text gets the printable output without escape
+// sequences, mark the markers, in order.
+func (p *markerParser) Feed(output []byte, text func([]byte), mark func(shellMark)) {
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			text(output[start:end])
+		}
+	}
+
+	for i := 0; i < len(output); i++ {
+		c := output[i]
+		switch p.state {
+		case markerText:
+			j := bytes.IndexByte(output[i:], 0x1b)
+			if j < 0 {
+				i = len(output)
+				continue
+			}
+			i += j
+			flush(i)
+			p.state = markerEsc
+		case markerEsc:
+			switch c {
+			case ']':
+				p.state = markerOSC
+				p.payload = p.payload[:0]
+				p.overflow = false
+			case '[':
+				p.state = markerCSI
+			default:
+				p.state = markerText
+				start = i + 1
+			}
+		case markerCSI:
+			if c >= 0x40 && c <= 0x7e {
+				p.state = markerText
+				start = i + 1
+			}
+		case markerOSC, markerOSCEsc:
+			switch {
+			case c == 0x07 || (p.state == markerOSCEsc && c == '\\'):
+				if m, ok := parseShellMark(p.payload); ok && !p.overflow {
+					mark(m)
+				}
+				p.state = markerText
+				start = i + 1
+			case c == 0x1b:
+				p.state = markerOSCEsc
+			case p.state == markerOSCEsc:
+				// an unterminated OSC followed by another escape sequence
+				p.state = markerEsc
+				i--
+			case len(p.payload) >= maxMarkerBytes:
+				p.overflow = true
+			default:
+				p.payload = append(p.payload, c)
+			}
+		}
+	}
+
+	if p.state == markerText {
+		flush(len(output))
+	}
+}
+
+// commandTracker follows the commands of a session from the shell integration
+// markers in its output.
+type commandTracker struct {
+	parser markerParser
+
+	mu       sync.Mutex
+	commands []ShellCommand
+	seq      int
+	running  bool
+	// input is the echoed command line after the prompt (B) when the shell does
+	// not report it with E.
+	input   []byte
+	inInput bool
+	line    string
+	cwd     string
+
+	redact   *redactor
+	onFinish func(ShellCommand)
+}
+
+func newCommandTracker(redact *redactor, onFinish func(ShellCommand)) *commandTracker {
+	return &commandTracker{redact: redact, onFinish: onFinish}
+}
+
+// Feed parses session output. It is called by the pump only.
+func (t *commandTracker) Feed(output []byte) {
+	var finished []ShellCommand
+	t.mu.Lock()
+	t.parser.Feed(output, t.text, func(m shellMark) {
+		if c, ok := t.mark(m); ok {
+			finished = append(finished, c)
+		}
+	})
+	t.mu.Unlock()
+
+	for _, c := range finished {
+		if t.onFinish != nil {
+			t.onFinish(c)
+		}
+	}
+}
+
+func (t *commandTracker) text(p []byte) {
+	if !t.inInput || len(t.input) >= maxMarkerBytes {
+		return
+	}
+	for _, c := range p {
+		switch {
+		case c == '\b' || c == 0x7f:
+			_, size := utf8.DecodeLastRune(t.input)
+			t.input = t.input[:len(t.input)-size]
+		case c < 0x20:
+			// \r, \n and other controls around the echo
+		default:
+			t.input = append(t.input, c)
+		}
+	}
+}
+
+// mark applies m and returns the command it finished.
+func (t *commandTracker) mark(m shellMark) (ShellCommand, bool) {
+	switch m.kind {
+	case 'A':
+		t.inInput = false
+		if t.running {
+			return t.finish(nil), true
+		}
+	case 'B':
+		t.inInput = true
+		t.input = t.input[:0]
+		t.line = ""
+	case 'E':
+		t.line, _, _ = strings.Cut(m.arg, ";")
+		t.line = unescapeMarkArg(t.line)
+	case 'P':
+		if k, v, ok := strings.Cut(m.arg, "="); ok && k == "Cwd" {
+			t.cwd = unescapeMarkArg(v)
+		}
+	case '7':
+		t.cwd = m.arg
+	case 'C':
+		var finished ShellCommand
+		wasRunning := t.running
+		if wasRunning {
+			finished = t.finish(nil)
+		}
+		t.start()
+		return finished, wasRunning
+	case 'D':
+		t.inInput = false
+		if !t.running {
+			return ShellCommand{}, false
+		}
+		var code *int
+		if v, err := strconv.Atoi(strings.TrimSpace(m.arg)); err == nil {
+			code = &v
+		}
+		return t.finish(code), true
+	}
+	return ShellCommand{}, false
+}
+
+func (t *commandTracker) start() {
+	line := strings.TrimSpace(t.line)
+	if line == "" {
+		line = strings.TrimSpace(string(t.input))
+	}
+	t.line, t.input, t.inInput = "", t.input[:0], false
+
+	t.seq++
+	t.commands = append(t.commands, ShellCommand{
+		Seq:       t.seq,
+		Command:   t.redact.RedactString(line),
+		Cwd:       t.cwd,
+		StartedAt: time.Now(),
+	})
+	if len(t.commands) > maxShellCommands {
+		t.commands = append(t.commands[:0:0], t.commands[len(t.commands)-maxShellCommands:]...)
+	}
+	t.running = true
+}
+
+func (t *commandTracker) finish(code *int) ShellCommand {
+	t.running = false
+	c := &t.commands[len(t.commands)-1]
+	now := time.Now()
+	c.FinishedAt = &now
+	c.ExitCode = code
+	c.DurationMS = now.Sub(c.StartedAt).Milliseconds()
+	return *c
+}
+
+// End finishes the running command, if any, with the exit code of the session
+// (e.g. exit).
+func (t *commandTracker) End(code int) {
+	t.mu.Lock()
+	if !t.running {
+		t.mu.Unlock()
+		return
+	}
+	c := t.finish(&code)
+	t.mu.Unlock()
+
+	if t.onFinish != nil {
+		t.onFinish(c)
+	}
+}
+
+// Commands returns the tracked commands, oldest first.
+func (t *commandTracker) Commands() []ShellCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]ShellCommand{}, t.commands...)
+}
+
+// commandsHandler returns a handler listing the commands tracked in a session
+// (GET ?session_id=...), see ShellCommand. Only the owner of the session, an
+// admin or a share link of the session may list them.
+func commandsHandler(sessions *sessionRegistry) zoox.HandlerFunc {
+	return func(ctx *zoox.Context) {
+		id := ctx.Query().Get("session_id").String()
+		if id == "" {
+			ctx.JSON(400, zoox.H{"message": "session_id is required"})
+			return
+		}
+
+		commands, ok := sessions.ShellCommands(id)
+		if !ok {
+			ctx.JSON(404, zoox.H{"message": "session not found"})
+			return
+		}
+		if identity, _ := IdentityFromRequest(ctx.Request); !sessions.Accessible(id, identity) {
+			ctx.JSON(403, zoox.H{"message": "the session belongs to another user"})
+			return
+		}
+		ctx.JSON(200, zoox.H{"session_id": id, "commands": commands})
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/zoox"
+)
+
+func TestMarkerParser(t *testing.T) {
+	t.Parallel()
+
+	output := "\x1b]133;A\x07\x1b[1;32muser@host\x1b[0m$ \x1b]133;B\x1b\\ls\r\n" +
+		"\x1b]633;E;ls -l\\x3b pwd\x07\x1b]2;title\x07\x1b]7;file://host/tmp/a%20b\x07out\x1b]133;D;2\x07"
+	wantText := "user@host$ ls\r\nout"
+	wantMarks := []shellMark{{'A', ""}, {'B', ""}, {'E', `ls -l\x3b pwd`}, {'7', "/tmp/a b"}, {'D', "2"}}
+
+	// the same result however the output is split into reads
+	for _, size := range []int{len(output), 1, 2, 3, 7} {
+		var p markerParser
+		var text strings.Builder
+		var marks []shellMark
+		for i := 0; i < len(output); i += size {
+			end := i + size
+			if end > len(output) {
+				end = len(output)
+			}
+			p.Feed([]byte(output[i:end]), func(b []byte) { text.Write(b) }, func(m shellMark) { marks = append(marks, m) })
+		}
+
+		if text.String() != wantText {
+			t.Errorf("reads of %d: text = %q", size, text.String())
+		}
+		if !reflect.DeepEqual(marks, wantMarks) {
+			t.Errorf("reads of %d: marks = %q", size, marks)
+		}
+	}
+}
+
+func TestCommandTracker(t *testing.T) {
+	t.Parallel()
+
+	var finished []ShellCommand
+	tr := newCommandTracker(nil, func(c ShellCommand) { finished = append(finished, c) })
+
+	// the echoed input, the shell reports no command line
+	tr.Feed([]byte("\x1b]633;P;Cwd=/home/alice\x07\x1b]133;A\x07$ \x1b]133;B\x07lx\b\x1b[Ks -a\r\n\x1b]133;C\x07"))
+	tr.Feed([]byte(".profile\r\n\x1b]133;D;0\x07"))
+	// an empty line
+	tr.Feed([]byte("\x1b]133;A\x07$ \x1b]133;B\x07\r\n\x1b]133;D;0\x07"))
+	// the command line reported by the shell
+	tr.Feed([]byte("\x1b]133;A\x07$ \x1b]133;B\x07\x1b[A\r\n\x1b]633;E;a\\x3bb\\\\c\x07\x1b]133;C\x07\x1b]133;D;127\x07"))
+	// a command without end marker
+	tr.Feed([]byte("\x1b]133;A\x07$ \x1b]133;B\x07vim\r\n\x1b]133;C\x07"))
+	tr.Feed([]byte("\x1b]133;A\x07$ \x1b]133;B\x07"))
+
+	commands := tr.Commands()
+	if len(commands) != 3 || len(finished) != 3 {
+		t.Fatalf("commands = %+v, finished = %+v", commands, finished)
+	}
+	for i, want := range []struct {
+		command string
+		code    int
+	}{
+		{"ls -a", 0},
+		{`a;b\c`, 127},
+		{"vim", -1},
+	} {
+		c := commands[i]
+		if c.Seq != i+1 || c.Command != want.command || c.Cwd != "/home/alice" || c.FinishedAt == nil || !reflect.DeepEqual(c, finished[i]) {
+			t.Errorf("command %d = %+v", i, c)
+		}
+		if (c.ExitCode == nil) != (want.code < 0) || (c.ExitCode != nil && *c.ExitCode != want.code) {
+			t.Errorf("command %d: exit code %v, want %d", i, c.ExitCode, want.code)
+		}
+	}
+}
+
+func TestSessionRegistry_shellCommands(t *testing.T) {
+	t.Parallel()
+
+	out := &auditBuffer{}
+	reg := newSessionRegistry(SessionRegistryConfig{TTL: time.Hour})
+	reg.audit = &auditLog{w: out}
+
+	sess := &mockTerminal{chunks: [][]byte{
+		[]byte("\x1b]133;A\x07$ \x1b]133;B\x07\x1b]633;E;make test\x07\x1b]133;C\x07FAIL\r\n"),
+		[]byte("\x1b]133;D;2\x07\x1b]133;A\x07$ \x1b]133;B\x07exit\r\n\x1b]133;C\x07"),
+	}, exitCode: 1}
+	id := reg.Register(sess)
+	reg.AttachWriter(id, &syncBridgeConn{})
+
+	deadline := time.Now().Add(time.Second)
+	for len(out.events(t)) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events := out.events(t)
+	if len(events) != 3 {
+		t.Fatalf("events = %+v", events)
+	}
+	if e := events[0]; e.Type != AuditEventShellCommand || e.SessionID != id || e.Command != "make test" || e.ExitCode == nil || *e.ExitCode != 2 {
+		t.Fatalf("shell command event = %+v", e)
+	}
+	// the last command ends with the session
+	if e := events[1]; e.Type != AuditEventShellCommand || e.Command != "exit" || e.ExitCode == nil || *e.ExitCode != 1 {
+		t.Fatalf("shell command event = %+v", e)
+	}
+
+	commands, ok := reg.ShellCommands(id)
+	if !ok || len(commands) != 2 || commands[0].Command != "make test" {
+		t.Fatalf("ShellCommands() = %+v, %v", commands, ok)
+	}
+}
+
+func TestCommandsHandler(t *testing.T) {
+	t.Parallel()
+
+	reg := newSessionRegistry(SessionRegistryConfig{TTL: time.Hour})
+	id := reg.registerSessionOnly(&mockTerminal{})
+	other := reg.registerSessionOnly(&mockTerminal{})
+	reg.entry(id).shell.Feed([]byte("\x1b]133;B\x07\x1b]633;E;uptime\x07\x1b]133;C\x07\x1b]133;D;0\x07"))
+	reg.SetOwner(id, &AuditEvent{Subject: "alice", AuthMethod: "basic"})
+
+	app := zoox.New()
+	app.Use(func(ctx *zoox.Context) {
+		if token := ctx.Query().Get("as_share").String(); token != "" {
+			ctx.Request = requestWithIdentity(ctx.Request, &Identity{Method: "share", Share: &Share{SessionID: token}})
+		}
+		if user := ctx.Query().Get("as_user").String(); user != "" {
+			ctx.Request = requestWithIdentity(ctx.Request, &Identity{Subject: user, Method: "basic", Defaults: &SessionDefaults{Admin: user == "carol"}})
+		}
+		ctx.Next()
+	})
+	app.Get("/commands", commandsHandler(reg))
+
+	get := func(query string) (int, map[string]json.RawMessage) {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/commands?"+query, nil))
+		var body map[string]json.RawMessage
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	code, body := get("session_id=" + id + "&as_user=alice")
+	var commands []ShellCommand
+	if err := json.Unmarshal(body["commands"], &commands); code != 200 || err != nil || len(commands) != 1 || commands[0].Command != "uptime" {
+		t.Fatalf("%d %s", code, body["commands"])
+	}
+	if code, _ := get(""); code != 400 {
+		t.Errorf("without session id: %d", code)
+	}
+	if code, _ := get("session_id=nope"); code != 404 {
+		t.Errorf("unknown session: %d", code)
+	}
+	if code, _ := get("session_id=" + id + "&as_share=" + other); code != 403 {
+		t.Errorf("share link of another session: %d", code)
+	}
+	if code, _ := get("session_id=" + id + "&as_share=" + id); code != 200 {
+		t.Errorf("share link of the session: %d", code)
+	}
+	for user, want := range map[string]int{"bob": 403, "carol": 200} {
+		if code, _ := get("session_id=" + id + "&as_user=" + user); code != want {
+			t.Errorf("%s: %d, want %d", user, code, want)
+		}
+	}
+	if code, _ := get("session_id=" + id); code != 403 {
+		t.Errorf("anonymous: %d", code)
+	}
+}
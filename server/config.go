@@ -1,6 +1,9 @@
 package server
 
-import "time"
+import (
+	"io"
+	"time"
+)
 
 type Config struct {
 	Shell string
@@ -11,6 +14,41 @@ type Config struct {
 	// Driver is the Driver runtime, options: host, docker, kubernetes, ssh, default: host
 	Driver      string
 	DriverImage string
+	// Drivers registers additional drivers by name, selectable as Driver or by
+	// clients; a name here replaces the built-in driver of that name.
+	Drivers map[string]Driver
+	// DriverPluginDirs are searched for driver plugin executables
+	// (terminal-driver-<name>, see package plugin), default: PATH for Driver
+	// only, clients may not select plugins from PATH.
+	DriverPluginDirs []string
+	// Sandbox runs host driver sessions in a sandbox and refuses the other
+	// drivers, see SandboxConfig.
+	Sandbox *SandboxConfig
+	//
+	// EphemeralHome gives each host driver session a fresh directory in
+	// EphemeralHomeDir (default: the system temp dir) as workdir and HOME,
+	// seeded with a copy of EphemeralHomeTemplate and owned by the session user.
+	// It is removed when the session exits or is evicted, except after a
+	// non-zero exit with EphemeralHomeKeepOnFailure. Sessions with a User need
+	// the sandbox, which sets HOME after switching to the user.
+	EphemeralHome              bool
+	EphemeralHomeDir           string
+	EphemeralHomeTemplate      string
+	EphemeralHomeKeepOnFailure bool
+	//
+	// SessionEnvPrefix prefixes the variables that tell each new session about
+	// itself: SESSION_ID, USER, AUTH_METHOD, REMOTE_ADDR, USER_AGENT and
+	// SERVER_VERSION, default: TERMINAL_. SessionEnv adds variables by name, the
+	// values are text/template templates executed with the SessionContext.
+	// Both replace variables of the same name sent by the client.
+	SessionEnvPrefix string
+	SessionEnv       map[string]string
+	//
+	// ShellIntegration starts interactive bash sessions of the host and docker
+	// drivers with prompt and command markers (OSC 133), so the server tracks
+	// each command's line, exit code and duration (see ShellCommand). Markers
+	// of shells set up by the user are tracked without it.
+	ShellIntegration bool
 	//
 	InitCommand string
 	// WorkDir is the default working directory for new sessions when the client
@@ -25,4 +63,66 @@ type Config struct {
 	// disconnects, allowing reconnect before eviction. Zero means use the default
 	// (60 seconds) in Serve.
 	SessionIdleRetention time.Duration
+	//
+	// ShareSecret signs share links (see NewShareToken). Register and Middleware
+	// use a random per-process secret when empty; set it to mint links with the
+	// Go API or to keep links valid across restarts and replicas.
+	ShareSecret string
+	//
+	// AuditLogFile enables the audit log: JSON lines of auth, connect,
+	// reconnect, resize, disconnect, idle eviction and exit events (see
+	// AuditEvent). The file is rotated to AuditLogFile.1, .2, ... when it
+	// reaches AuditLogMaxSize bytes (default 100 MiB), keeping AuditLogMaxBackups
+	// files (default 5). AuditWriter receives the events instead when set.
+	AuditLogFile       string
+	AuditLogMaxSize    int64
+	AuditLogMaxBackups int
+	AuditWriter        io.Writer
+	//
+	// CommandLog logs the command lines submitted in sessions, reconstructed
+	// from the keystrokes, to the server log and as command audit events.
+	// CommandDenyPatterns block lines matching any of the regular expressions;
+	// when CommandAllowPatterns are set, only lines matching one of them run.
+	// A blocked line is cleared instead of submitted and a policy notice is
+	// printed in the terminal. The reconstruction is best-effort (history and
+	// completion are invisible to the server), so rules are a guard rail, not a
+	// sandbox.
+	CommandLog           bool
+	CommandDenyPatterns  []string
+	CommandAllowPatterns []string
+	//
+	// RedactSecrets masks well-known secret formats (cloud and API tokens, JWTs,
+	// private key blocks, password=... assignments) in session output;
+	// RedactPatterns adds regular expressions, a pattern with a capture group
+	// masks only the group. Redaction applies to the reconnect replay and the
+	// command log; with RedactClientOutput, clients (and their recordings)
+	// receive the redacted output too.
+	RedactSecrets      bool
+	RedactPatterns     []string
+	RedactClientOutput bool
+	//
+	// SSHHost and SSHPort (default 22) are the target of the ssh driver, which
+	// logs in as SSHUser (default User) with SSHPassword or the private key in
+	// SSHPrivateKeyFile (decrypted with SSHPrivateKeyPassphrase). Host keys are
+	// checked against SSHKnownHostsFile (default ~/.ssh/known_hosts) unless
+	// SSHInsecureIgnoreHostKey. SSHJumpHost ([user@]host[:port]) is dialed first
+	// with the same credentials. Clients may send their own user and credentials,
+	// and another host only when it matches an SSHAllowedHosts pattern (host or
+	// host:port, with * wildcards).
+	SSHHost                  string
+	SSHPort                  int
+	SSHUser                  string
+	SSHPassword              string
+	SSHPrivateKeyFile        string
+	SSHPrivateKeyPassphrase  string
+	SSHKnownHostsFile        string
+	SSHInsecureIgnoreHostKey bool
+	SSHJumpHost              string
+	SSHAllowedHosts          []string
+
+	// audit is the audit log shared by Middleware / Register and Serve.
+	audit *auditLog
+	// sessions is the session registry shared by Middleware / Register and
+	// Serve, for the commands endpoint.
+	sessions *sessionRegistry
 }
@@ -23,9 +23,46 @@ type ConnectConfig struct {
 	ReadOnly bool
 	//
 	WaitUntilFinished bool
+	//
+	// EphemeralHome is the session's temporary home directory, see
+	// Config.EphemeralHome; it is the workdir and HOME of the session.
+	EphemeralHome string
+	//
+	// SSH target of the ssh driver, see Config.SSHHost.
+	SSHHost                  string
+	SSHPort                  int
+	SSHUser                  string
+	SSHPassword              string
+	SSHPrivateKey            string
+	SSHPrivateKeyPassphrase  string
+	SSHKnownHostsFile        string
+	SSHInsecureIgnoreHostKey bool
+	SSHJumpHost              string
+	// SSHWorkDir is the directory on the ssh target the session starts in, the
+	// workdir the client asked for; WorkDir may be a default of this server.
+	SSHWorkDir string
+}
+
+// useEphemeralHome makes dir the workdir and HOME of the session.
+func (cc *ConnectConfig) useEphemeralHome(dir string) {
+	env := make(map[string]string, len(cc.Environment)+1)
+	for k, v := range cc.Environment {
+		env[k] = v
+	}
+	env["HOME"] = dir
+	cc.Environment = env
+	cc.WorkDir = dir
+	cc.EphemeralHome = dir
+}
+
+// connect creates the session terminal with the driver cc.Driver names.
+func connect(cfg *Config, cc *ConnectConfig) (terminal.Terminal, error) {
+	return cfg.driver(cc.Driver).Connect(cc)
 }
 
-func connect(cfg *ConnectConfig) (session terminal.Terminal, err error) {
+// connectEngine runs the session with the github.com/go-zoox/command engine
+// cfg.Driver.
+func connectEngine(cfg *ConnectConfig) (session terminal.Terminal, err error) {
 	// command.New attaches a goroutine: when cfg.Context is done, it calls eg.Cancel().
 	// conn.Context() from the WebSocket upgrade is canceled as soon as the browser disconnects
 	// (refresh, tab close), which tears down the PTY and breaks session reconnect — the user
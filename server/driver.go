@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/go-zoox/command/engine"
+	"github.com/go-zoox/command/terminal"
+)
+
+// Driver creates the terminal of a new session. Register drivers by name in
+// Config.Drivers to add in-process runtimes (a REPL, a restricted shell, a test
+// fake) next to the built-in ones; clients select them like any other driver.
+//
+// Connect receives the session's ConnectConfig after the server defaults, user
+// defaults and query overrides are applied. The returned terminal is pumped until
+// Read fails, then Wait reports the exit: an *errors.ExitError (from
+// github.com/go-zoox/command/errors) carries a non-zero exit code.
+type Driver interface {
+	Connect(cfg *ConnectConfig) (terminal.Terminal, error)
+}
+
+// DriverFunc adapts a function to a Driver.
+type DriverFunc func(cfg *ConnectConfig) (terminal.Terminal, error)
+
+// Connect calls f(cfg).
+func (f DriverFunc) Connect(cfg *ConnectConfig) (terminal.Terminal, error) {
+	return f(cfg)
+}
+
+// builtinDrivers are used when Config.Drivers has no driver of the name. Other
+// names are passed to github.com/go-zoox/command as engine (host, docker, k8s,
+// ...) when it has one of the name, else they run the driver plugin of the name
+// (see package plugin).
+var builtinDrivers = map[string]Driver{
+	"ssh": DriverFunc(connectSSH),
+}
+
+// driver returns the driver registered as name. With the sandbox, only the
+// sandboxed host driver is available.
+func (cfg *Config) driver(name string) Driver {
+	if cfg.Sandbox != nil {
+		return DriverFunc(func(cc *ConnectConfig) (terminal.Terminal, error) {
+			if name != "host" {
+				return nil, fmt.Errorf("driver %s is not available, the sandbox only runs host sessions", name)
+			}
+			return connectSandbox(cc, cfg.Sandbox)
+		})
+	}
+	if d, ok := cfg.Drivers[name]; ok {
+		return d
+	}
+	if d, ok := builtinDrivers[name]; ok {
+		return d
+	}
+	if _, err := engine.Get(name); err == nil {
+		return DriverFunc(connectEngine)
+	}
+	if path, ok := cfg.findDriverPlugin(name); ok {
+		return &pluginDriver{name: name, path: path}
+	}
+	return DriverFunc(connectEngine)
+}
+
+// isBuiltinDriver reports whether name is served by a built-in driver rather
+// than one registered in Config.Drivers.
+func (cfg *Config) isBuiltinDriver(name string) bool {
+	_, ok := cfg.Drivers[name]
+	return !ok
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/command/terminal"
+	"github.com/go-zoox/terminal/message"
+	"github.com/go-zoox/zoox"
+	"github.com/gorilla/websocket"
+)
+
+// echoTerminal writes its input back as output.
+type echoTerminal struct {
+	mockTerminal
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func newEchoTerminal() *echoTerminal {
+	r, w := io.Pipe()
+	return &echoTerminal{r: r, w: w}
+}
+
+func (e *echoTerminal) Read(p []byte) (int, error)  { return e.r.Read(p) }
+func (e *echoTerminal) Write(p []byte) (int, error) { return e.w.Write(p) }
+func (e *echoTerminal) Close() error                { return e.w.Close() }
+
+func TestConfigDriver(t *testing.T) {
+	t.Parallel()
+
+	fake := DriverFunc(func(cc *ConnectConfig) (terminal.Terminal, error) { return newEchoTerminal(), nil })
+	cfg := &Config{Drivers: map[string]Driver{"ssh": fake}}
+
+	if !cfg.isBuiltinDriver("host") || cfg.isBuiltinDriver("ssh") {
+		t.Fatal("registered drivers must replace the built-in ones")
+	}
+	session, err := connect(cfg, &ConnectConfig{Driver: "ssh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := session.(*echoTerminal); !ok {
+		t.Fatalf("connect used %T, want the registered driver", session)
+	}
+}
+
+func TestMiddleware_registeredDriver(t *testing.T) {
+	t.Parallel()
+
+	connected := make(chan *ConnectConfig, 1)
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{Config: &Config{
+		Driver: "echo",
+		Shell:  "/bin/repl",
+		Drivers: map[string]Driver{
+			"echo": DriverFunc(func(cc *ConnectConfig) (terminal.Terminal, error) {
+				connected <- cc
+				return newEchoTerminal(), nil
+			}),
+		},
+	}}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?workdir=/data", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	send := func(msg *message.Message) {
+		t.Helper()
+		if err := msg.Serialize(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.WriteMessage(websocket.BinaryMessage, msg.Msg()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	connect := &message.Message{}
+	connect.SetType(message.TypeConnect)
+	connect.SetConnect(&message.Connect{})
+	send(connect)
+
+	select {
+	case cc := <-connected:
+		if cc.Driver != "echo" || cc.Shell != "/bin/repl" || cc.WorkDir != "/data" || cc.SSHWorkDir != "/data" {
+			t.Fatalf("connect config = %+v", cc)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("registered driver was not called")
+	}
+
+	key := &message.Message{}
+	key.SetType(message.TypeKey)
+	key.SetKey([]byte("1+1\r"))
+	send(key)
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, b, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := message.Deserialize(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type() == message.TypeOutput && strings.Contains(string(msg.Output()), "1+1") {
+			return
+		}
+	}
+}
+
+func TestMiddleware_sshWorkDirOnlyFromClient(t *testing.T) {
+	t.Parallel()
+
+	connected := make(chan *ConnectConfig, 1)
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{Config: &Config{
+		Driver:  "echo",
+		WorkDir: "/srv/local",
+		Drivers: map[string]Driver{
+			"echo": DriverFunc(func(cc *ConnectConfig) (terminal.Terminal, error) {
+				connected <- cc
+				return newEchoTerminal(), nil
+			}),
+		},
+	}}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	connect := &message.Message{}
+	connect.SetType(message.TypeConnect)
+	connect.SetConnect(&message.Connect{})
+	if err := connect.Serialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, connect.Msg()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case cc := <-connected:
+		if cc.WorkDir != "/srv/local" || cc.SSHWorkDir != "" {
+			t.Fatalf("WorkDir = %q, SSHWorkDir = %q, want the server default only locally", cc.WorkDir, cc.SSHWorkDir)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("registered driver was not called")
+	}
+}
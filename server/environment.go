@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/go-zoox/terminal"
+	"github.com/go-zoox/websocket"
+)
+
+// defaultSessionEnvPrefix prefixes the session context variables when
+// Config.SessionEnvPrefix is empty.
+const defaultSessionEnvPrefix = "TERMINAL_"
+
+var sessionEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sessionEnvNoValue is what text/template prints for a missing map key, e.g. a
+// claim the session does not have; it is rendered empty.
+const sessionEnvNoValue = "<no value>"
+
+// SessionContext describes a new session to its shell: the server sets it as
+// <prefix>SESSION_ID, <prefix>USER, ... and Config.SessionEnv templates are
+// executed with it, e.g. {{.User}} or {{.Claims.email}}.
+type SessionContext struct {
+	SessionID string
+	// User and AuthMethod are the authenticated identity (Identity.Subject and
+	// Identity.Method), empty without authentication.
+	User       string
+	AuthMethod string
+	// Claims are the verified JWT claims, nil for other methods.
+	Claims     map[string]interface{}
+	RemoteAddr string
+	UserAgent  string
+	Driver     string
+	// Version is the server version.
+	Version string
+}
+
+// sessionEnv adds the session context to the environment of new sessions.
+type sessionEnv struct {
+	prefix string
+	extra  map[string]*template.Template
+}
+
+// newSessionEnv returns the session environment configured in cfg.
+func newSessionEnv(cfg *Config) (*sessionEnv, error) {
+	e := &sessionEnv{prefix: cfg.SessionEnvPrefix, extra: make(map[string]*template.Template, len(cfg.SessionEnv))}
+	if e.prefix == "" {
+		e.prefix = defaultSessionEnvPrefix
+	}
+	if !sessionEnvName.MatchString(e.prefix + "SESSION_ID") {
+		return nil, fmt.Errorf("invalid session env prefix %q", e.prefix)
+	}
+	for name, text := range cfg.SessionEnv {
+		if !sessionEnvName.MatchString(name) {
+			return nil, fmt.Errorf("invalid session env name %q", name)
+		}
+		tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session env %s: %s", name, err)
+		}
+		e.extra[name] = tmpl
+	}
+	return e, nil
+}
+
+// newSessionContext returns the context of session sessionID started on conn.
+func newSessionContext(conn websocket.Conn, sessionID string, cc *ConnectConfig) *SessionContext {
+	ctx := &SessionContext{
+		SessionID:  sessionID,
+		RemoteAddr: conn.Request().RemoteAddr,
+		UserAgent:  conn.Request().UserAgent(),
+		Driver:     cc.Driver,
+		Version:    terminal.Version,
+	}
+	if identity, ok := IdentityFromRequest(conn.Request()); ok {
+		ctx.User, ctx.AuthMethod, ctx.Claims = identity.Subject, identity.Method, identity.Claims
+	}
+	return ctx
+}
+
+// apply adds the variables of ctx to cc.Environment. They replace variables
+// the client sent, so scripts can trust them; all of them are set, empty when
+// unknown, e.g. a claim of a session without JWT.
+func (e *sessionEnv) apply(cc *ConnectConfig, ctx *SessionContext) error {
+	env := make(map[string]string, len(cc.Environment)+6+len(e.extra))
+	for k, v := range cc.Environment {
+		env[k] = v
+	}
+
+	env[e.prefix+"SESSION_ID"] = ctx.SessionID
+	env[e.prefix+"USER"] = ctx.User
+	env[e.prefix+"AUTH_METHOD"] = ctx.AuthMethod
+	env[e.prefix+"REMOTE_ADDR"] = ctx.RemoteAddr
+	env[e.prefix+"USER_AGENT"] = ctx.UserAgent
+	env[e.prefix+"SERVER_VERSION"] = ctx.Version
+
+	for name, tmpl := range e.extra {
+		var b strings.Builder
+		if err := tmpl.Execute(&b, ctx); err != nil {
+			return fmt.Errorf("session env %s: %s", name, err)
+		}
+		env[name] = strings.ReplaceAll(b.String(), sessionEnvNoValue, "")
+	}
+
+	cc.Environment = env
+	return nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/command/terminal"
+	"github.com/go-zoox/terminal/message"
+	"github.com/go-zoox/zoox"
+	"github.com/gorilla/websocket"
+)
+
+func TestSessionEnv(t *testing.T) {
+	t.Parallel()
+
+	env, err := newSessionEnv(&Config{
+		SessionEnvPrefix: "WEB_",
+		SessionEnv: map[string]string{
+			"GIT_AUTHOR_EMAIL": "{{.Claims.email}}",
+			"SESSION_URL":      "https://terminal.example.com/?session_id={{.SessionID}}",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := &ConnectConfig{Environment: map[string]string{"LANG": "C", "WEB_USER": "root", "WEB_AUTH_METHOD": "basic"}}
+	err = env.apply(cc, &SessionContext{
+		SessionID: "s1",
+		User:      "alice",
+		Claims:    map[string]interface{}{"email": "alice@example.com"},
+		UserAgent: "curl/8",
+		Version:   "1.0.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range map[string]string{
+		"LANG":               "C",
+		"WEB_SESSION_ID":     "s1",
+		"WEB_USER":           "alice",
+		"WEB_AUTH_METHOD":    "",
+		"WEB_USER_AGENT":     "curl/8",
+		"WEB_SERVER_VERSION": "1.0.0",
+		"GIT_AUTHOR_EMAIL":   "alice@example.com",
+		"SESSION_URL":        "https://terminal.example.com/?session_id=s1",
+	} {
+		if got, ok := cc.Environment[k]; !ok || got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+
+	// sessions without JWT claims get the variable empty
+	cc = &ConnectConfig{}
+	if err := env.apply(cc, &SessionContext{SessionID: "s2", User: "bob", AuthMethod: "basic"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := cc.Environment["GIT_AUTHOR_EMAIL"]; !ok || got != "" {
+		t.Errorf("GIT_AUTHOR_EMAIL = %q, want empty", got)
+	}
+	cc = &ConnectConfig{}
+	if err := env.apply(cc, &SessionContext{SessionID: "s3", Claims: map[string]interface{}{"sub": "carol"}}); err != nil || cc.Environment["GIT_AUTHOR_EMAIL"] != "" {
+		t.Errorf("missing claim: %v, %q", err, cc.Environment["GIT_AUTHOR_EMAIL"])
+	}
+}
+
+func TestSessionEnv_invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, extra := range []map[string]string{
+		{"A-B": "x"},
+		{"A": "{{.User"},
+	} {
+		if _, err := newSessionEnv(&Config{SessionEnv: extra}); err == nil {
+			t.Errorf("%v accepted", extra)
+		}
+	}
+	for _, prefix := range []string{"WEB-", "1_", "A B"} {
+		if _, err := newSessionEnv(&Config{SessionEnvPrefix: prefix}); err == nil {
+			t.Errorf("prefix %q accepted", prefix)
+		}
+	}
+}
+
+func TestMiddleware_sessionEnv(t *testing.T) {
+	t.Parallel()
+
+	connected := make(chan *ConnectConfig, 1)
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{
+		Username: "alice",
+		Password: "secret",
+		Config: &Config{
+			Driver: "echo",
+			Drivers: map[string]Driver{
+				"echo": DriverFunc(func(cc *ConnectConfig) (terminal.Terminal, error) {
+					connected <- cc
+					return newEchoTerminal(), nil
+				}),
+			},
+		},
+	}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	header := http.Header{"User-Agent": {"env-test"}}
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.SetBasicAuth("alice", "secret")
+	header.Set("Authorization", req.Header.Get("Authorization"))
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?environment=TERMINAL_USER=root", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	connect := &message.Message{}
+	connect.SetType(message.TypeConnect)
+	connect.SetConnect(&message.Connect{})
+	if err := connect.Serialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, connect.Msg()); err != nil {
+		t.Fatal(err)
+	}
+
+	var cc *ConnectConfig
+	select {
+	case cc = <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("driver was not called")
+	}
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, b, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := message.Deserialize(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.Type() != message.TypeConnect {
+		t.Fatalf("got message type %v, want the connect ack", ack.Type())
+	}
+
+	env := cc.Environment
+	if env["TERMINAL_SESSION_ID"] != ack.Connect().SessionID {
+		t.Errorf("TERMINAL_SESSION_ID = %q, want %q", env["TERMINAL_SESSION_ID"], ack.Connect().SessionID)
+	}
+	if env["TERMINAL_USER"] != "alice" || env["TERMINAL_AUTH_METHOD"] != "basic" {
+		t.Errorf("identity = %q (%q)", env["TERMINAL_USER"], env["TERMINAL_AUTH_METHOD"])
+	}
+	if env["TERMINAL_USER_AGENT"] != "env-test" || !strings.HasPrefix(env["TERMINAL_REMOTE_ADDR"], "127.0.0.1:") {
+		t.Errorf("client = %q from %q", env["TERMINAL_USER_AGENT"], env["TERMINAL_REMOTE_ADDR"])
+	}
+}
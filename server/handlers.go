@@ -14,12 +14,21 @@ type PageConfig struct {
 	WSPath string
 	// WelcomeMessage is shown in the terminal after connect (optional).
 	WelcomeMessage string
+
+	// csrf, when set, embeds a CSRF token for the WebSocket upgrade in the page.
+	csrf *csrfTokens
+	// shareSecret, when set, opens the session of a ?share= link.
+	shareSecret string
 }
 
 // PageHandler returns a zoox handler that serves the embedded xterm HTML page.
 // WSPath defaults to "/ws" if empty.
 func PageHandler(page PageConfig) zoox.HandlerFunc {
 	return func(ctx *zoox.Context) {
+		if page.shareSecret != "" && !withShareIdentity(page.shareSecret, ctx) {
+			return
+		}
+
 		ws := page.WSPath
 		if ws == "" {
 			ws = "/ws"
@@ -31,13 +40,27 @@ func PageHandler(page PageConfig) zoox.HandlerFunc {
 		if page.WelcomeMessage != "" {
 			h["welcomeMessage"] = page.WelcomeMessage
 		}
+		if id, ok := IdentityFromRequest(ctx.Request); ok && id.Share != nil {
+			h["shareSessionId"] = id.Share.SessionID
+			h["readOnly"] = id.Share.ReadOnly()
+		}
+		if page.csrf != nil {
+			h["csrfToken"] = page.csrf.Mint(identitySubject(ctx.Request))
+			ctx.Set("Cache-Control", "no-store")
+		}
 		ctx.Data(200, "text/html; charset=utf-8", []byte(RenderXTerm(h)))
 	}
 }
 
 // WebSocketHandler returns an option callback for zoox.RouterGroup.WebSocket.
 // It wires the PTY server built from cfg. A nil cfg is treated as zero Config.
+// Browser upgrades are only accepted from the same origin (see Register for an
+// allow-list).
 func WebSocketHandler(cfg *Config) func(opt *zoox.WebSocketOption) {
+	return webSocketHandler(cfg, newUpgradeGuard(nil, nil))
+}
+
+func webSocketHandler(cfg *Config, guard *upgradeGuard) func(opt *zoox.WebSocketOption) {
 	c := normalizeConfig(cfg)
 	return func(opt *zoox.WebSocketOption) {
 		s, err := Serve(c)
@@ -45,6 +68,7 @@ func WebSocketHandler(cfg *Config) func(opt *zoox.WebSocketOption) {
 			panic(fmt.Errorf("failed to create websocket server: %w", err))
 		}
 		opt.Server = s
+		opt.Middlewares = append(opt.Middlewares, shareMiddleware(c.ShareSecret), guard.Middleware)
 	}
 }
 
@@ -56,5 +80,18 @@ func normalizeConfig(cfg *Config) *Config {
 	if c.Driver == "" {
 		c.Driver = "host"
 	}
+	if c.ShareSecret == "" {
+		c.ShareSecret = randomShareSecret()
+	}
+	if c.audit == nil {
+		audit, err := newAuditLog(&c)
+		if err != nil {
+			panic(fmt.Errorf("terminal: %w", err))
+		}
+		c.audit = audit
+	}
+	if c.sessions == nil {
+		c.sessions = newConfigSessionRegistry(&c)
+	}
 	return &c
 }
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/go-zoox/logger"
+)
+
+// newSessionHome creates the ephemeral home directory of a session, seeded from
+// Config.EphemeralHomeTemplate and owned by username when set.
+func newSessionHome(cfg *Config, username string) (string, error) {
+	dir, err := os.MkdirTemp(cfg.EphemeralHomeDir, "terminal-home-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create session home: %s", err)
+	}
+	if err := seedSessionHome(cfg, dir, username); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return dir, nil
+}
+
+// seedSessionHome copies the template into dir and hands it to username.
+func seedSessionHome(cfg *Config, dir, username string) error {
+	if cfg.EphemeralHomeTemplate != "" {
+		if err := copyDir(cfg.EphemeralHomeTemplate, dir); err != nil {
+			return fmt.Errorf("failed to copy session home template: %s", err)
+		}
+	}
+
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return err
+		}
+		uid, _ := strconv.Atoi(u.Uid)
+		gid, _ := strconv.Atoi(u.Gid)
+		err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, uid, gid)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to chown session home: %s", err)
+		}
+	}
+	return nil
+}
+
+// removeSessionHome removes the session home once the session is over; with
+// Config.EphemeralHomeKeepOnFailure it stays when the session failed.
+func removeSessionHome(cfg *Config, dir string, failed bool) {
+	if failed && cfg.EphemeralHomeKeepOnFailure {
+		logger.Warnf("[session home] kept %s of a failed session", dir)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		logger.Errorf("[session home] failed to remove %s: %s", dir, err)
+	}
+}
+
+// copyDir copies the files, directories and symlinks in src to the existing
+// directory dst, keeping their permissions.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case d.IsDir():
+			if rel == "." {
+				return os.Chmod(dst, info.Mode().Perm())
+			}
+			return os.Mkdir(target, info.Mode().Perm())
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			// sockets, devices and pipes are not copied
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/terminal/message"
+	"github.com/go-zoox/zoox"
+)
+
+func TestNewSessionHome(t *testing.T) {
+	t.Parallel()
+
+	template := t.TempDir()
+	os.MkdirAll(filepath.Join(template, ".config", "app"), 0755)
+	os.WriteFile(filepath.Join(template, ".bashrc"), []byte("PS1='$ '\n"), 0644)
+	os.WriteFile(filepath.Join(template, ".config", "app", "run.sh"), []byte("#!/bin/sh\n"), 0755)
+	os.Symlink(".bashrc", filepath.Join(template, ".profile"))
+
+	cfg := &Config{EphemeralHomeDir: t.TempDir(), EphemeralHomeTemplate: template}
+	home, err := newSessionHome(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(home) != cfg.EphemeralHomeDir {
+		t.Fatalf("home %s not in %s", home, cfg.EphemeralHomeDir)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(home, ".profile")); err != nil || string(b) != "PS1='$ '\n" {
+		t.Fatalf(".profile = %q, %v", b, err)
+	}
+	if info, err := os.Stat(filepath.Join(home, ".config", "app", "run.sh")); err != nil || info.Mode().Perm() != 0755 {
+		t.Fatalf("run.sh: %v, %v", info, err)
+	}
+
+	// a second session starts from the template again
+	other, err := newSessionHome(cfg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == home {
+		t.Fatal("sessions share a home")
+	}
+
+	if _, err := newSessionHome(&Config{EphemeralHomeDir: cfg.EphemeralHomeDir, EphemeralHomeTemplate: "/nonexistent"}, ""); err == nil {
+		t.Fatal("missing template accepted")
+	}
+	if entries, _ := os.ReadDir(cfg.EphemeralHomeDir); len(entries) != 2 {
+		t.Fatalf("%d homes left, want 2", len(entries))
+	}
+}
+
+func TestMiddleware_ephemeralHomeUserNeedsSandbox(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{
+		Config: &Config{Shell: "/bin/sh", User: "nobody", EphemeralHome: true, EphemeralHomeDir: dir},
+	}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	msg := openSession(t, srv, "", "", "")
+	if msg.Type() != message.TypeExit || !strings.Contains(msg.Exit().Message, "requires the sandbox") {
+		t.Fatalf("got message type %v, want an exit", msg.Type())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("session home created: %v", entries)
+	}
+}
+
+func TestRemoveSessionHome(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		keepOnFailure, failed, kept bool
+	}{
+		{false, false, false},
+		{false, true, false},
+		{true, false, false},
+		{true, true, true},
+	} {
+		cfg := &Config{EphemeralHomeDir: t.TempDir(), EphemeralHomeKeepOnFailure: tc.keepOnFailure}
+		home, _ := newSessionHome(cfg, "")
+		removeSessionHome(cfg, home, tc.failed)
+		if _, err := os.Stat(home); (err == nil) != tc.kept {
+			t.Errorf("keep on failure %v, failed %v: kept = %v", tc.keepOnFailure, tc.failed, err == nil)
+		}
+	}
+}
+
+func TestSessionRegistry_cleanup(t *testing.T) {
+	t.Parallel()
+
+	reg := newSessionRegistry(SessionRegistryConfig{TTL: time.Hour})
+
+	run := func(exitCode int, evict bool) bool {
+		sess := &chanTerminal{mockTerminal: mockTerminal{exitCode: exitCode}, out: make(chan []byte)}
+		id := reg.Register(sess)
+		failed := make(chan bool, 1)
+		reg.SetCleanup(id, func(f bool) { failed <- f })
+		reg.AttachWriter(id, &syncBridgeConn{})
+
+		if evict {
+			en := reg.entry(id)
+			reg.deleteID(id)
+			idleEvictSessionEntry(en, id, time.Now())
+		}
+		close(sess.out)
+
+		select {
+		case f := <-failed:
+			return f
+		case <-time.After(5 * time.Second):
+			t.Fatal("cleanup did not run")
+			return false
+		}
+	}
+
+	if run(0, false) {
+		t.Error("exit 0 counted as failure")
+	}
+	if !run(2, false) {
+		t.Error("exit 2 not counted as failure")
+	}
+	if run(-1, true) {
+		t.Error("idle eviction counted as failure")
+	}
+}
@@ -87,6 +87,20 @@ func RenderXTerm(data zoox.H) string {
 				overscroll-behavior: contain;
 			}
 
+			/* Shown while the connection is read-only (share link, read-only user or ?read_only=1) */
+			.read-only-banner {
+				flex: 0 0 auto;
+				margin-bottom: 6px;
+				padding: 4px 10px;
+				border-radius: 6px;
+				background: #3a2e00;
+				color: #ffd60a;
+				font: 12px/1.5 Menlo, Monaco, "Courier New", monospace;
+			}
+			.read-only-banner[hidden] {
+				display: none;
+			}
+
 			/* Mobile-only disconnect overlay (shown from JS when coarse pointer or narrow viewport) */
 			.disconnect-modal {
 				display: none;
@@ -148,6 +162,7 @@ func RenderXTerm(data zoox.H) string {
 		</style>
 	</head>
 	<body>
+		<div id="read-only-banner" class="read-only-banner" role="status" hidden>Read-only: you can watch this session but not type into it.</div>
 		<div id="terminal"></div>
 		<div id="disconnect-modal" class="disconnect-modal" hidden aria-hidden="true">
 			<div class="disconnect-modal__backdrop" aria-hidden="true"></div>
@@ -174,6 +189,7 @@ func RenderXTerm(data zoox.H) string {
 				Output: '6',
 				Exit: '7',
 				HeartBeat: '8',
+				Error: '9',
 			};
 			var config = `)
 	b.Write(jd)
@@ -227,10 +243,75 @@ func RenderXTerm(data zoox.H) string {
 				fontFamily: 'Menlo, Monaco, "Courier New", monospace',
 				fontWeight: 400,
 				fontSize: narrow ? 12 : 14,
+				disableStdin: !!config.readOnly,
 			});
 			var fitAddon = new FitAddon.FitAddon();
 			term.loadAddon(fitAddon);
 
+			/* Shell integration: OSC 133 / 633 "A" marks each prompt; Ctrl+Shift+Up/Down
+			   scroll to the previous / next command. */
+			var commandMarkers = [];
+			function markCommand(data) {
+				if (data.charAt(0) === 'A') {
+					var marker = term.registerMarker(0);
+					if (marker) {
+						commandMarkers.push(marker);
+					}
+				}
+				return false;
+			}
+			term.parser.registerOscHandler(133, markCommand);
+			term.parser.registerOscHandler(633, markCommand);
+
+			function clearCommandMarkers() {
+				commandMarkers.forEach(function (marker) {
+					marker.dispose();
+				});
+				commandMarkers = [];
+			}
+
+			function jumpToCommand(direction) {
+				commandMarkers = commandMarkers.filter(function (marker) {
+					return !marker.isDisposed && marker.line >= 0;
+				});
+				var top = term.buffer.active.viewportY;
+				var target = null;
+				for (var i = 0; i < commandMarkers.length; i++) {
+					var line = commandMarkers[i].line;
+					if (direction < 0 && line < top) {
+						target = line;
+					} else if (direction > 0 && line > top) {
+						target = line;
+						break;
+					}
+				}
+				if (target !== null) {
+					term.scrollToLine(target);
+				} else if (direction > 0) {
+					term.scrollToBottom();
+				}
+			}
+
+			term.attachCustomKeyEventHandler(function (ev) {
+				if (ev.type === 'keydown' && ev.ctrlKey && ev.shiftKey && (ev.key === 'ArrowUp' || ev.key === 'ArrowDown')) {
+					jumpToCommand(ev.key === 'ArrowUp' ? -1 : 1);
+					return false;
+				}
+				return true;
+			});
+
+			/* The server decides: the connect reply says whether this connection is read-only. */
+			var readOnly = false;
+			function setReadOnly(value) {
+				readOnly = !!value;
+				term.options.disableStdin = readOnly;
+				var banner = document.getElementById('read-only-banner');
+				if (banner) {
+					banner.hidden = !readOnly;
+				}
+				scheduleFitAfterLayout();
+			}
+
 			function scheduleFitAfterLayout() {
 				if (!term.element) {
 					return;
@@ -255,6 +336,7 @@ func RenderXTerm(data zoox.H) string {
 				try {
 					term.clear();
 				} catch (e) {}
+				clearCommandMarkers();
 			}
 
 			function scrollTermToBottomIfMobile() {
@@ -368,9 +450,10 @@ func RenderXTerm(data zoox.H) string {
 
 					try {
 						var data = JSON.parse(String.fromCharCode.apply(null, payload));
-						if (data && data.session_id) {
+						if (data && data.session_id && !config.shareSessionId) {
 							session.set(data.session_id);
 						}
+						setReadOnly(config.readOnly || (data && data.read_only));
 					} catch (e) {
 						console.error('failed to parse connect data:', e)
 					}
@@ -386,6 +469,10 @@ func RenderXTerm(data zoox.H) string {
 						var ex = JSON.parse(String.fromCharCode.apply(null, payload));
 						console.warn('terminal session exit', ex);
 					} catch (e) {}
+				} else if (typ === messageType.Error.charCodeAt(0)) {
+					try {
+						console.warn('terminal error', JSON.parse(String.fromCharCode.apply(null, payload)));
+					} catch (e) {}
 				} else if (typ === messageType.HeartBeat.charCodeAt(0)) {
 					if (ws && ws.readyState === WebSocket.OPEN) {
 						ws.send(messageType.HeartBeat + 'null');
@@ -394,7 +481,12 @@ func RenderXTerm(data zoox.H) string {
 			}
 
 			function openWebSocket() {
-				ws = new WebSocket(protocol + '://' + url.host + config.wsPath + window.location.search);
+				var wsQuery = new URLSearchParams(window.location.search);
+				if (config.csrfToken) {
+					wsQuery.set('csrf_token', config.csrfToken);
+				}
+				var wsSearch = wsQuery.toString();
+				ws = new WebSocket(protocol + '://' + url.host + config.wsPath + (wsSearch ? '?' + wsSearch : ''));
 				ws.binaryType = 'arraybuffer';
 				ws.onopen = function () {
 					hideMobileDisconnectModal();
@@ -402,7 +494,8 @@ func RenderXTerm(data zoox.H) string {
 					if (btn) {
 						btn.disabled = false;
 					}
-					var sessionID = session.get();
+					/* A share link opens the session it was minted for. */
+					var sessionID = config.shareSessionId || session.get();
 					if (!!sessionID) {
 						clearTerminalBeforeSessionReconnect();
 						ws.send(messageType.Connect + JSON.stringify({ session_id: sessionID }));
@@ -493,7 +586,7 @@ func RenderXTerm(data zoox.H) string {
 			});
 
 			term.onData((data) => {
-				if (!handshakeComplete || !ws || ws.readyState !== WebSocket.OPEN) {
+				if (readOnly || !handshakeComplete || !ws || ws.readyState !== WebSocket.OPEN) {
 					return;
 				}
 				ws.send(messageType.Key + data);
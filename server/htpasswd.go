@@ -0,0 +1,223 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/logger"
+	"github.com/go-zoox/terminal/message"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionDefaults are per-user session settings, e.g. from an htpasswd entry.
+// User, Driver and Image are enforced for the user's sessions; Shell and WorkDir
+// are defaults the client may still override. All of them take precedence over
+// the Config defaults. ReadOnly connections may watch but not type. Admin users
+// may reconnect to and inspect the sessions of other users.
+type SessionDefaults struct {
+	User     string
+	Driver   string
+	Image    string
+	Shell    string
+	WorkDir  string
+	ReadOnly bool
+	Admin    bool
+}
+
+// apply sets the defaults on a connect request: enforced fields always, the
+// others only when the client did not choose.
+func (d *SessionDefaults) apply(data *message.Connect) {
+	if d.User != "" {
+		data.User = d.User
+	}
+	if d.Driver != "" {
+		data.Driver = d.Driver
+	}
+	if d.Image != "" {
+		data.Image = d.Image
+	}
+	if data.Shell == "" {
+		data.Shell = d.Shell
+	}
+	if data.WorkDir == "" {
+		data.WorkDir = d.WorkDir
+	}
+}
+
+// htpasswdEntry is one user of an htpasswd file.
+type htpasswdEntry struct {
+	hash     []byte
+	defaults SessionDefaults
+}
+
+// htpasswdFile authenticates against an htpasswd-style file of bcrypt hashes
+// (htpasswd -B), re-read whenever it changes. A line may carry a third field
+// with the user's session defaults:
+//
+//	alice:$2y$10$...:user=alice,shell=/bin/zsh,workdir=/home/alice
+//	bob:$2y$10$...:driver=docker,image=ubuntu:22.04
+//	carol:$2y$10$...:read_only=true
+//	dave:$2y$10$...:admin=true
+//
+// Apache ignores fields after the hash, so the file stays usable there.
+type htpasswdFile struct {
+	path string
+
+	mu      sync.Mutex
+	users   map[string]*htpasswdEntry
+	modTime time.Time
+	size    int64
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// compareDummyHash spends the time of a bcrypt comparison for unknown users, so a
+// wrong username costs the same as a wrong password.
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("go-zoox/terminal"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+func newHTPasswdFile(path string) *htpasswdFile {
+	f := &htpasswdFile{path: path}
+	if _, err := f.load(); err != nil {
+		panic(fmt.Errorf("terminal htpasswd: %w", err))
+	}
+	return f
+}
+
+// Authenticate checks username and password and returns the identity of the user.
+func (f *htpasswdFile) Authenticate(username, password string) (*Identity, bool) {
+	users, err := f.load()
+	if err != nil {
+		// keep serving with the last good file
+		logger.Errorf("[htpasswd] %s", err)
+	}
+
+	entry, ok := users[username]
+	if !ok {
+		compareDummyHash(password)
+		return nil, false
+	}
+	if bcrypt.CompareHashAndPassword(entry.hash, []byte(password)) != nil {
+		return nil, false
+	}
+
+	defaults := entry.defaults
+	return &Identity{
+		Subject:  username,
+		Method:   "basic",
+		Defaults: &defaults,
+	}, true
+}
+
+// load returns the users, re-reading the file when its mtime or size changed.
+func (f *htpasswdFile) load() (map[string]*htpasswdEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return f.users, fmt.Errorf("failed to read %s: %s", f.path, err)
+	}
+	if f.users != nil && info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.users, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return f.users, fmt.Errorf("failed to read %s: %s", f.path, err)
+	}
+
+	users, err := parseHTPasswd(data)
+	if err != nil {
+		return f.users, fmt.Errorf("invalid %s: %s", f.path, err)
+	}
+
+	if f.users != nil {
+		logger.Infof("[htpasswd] reloaded %s (%d users)", f.path, len(users))
+	}
+	f.users = users
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return users, nil
+}
+
+func parseHTPasswd(data []byte) (map[string]*htpasswdEntry, error) {
+	users := map[string]*htpasswdEntry{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("line %d: expected user:hash", lineNo)
+		}
+
+		username, hash := fields[0], fields[1]
+		if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
+			return nil, fmt.Errorf("line %d: user %s: only bcrypt hashes are supported (htpasswd -B)", lineNo, username)
+		}
+
+		entry := &htpasswdEntry{hash: []byte(hash)}
+		if len(fields) == 3 {
+			for _, kv := range strings.Split(fields[2], ",") {
+				if strings.TrimSpace(kv) == "" {
+					continue
+				}
+
+				k, v, ok := strings.Cut(kv, "=")
+				if !ok {
+					return nil, fmt.Errorf("line %d: user %s: expected key=value, got %q", lineNo, username, kv)
+				}
+				k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+
+				switch k {
+				case "user":
+					entry.defaults.User = v
+				case "driver":
+					entry.defaults.Driver = v
+				case "image":
+					entry.defaults.Image = v
+				case "shell":
+					entry.defaults.Shell = v
+				case "workdir":
+					entry.defaults.WorkDir = v
+				case "read_only":
+					readOnly, err := strconv.ParseBool(v)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: user %s: invalid read_only %q", lineNo, username, v)
+					}
+					entry.defaults.ReadOnly = readOnly
+				case "admin":
+					admin, err := strconv.ParseBool(v)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: user %s: invalid admin %q", lineNo, username, v)
+					}
+					entry.defaults.Admin = admin
+				default:
+					return nil, fmt.Errorf("line %d: user %s: unknown field %q, options: user, driver, image, shell, workdir, read_only, admin", lineNo, username, k)
+				}
+			}
+		}
+
+		users[username] = entry
+	}
+
+	return users, scanner.Err()
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/command/terminal"
+	"github.com/go-zoox/terminal/message"
+	"github.com/go-zoox/zoox"
+	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestHTPasswdFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# team\n" +
+		"alice:" + bcryptHash(t, "alice-pw") + ":user=alice,shell=/bin/zsh,workdir=/home/alice\n" +
+		"bob:" + bcryptHash(t, "bob-pw") + ":driver=docker,image=ubuntu:22.04,read_only=true\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newHTPasswdFile(path)
+
+	id, ok := f.Authenticate("alice", "alice-pw")
+	if !ok {
+		t.Fatal("alice rejected")
+	}
+	if id.Subject != "alice" || *id.Defaults != (SessionDefaults{User: "alice", Shell: "/bin/zsh", WorkDir: "/home/alice"}) {
+		t.Fatalf("identity = %+v %+v", id, id.Defaults)
+	}
+
+	id, ok = f.Authenticate("bob", "bob-pw")
+	if !ok || id.Defaults.Image != "ubuntu:22.04" || id.Defaults.Driver != "docker" || !id.ReadOnly() {
+		t.Fatalf("bob = %v %+v", ok, id)
+	}
+
+	if _, ok := f.Authenticate("alice", "bob-pw"); ok {
+		t.Fatal("wrong password accepted")
+	}
+	if _, ok := f.Authenticate("mallory", "x"); ok {
+		t.Fatal("unknown user accepted")
+	}
+
+	// reload: bob removed, carol added
+	content = "carol:" + bcryptHash(t, "carol-pw") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second))
+
+	if _, ok := f.Authenticate("bob", "bob-pw"); ok {
+		t.Fatal("removed user accepted after reload")
+	}
+	if _, ok := f.Authenticate("carol", "carol-pw"); !ok {
+		t.Fatal("added user rejected after reload")
+	}
+
+	// a broken file keeps the last good users
+	os.WriteFile(path, []byte("carol:plaintext\n"), 0600)
+	os.Chtimes(path, time.Now().Add(2*time.Second), time.Now().Add(2*time.Second))
+	if _, ok := f.Authenticate("carol", "carol-pw"); !ok {
+		t.Fatal("invalid file replaced the last good users")
+	}
+}
+
+func TestParseHTPasswd_invalid(t *testing.T) {
+	t.Parallel()
+
+	cases := []string{
+		"alice\n",
+		"alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n",
+		"alice:$2y$05$abcdefghijklmnopqrstuv:os_user=root\n",
+		"alice:$2y$05$abcdefghijklmnopqrstuv:read_only=maybe\n",
+	}
+	for _, c := range cases {
+		if _, err := parseHTPasswd([]byte(c)); err == nil {
+			t.Errorf("parseHTPasswd(%q): expected error", c)
+		}
+	}
+}
+
+func TestSessionDefaults_apply(t *testing.T) {
+	t.Parallel()
+
+	d := &SessionDefaults{User: "alice", Driver: "host", Shell: "/bin/zsh", WorkDir: "/home/alice"}
+	data := &message.Connect{User: "root", Driver: "docker", Shell: "/bin/bash"}
+	d.apply(data)
+
+	if data.User != "alice" || data.Driver != "host" {
+		t.Fatalf("enforced fields not applied: %+v", data)
+	}
+	if data.Shell != "/bin/bash" || data.WorkDir != "/home/alice" {
+		t.Fatalf("defaults = %+v", data)
+	}
+}
+
+func TestBasicAuth_htpasswd(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	os.WriteFile(path, []byte("alice:"+bcryptHash(t, "pw")+":user=alice\n"), 0600)
+
+	auth := newAuthenticator("admin", "admin", path, nil)
+
+	for _, tc := range []struct {
+		user, pass string
+		subject    string
+	}{
+		{"admin", "admin", "admin"},
+		{"alice", "pw", "alice"},
+		{"alice", "admin", ""},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.SetBasicAuth(tc.user, tc.pass)
+		id, _ := auth.Authenticate(req)
+
+		if tc.subject == "" {
+			if id != nil {
+				t.Errorf("%s:%s accepted", tc.user, tc.pass)
+			}
+			continue
+		}
+		if id == nil || id.Subject != tc.subject {
+			t.Errorf("%s:%s = %+v", tc.user, tc.pass, id)
+		}
+	}
+}
+
+func TestIdentity_ReadOnly(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		id   *Identity
+		want bool
+	}{
+		{&Identity{Subject: "alice", Method: "basic"}, false},
+		{&Identity{Defaults: &SessionDefaults{ReadOnly: true}}, true},
+		{&Identity{Claims: map[string]interface{}{"read_only": true}}, true},
+		{&Identity{Claims: map[string]interface{}{"read_only": "false"}}, false},
+		{&Identity{Share: &Share{Role: ShareRoleReadOnly}}, true},
+		{&Identity{Share: &Share{Role: ShareRoleReadWrite}}, false},
+	} {
+		if got := tc.id.ReadOnly(); got != tc.want {
+			t.Errorf("%+v: ReadOnly() = %v, want %v", tc.id, got, tc.want)
+		}
+	}
+}
+
+// openSession connects to the terminal WebSocket of srv as user and returns the
+// first server message: the connect ack, or the exit when refused.
+func openSession(t *testing.T, srv *httptest.Server, user, password, sessionID string) *message.Message {
+	t.Helper()
+
+	header := http.Header{}
+	if user != "" {
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.SetBasicAuth(user, password)
+		header.Set("Authorization", req.Header.Get("Authorization"))
+	}
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+
+	connect := &message.Message{}
+	connect.SetType(message.TypeConnect)
+	connect.SetConnect(&message.Connect{SessionID: sessionID})
+	if err := connect.Serialize(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, connect.Msg()); err != nil {
+		t.Fatal(err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, b, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := message.Deserialize(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestMiddleware_reconnectOnlyOwner(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	os.WriteFile(path, []byte("alice:"+bcryptHash(t, "pw")+"\n"+
+		"bob:"+bcryptHash(t, "pw")+"\n"+
+		"carol:"+bcryptHash(t, "pw")+":admin=true\n"), 0600)
+
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{
+		HTPasswdFile: path,
+		Config: &Config{
+			Driver: "echo",
+			Drivers: map[string]Driver{
+				"echo": DriverFunc(func(cc *ConnectConfig) (terminal.Terminal, error) { return newEchoTerminal(), nil }),
+			},
+		},
+	}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	ack := openSession(t, srv, "alice", "pw", "")
+	if ack.Type() != message.TypeConnect {
+		t.Fatalf("alice: got message type %v", ack.Type())
+	}
+	id := ack.Connect().SessionID
+
+	if msg := openSession(t, srv, "bob", "pw", id); msg.Type() != message.TypeExit {
+		t.Fatalf("bob reconnected to the session of alice")
+	}
+	for _, user := range []string{"alice", "carol"} {
+		if msg := openSession(t, srv, user, "pw", id); msg.Type() != message.TypeConnect || msg.Connect().SessionID != id {
+			t.Fatalf("%s: reconnect refused", user)
+		}
+	}
+}
+
+func TestIdentity_Admin(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		id   *Identity
+		want bool
+	}{
+		{&Identity{Subject: "alice", Method: "basic"}, false},
+		{&Identity{Defaults: &SessionDefaults{Admin: true}}, true},
+		{&Identity{Claims: map[string]interface{}{"admin": true}}, true},
+		{&Identity{Claims: map[string]interface{}{"admin": "true"}}, false},
+	} {
+		if got := tc.id.Admin(); got != tc.want {
+			t.Errorf("%+v: Admin() = %v, want %v", tc.id, got, tc.want)
+		}
+	}
+}
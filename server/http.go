@@ -2,8 +2,10 @@ package server
 
 import (
 	"fmt"
+	"net/http"
 	"time"
 
+	"github.com/go-zoox/logger"
 	"github.com/go-zoox/zoox"
 	"github.com/go-zoox/zoox/defaults"
 )
@@ -20,12 +22,52 @@ type HTTPServerConfig struct {
 	//
 	Username string
 	Password string
+	// HTPasswdFile enables multi-user Basic Auth, see MiddlewareOptions.HTPasswdFile.
+	HTPasswdFile string
+	// JWTSecret (HS256) and/or JWTJWKSFile (RS256/ES256) enable bearer token auth,
+	// see TokenAuthConfig; JWTIssuer and JWTAudience are checked when set.
+	JWTSecret   string
+	JWTJWKSFile string
+	JWTIssuer   string
+	JWTAudience string
+	// Lockout configures the brute-force protection of Basic Auth and token
+	// auth, see LockoutConfig.
+	Lockout *LockoutConfig
+	// AllowedOrigins lists the origins browsers may open the WebSocket from,
+	// default: same-origin only
+	AllowedOrigins []string
 	// Driver is the Driver runtime, options: host, docker, kubernetes, ssh, default: host
 	Driver      string
 	DriverImage string
+	// DriverPluginDirs are searched for driver plugin executables, default:
+	// PATH for Driver only
+	DriverPluginDirs []string
+	// Sandbox runs host sessions in a sandbox, see SandboxConfig
+	Sandbox *SandboxConfig
+	// EphemeralHome gives each host session its own temporary home, see
+	// Config.EphemeralHome
+	EphemeralHome              bool
+	EphemeralHomeDir           string
+	EphemeralHomeTemplate      string
+	EphemeralHomeKeepOnFailure bool
+	// SessionEnvPrefix and SessionEnv describe the session to its shell, see
+	// Config.SessionEnvPrefix
+	SessionEnvPrefix string
+	SessionEnv       map[string]string
+	// ShellIntegration tracks the commands of bash sessions, see
+	// Config.ShellIntegration
+	ShellIntegration bool
 	//
 	Path string
 	//
+	// TLSCertFile and TLSKeyFile serve HTTPS (and wss://) on Port, reloading the
+	// files when they change. TLSClientCAFile additionally requires client
+	// certificates signed by these CAs (mutual TLS); the certificate subject becomes
+	// the session identity.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	//
 	InitCommand string
 	WorkDir     string
 	//
@@ -36,6 +78,36 @@ type HTTPServerConfig struct {
 	// SessionIdleRetention is how long a PTY session remains after the WebSocket
 	// disconnects before idle eviction. Zero selects the default in Serve (60 seconds).
 	SessionIdleRetention time.Duration
+	//
+	// AuditLogFile, AuditLogMaxSize and AuditLogMaxBackups configure the audit
+	// log, see Config.AuditLogFile.
+	AuditLogFile       string
+	AuditLogMaxSize    int64
+	AuditLogMaxBackups int
+	//
+	// CommandLog, CommandDenyPatterns and CommandAllowPatterns configure
+	// command logging and rules, see Config.CommandLog.
+	CommandLog           bool
+	CommandDenyPatterns  []string
+	CommandAllowPatterns []string
+	//
+	// RedactSecrets, RedactPatterns and RedactClientOutput configure output
+	// redaction, see Config.RedactSecrets.
+	RedactSecrets      bool
+	RedactPatterns     []string
+	RedactClientOutput bool
+	//
+	// SSH* configure the target of the ssh driver, see Config.SSHHost.
+	SSHHost                  string
+	SSHPort                  int
+	SSHUser                  string
+	SSHPassword              string
+	SSHPrivateKeyFile        string
+	SSHPrivateKeyPassphrase  string
+	SSHKnownHostsFile        string
+	SSHInsecureIgnoreHostKey bool
+	SSHJumpHost              string
+	SSHAllowedHosts          []string
 }
 
 type httpServer struct {
@@ -61,12 +133,24 @@ func (s *httpServer) Run() error {
 	addr := fmt.Sprintf(":%d", cfg.Port)
 	app := defaults.Application()
 
+	var token *TokenAuthConfig
+	if cfg.JWTSecret != "" || cfg.JWTJWKSFile != "" {
+		token = &TokenAuthConfig{
+			Secret:   cfg.JWTSecret,
+			JWKSFile: cfg.JWTJWKSFile,
+			Issuer:   cfg.JWTIssuer,
+			Audience: cfg.JWTAudience,
+		}
+	}
+
 	app.Use(Middleware(MiddlewareOptions{
 		Config: &Config{
 			Shell:                cfg.Shell,
 			User:                 cfg.User,
 			Driver:               cfg.Driver,
 			DriverImage:          cfg.DriverImage,
+			DriverPluginDirs:     cfg.DriverPluginDirs,
+			Sandbox:              cfg.Sandbox,
 			InitCommand:          cfg.InitCommand,
 			WorkDir:              cfg.WorkDir,
 			Username:             cfg.Username,
@@ -74,16 +158,67 @@ func (s *httpServer) Run() error {
 			IsHistoryDisabled:    cfg.IsHistoryDisabled,
 			ReadOnly:             cfg.ReadOnly,
 			SessionIdleRetention: cfg.SessionIdleRetention,
+			AuditLogFile:         cfg.AuditLogFile,
+			AuditLogMaxSize:      cfg.AuditLogMaxSize,
+			AuditLogMaxBackups:   cfg.AuditLogMaxBackups,
+			CommandLog:           cfg.CommandLog,
+			CommandDenyPatterns:  cfg.CommandDenyPatterns,
+			CommandAllowPatterns: cfg.CommandAllowPatterns,
+			RedactSecrets:        cfg.RedactSecrets,
+			RedactPatterns:       cfg.RedactPatterns,
+			RedactClientOutput:   cfg.RedactClientOutput,
+			//
+			SSHHost:                  cfg.SSHHost,
+			SSHPort:                  cfg.SSHPort,
+			SSHUser:                  cfg.SSHUser,
+			SSHPassword:              cfg.SSHPassword,
+			SSHPrivateKeyFile:        cfg.SSHPrivateKeyFile,
+			SSHPrivateKeyPassphrase:  cfg.SSHPrivateKeyPassphrase,
+			SSHKnownHostsFile:        cfg.SSHKnownHostsFile,
+			SSHInsecureIgnoreHostKey: cfg.SSHInsecureIgnoreHostKey,
+			SSHJumpHost:              cfg.SSHJumpHost,
+			SSHAllowedHosts:          cfg.SSHAllowedHosts,
+			//
+			EphemeralHome:              cfg.EphemeralHome,
+			EphemeralHomeDir:           cfg.EphemeralHomeDir,
+			EphemeralHomeTemplate:      cfg.EphemeralHomeTemplate,
+			EphemeralHomeKeepOnFailure: cfg.EphemeralHomeKeepOnFailure,
+			//
+			SessionEnvPrefix: cfg.SessionEnvPrefix,
+			SessionEnv:       cfg.SessionEnv,
+			ShellIntegration: cfg.ShellIntegration,
 		},
 		PagePath: "/",
 		WSPath:   cfg.Path,
 		Username: cfg.Username,
 		Password: cfg.Password,
+		//
+		HTPasswdFile: cfg.HTPasswdFile,
+		Token:        token,
+		Lockout:      cfg.Lockout,
+		//
+		AllowedOrigins: cfg.AllowedOrigins,
 	}))
 
 	app.Get("/hi", func(ctx *zoox.Context) {
 		ctx.String(200, "hi")
 	})
 
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" || cfg.TLSClientCAFile != "" {
+		tlsConfig, err := newTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSClientCAFile)
+		if err != nil {
+			return err
+		}
+
+		srv := &http.Server{
+			Addr:              addr,
+			Handler:           app,
+			TLSConfig:         tlsConfig,
+			ReadHeaderTimeout: 30 * time.Second,
+		}
+		logger.Infof("Server started at https://%s", addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+
 	return app.Run(addr)
 }
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+)
+
+// Identity is the authenticated principal behind a request, set by the auth
+// middlewares and carried to the WebSocket session through the request context.
+type Identity struct {
+	// Subject identifies the user: the Basic Auth username, the JWT sub claim or
+	// the client certificate common name; the session id for share links.
+	Subject string
+	// Method is how the request was authenticated: basic, jwt, mtls or share.
+	Method string
+	// Claims holds the verified JWT claims (nil for Basic Auth).
+	Claims map[string]interface{}
+	// Defaults are the user's session settings (htpasswd entries), nil when none.
+	Defaults *SessionDefaults
+	// Certificate is the verified client certificate (mtls).
+	Certificate *x509.Certificate
+	// Share is the verified share link (share).
+	Share *Share
+}
+
+// ReadOnly reports whether the identity may only watch its sessions: a
+// read-only share link, a read_only htpasswd entry or a true read_only JWT claim.
+func (id *Identity) ReadOnly() bool {
+	if id.Share != nil && id.Share.ReadOnly() {
+		return true
+	}
+	if id.Defaults != nil && id.Defaults.ReadOnly {
+		return true
+	}
+	readOnly, _ := id.Claims["read_only"].(bool)
+	return readOnly
+}
+
+// Admin reports whether the identity may use every session, not only the ones it
+// started: an admin htpasswd entry or a true admin JWT claim.
+func (id *Identity) Admin() bool {
+	if id.Defaults != nil && id.Defaults.Admin {
+		return true
+	}
+	admin, _ := id.Claims["admin"].(bool)
+	return admin
+}
+
+type identityContextKey struct{}
+
+// WithIdentity returns a copy of ctx carrying id.
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+// IdentityFromContext returns the identity stored by WithIdentity.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return id, ok && id != nil
+}
+
+// IdentityFromRequest returns the identity the auth middlewares attached to r,
+// falling back to the verified TLS client certificate.
+func IdentityFromRequest(r *http.Request) (*Identity, bool) {
+	if r == nil {
+		return nil, false
+	}
+	if id, ok := IdentityFromContext(r.Context()); ok {
+		return id, true
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 && len(r.TLS.VerifiedChains[0]) != 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		subject := cert.Subject.CommonName
+		if subject == "" {
+			subject = cert.Subject.String()
+		}
+		return &Identity{
+			Subject:     subject,
+			Method:      "mtls",
+			Certificate: cert,
+		}, true
+	}
+
+	return nil, false
+}
+
+func requestWithIdentity(r *http.Request, id *Identity) *http.Request {
+	return r.WithContext(WithIdentity(r.Context(), id))
+}
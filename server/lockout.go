@@ -0,0 +1,280 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/logger"
+)
+
+// Auth event types reported to LockoutConfig.OnEvent.
+const (
+	// AuthEventFailure is a failed authentication attempt.
+	AuthEventFailure = "failure"
+	// AuthEventLockout is a client IP, a username from a client IP, or a
+	// username from all IPs being locked out.
+	AuthEventLockout = "lockout"
+	// AuthEventRejected is an attempt refused because of an active lockout.
+	AuthEventRejected = "rejected"
+)
+
+// AuthEvent describes a failed or refused authentication attempt.
+type AuthEvent struct {
+	Type string
+	// IP is the client IP of the request (its RemoteAddr), empty for lockouts
+	// of a username from all IPs.
+	IP string
+	// Username is the attempted Basic Auth username, empty for tokens and for
+	// lockouts of the whole IP.
+	Username string
+	// Failures is the number of consecutive failures of the IP or username.
+	Failures int
+	// RetryAfter is the remaining lockout, for AuthEventLockout and
+	// AuthEventRejected.
+	RetryAfter time.Duration
+}
+
+// LockoutConfig configures the brute-force protection of BasicAuth and
+// Middleware. Failed attempts are counted per username from a client IP, per
+// client IP, and per username over all IPs; after MaxFailures (MaxIPFailures
+// for the IP, MaxUserFailures for the username) consecutive failures further
+// attempts are refused with 429 for Lockout, doubled with every further failure
+// up to MaxLockout. The higher MaxUserFailures throttles guessing one account
+// from many IPs while a few failures elsewhere cannot lock its user out. A
+// successful login clears the counters, failures older than MaxLockout are
+// forgotten.
+//
+// The client IP is the request's RemoteAddr: behind a reverse proxy all clients
+// share the proxy's address.
+type LockoutConfig struct {
+	// Disabled turns the protection off.
+	Disabled bool
+
+	// MaxFailures is the number of failures of a username from an IP allowed
+	// before a lockout (default 5).
+	MaxFailures int
+	// MaxIPFailures is the number of failures from an IP over all usernames and
+	// tokens allowed before a lockout of the IP (default 4 × MaxFailures).
+	MaxIPFailures int
+	// MaxUserFailures is the number of failures of a username over all IPs
+	// allowed before a lockout of the username (default 4 × MaxFailures).
+	MaxUserFailures int
+	// Lockout is the first lockout duration (default 30s).
+	Lockout time.Duration
+	// MaxLockout caps the lockout duration (default 15m).
+	MaxLockout time.Duration
+
+	// OnEvent is called for every failure, lockout and refused attempt, e.g. to
+	// export metrics. Events are logged either way.
+	OnEvent func(event AuthEvent)
+}
+
+// lockout tracks failed authentication attempts. A nil *lockout never locks.
+type lockout struct {
+	cfg LockoutConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*lockoutEntry
+	lastSweep time.Time
+}
+
+type lockoutEntry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func newLockout(cfg *LockoutConfig) *lockout {
+	c := LockoutConfig{}
+	if cfg != nil {
+		c = *cfg
+	}
+	if c.Disabled {
+		return nil
+	}
+
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = 5
+	}
+	if c.MaxIPFailures <= 0 {
+		c.MaxIPFailures = 4 * c.MaxFailures
+	}
+	if c.MaxUserFailures <= 0 {
+		c.MaxUserFailures = 4 * c.MaxFailures
+	}
+	if c.Lockout <= 0 {
+		c.Lockout = 30 * time.Second
+	}
+	if c.MaxLockout <= 0 {
+		c.MaxLockout = 15 * time.Minute
+	}
+	if c.MaxLockout < c.Lockout {
+		c.MaxLockout = c.Lockout
+	}
+
+	return &lockout{
+		cfg:     c,
+		now:     time.Now,
+		entries: map[string]*lockoutEntry{},
+	}
+}
+
+// RetryAfter returns how long the IP, the username from the IP or the username
+// is still locked out, 0 when none is. A refused attempt does not extend the lockout.
+func (l *lockout) RetryAfter(ip, username string) time.Duration {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	var retryAfter time.Duration
+	var failures int
+	for _, k := range lockoutKeys(ip, username) {
+		if e, ok := l.entries[k.key]; ok && e.lockedUntil.After(now) {
+			if d := e.lockedUntil.Sub(now); d > retryAfter {
+				retryAfter = d
+				failures = e.failures
+			}
+		}
+	}
+	l.mu.Unlock()
+
+	if retryAfter > 0 {
+		l.emit(AuthEvent{Type: AuthEventRejected, IP: ip, Username: username, Failures: failures, RetryAfter: retryAfter})
+	}
+	return retryAfter
+}
+
+// Fail records a failed attempt.
+func (l *lockout) Fail(ip, username string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	now := l.now()
+	l.sweep(now)
+
+	var events []AuthEvent
+	maxFailures := 0
+	for _, k := range lockoutKeys(ip, username) {
+		e, ok := l.entries[k.key]
+		if !ok || now.Sub(e.lastFailure) > l.cfg.MaxLockout {
+			e = &lockoutEntry{}
+			l.entries[k.key] = e
+		}
+		e.failures++
+		e.lastFailure = now
+		if e.failures > maxFailures {
+			maxFailures = e.failures
+		}
+
+		maxKeyFailures := l.cfg.MaxFailures
+		switch {
+		case k.username == "":
+			maxKeyFailures = l.cfg.MaxIPFailures
+		case k.ip == "":
+			maxKeyFailures = l.cfg.MaxUserFailures
+		}
+		if e.failures >= maxKeyFailures {
+			d := l.cfg.Lockout
+			for i := maxKeyFailures; i < e.failures && d < l.cfg.MaxLockout; i++ {
+				d *= 2
+			}
+			if d > l.cfg.MaxLockout {
+				d = l.cfg.MaxLockout
+			}
+			e.lockedUntil = now.Add(d)
+
+			events = append(events, AuthEvent{Type: AuthEventLockout, IP: k.ip, Username: k.username, Failures: e.failures, RetryAfter: d})
+		}
+	}
+	l.mu.Unlock()
+
+	l.emit(AuthEvent{Type: AuthEventFailure, IP: ip, Username: username, Failures: maxFailures})
+	for _, event := range events {
+		l.emit(event)
+	}
+}
+
+// Succeed clears the failures of the IP, the username from the IP and the
+// username.
+func (l *lockout) Succeed(ip, username string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, k := range lockoutKeys(ip, username) {
+		delete(l.entries, k.key)
+	}
+}
+
+// sweep forgets stale entries at most once a minute.
+func (l *lockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for key, e := range l.entries {
+		if now.After(e.lockedUntil) && now.Sub(e.lastFailure) > l.cfg.MaxLockout {
+			delete(l.entries, key)
+		}
+	}
+}
+
+func (l *lockout) emit(event AuthEvent) {
+	switch event.Type {
+	case AuthEventFailure:
+		logger.Warnf("[auth] failed login (user: %q) from %s, %d consecutive failures", event.Username, event.IP, event.Failures)
+	case AuthEventLockout:
+		if event.IP == "" {
+			logger.Warnf("[auth] user %q locked out for %s after %d failures from all IPs", event.Username, event.RetryAfter, event.Failures)
+		} else if event.Username != "" {
+			logger.Warnf("[auth] user %q from %s locked out for %s after %d failures", event.Username, event.IP, event.RetryAfter, event.Failures)
+		} else {
+			logger.Warnf("[auth] %s locked out for %s after %d failures", event.IP, event.RetryAfter, event.Failures)
+		}
+	case AuthEventRejected:
+		logger.Debugf("[auth] refused login (user: %q) from %s, locked out for %s", event.Username, event.IP, event.RetryAfter)
+	}
+
+	if l.cfg.OnEvent != nil {
+		l.cfg.OnEvent(event)
+	}
+}
+
+// lockoutKey is a tracked client IP, username from a client IP, or username
+// from all IPs (empty ip).
+type lockoutKey struct {
+	key      string
+	ip       string
+	username string
+}
+
+func lockoutKeys(ip, username string) []lockoutKey {
+	keys := []lockoutKey{{key: "ip:" + ip, ip: ip}}
+	if username != "" {
+		keys = append(keys,
+			lockoutKey{key: "user:" + ip + "\x00" + username, ip: ip, username: username},
+			lockoutKey{key: "account:" + username, username: username},
+		)
+	}
+	return keys
+}
+
+// clientIP returns the IP of RemoteAddr.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/zoox"
+)
+
+func TestLockout_exponential(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	l := newLockout(&LockoutConfig{MaxFailures: 3, Lockout: time.Second, MaxLockout: 5 * time.Second})
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		l.Fail("10.0.0.1", "alice")
+	}
+	if d := l.RetryAfter("10.0.0.1", "alice"); d != 0 {
+		t.Fatalf("locked after 2 failures: %s", d)
+	}
+
+	l.Fail("10.0.0.1", "alice")
+	if d := l.RetryAfter("10.0.0.1", "alice"); d != time.Second {
+		t.Fatalf("user lockout = %s, want 1s", d)
+	}
+	// others cannot lock alice out, and other users of the IP may still log in
+	if d := l.RetryAfter("10.0.0.2", "alice"); d != 0 {
+		t.Fatalf("user locked from another IP: %s", d)
+	}
+	if d := l.RetryAfter("10.0.0.1", "bob"); d != 0 {
+		t.Fatalf("other user of the IP locked: %s", d)
+	}
+
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		// the next attempt after the lockout ended fails again
+		now = now.Add(l.RetryAfter("10.0.0.1", "alice"))
+		l.Fail("10.0.0.1", "alice")
+		if d := l.RetryAfter("10.0.0.1", "alice"); d != want {
+			t.Fatalf("lockout = %s, want %s", d, want)
+		}
+	}
+
+	l.Succeed("10.0.0.1", "alice")
+	if d := l.RetryAfter("10.0.0.1", "alice"); d != 0 {
+		t.Fatalf("locked after success: %s", d)
+	}
+}
+
+func TestLockout_ip(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	l := newLockout(&LockoutConfig{MaxFailures: 3, MaxIPFailures: 5, Lockout: time.Second})
+	l.now = func() time.Time { return now }
+	for _, user := range []string{"a", "b", "c", "d"} {
+		l.Fail("10.0.0.1", user)
+	}
+	if d := l.RetryAfter("10.0.0.1", "e"); d != 0 {
+		t.Fatalf("ip locked after 4 failures: %s", d)
+	}
+	l.Fail("10.0.0.1", "")
+	if d := l.RetryAfter("10.0.0.1", "e"); d != time.Second {
+		t.Fatalf("ip lockout = %s, want 1s", d)
+	}
+	if d := l.RetryAfter("10.0.0.2", "a"); d != 0 {
+		t.Fatalf("other IP locked: %s", d)
+	}
+}
+
+func TestLockout_user(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	l := newLockout(&LockoutConfig{MaxFailures: 3, MaxUserFailures: 5, Lockout: time.Second})
+	l.now = func() time.Time { return now }
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"} {
+		l.Fail(ip, "alice")
+	}
+	if d := l.RetryAfter("10.0.0.9", "alice"); d != 0 {
+		t.Fatalf("user locked after 4 failures: %s", d)
+	}
+	l.Fail("10.0.0.5", "alice")
+	if d := l.RetryAfter("10.0.0.9", "alice"); d != time.Second {
+		t.Fatalf("user lockout = %s, want 1s", d)
+	}
+	if d := l.RetryAfter("10.0.0.1", "bob"); d != 0 {
+		t.Fatalf("other user locked: %s", d)
+	}
+
+	l.Succeed("10.0.0.9", "alice")
+	if d := l.RetryAfter("10.0.0.9", "alice"); d != 0 {
+		t.Fatalf("locked after success: %s", d)
+	}
+}
+
+func TestLockout_disabled(t *testing.T) {
+	t.Parallel()
+
+	l := newLockout(&LockoutConfig{Disabled: true})
+	for i := 0; i < 10; i++ {
+		l.Fail("10.0.0.1", "alice")
+	}
+	if d := l.RetryAfter("10.0.0.1", "alice"); d != 0 {
+		t.Fatalf("disabled lockout locked: %s", d)
+	}
+}
+
+func TestBasicAuth_lockout(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	events := map[string]int{}
+
+	app := zoox.New()
+	app.Use(BasicAuth(BasicAuthConfig{
+		Username: "admin",
+		Password: "admin",
+		Lockout: &LockoutConfig{
+			MaxFailures: 2,
+			Lockout:     time.Minute,
+			OnEvent: func(event AuthEvent) {
+				mu.Lock()
+				events[event.Type]++
+				mu.Unlock()
+			},
+		},
+	}))
+	app.Get("/", func(ctx *zoox.Context) {
+		ctx.String(200, "ok")
+	})
+
+	do := func(user, pass string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// challenges without credentials are not failures
+	for i := 0; i < 3; i++ {
+		if rec := do("", ""); rec.Code != 401 {
+			t.Fatalf("anonymous: %d", rec.Code)
+		}
+	}
+	if rec := do("admin", "admin"); rec.Code != 200 {
+		t.Fatalf("valid login: %d", rec.Code)
+	}
+
+	do("admin", "guess1")
+	do("admin", "guess2")
+	rec := do("admin", "admin")
+	if rec.Code != 429 || rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("during lockout: %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if events[AuthEventFailure] != 2 || events[AuthEventLockout] != 1 || events[AuthEventRejected] != 1 {
+		t.Fatalf("events = %v", events)
+	}
+}
@@ -1,11 +1,17 @@
 package server
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-zoox/headers"
+	"github.com/go-zoox/logger"
 	"github.com/go-zoox/zoox"
 )
 
@@ -15,7 +21,7 @@ const (
 )
 
 // MiddlewareOptions configures Middleware: full terminal mounting (PTY WebSocket +
-// HTML shell), with optional Basic Auth ahead of those routes.
+// HTML shell), with optional Basic Auth and/or token auth ahead of those routes.
 type MiddlewareOptions struct {
 	Config *Config
 
@@ -33,18 +39,46 @@ type MiddlewareOptions struct {
 	// DisablePage, if true, only handles WebSocket upgrades on WSPath (no HTML route).
 	DisablePage bool
 
+	// AllowedOrigins and CSRFSecret protect the WebSocket upgrade against
+	// cross-site use, see RegisterOptions.AllowedOrigins.
+	AllowedOrigins []string
+	CSRFSecret     string
+
+	// SharePath is the POST route minting share links for the caller's own
+	// sessions (default "/share"). Requests to PagePath and WSPath with a valid
+	// share link skip Basic Auth and token auth; an invalid or expired link gets
+	// 403.
+	SharePath string
+	// CommandsPath is the GET route listing the commands of a session tracked by
+	// shell integration (default "/commands", ?session_id=...). A share link
+	// opens it for its own session.
+	CommandsPath string
+
 	// Username and Password enable Basic Auth for requests that reach this middleware.
 	// When both are set, credentials are checked before terminal handling; failed
 	// auth returns 401. Skip can bypass the check for selected requests.
 	Username string
 	Password string
-	Skip     func(ctx *zoox.Context) bool
+	// HTPasswdFile enables Basic Auth against an htpasswd file of bcrypt hashes,
+	// reloaded on change, whose entries may carry per-user session defaults (see
+	// SessionDefaults). It can be combined with Username and Password.
+	HTPasswdFile string
+	// Token enables JWT auth (see TokenAuth). When Basic Auth is enabled as well,
+	// requests carrying a token are checked against it and all others fall back
+	// to Basic Auth. Skip applies to both.
+	Token *TokenAuthConfig
+	// Lockout configures the brute-force protection of Basic Auth and token auth,
+	// enabled with its defaults when nil (see LockoutConfig).
+	Lockout *LockoutConfig
+	Skip    func(ctx *zoox.Context) bool
 }
 
 // Middleware returns zoox middleware that serves the terminal: WebSocket upgrades
 // on WSPath are handled by the PTY server; GET PagePath serves the embedded page.
 // Other requests call Next(). Optional Basic Auth runs first when Username and
-// Password are both non-empty.
+// Password are both non-empty, token auth when Token is set; the authenticated
+// Identity is attached to the request context. Repeated failures lock the client
+// IP or username out (see LockoutConfig).
 //
 // Do not Register the same routes elsewhere; this middleware already owns PagePath
 // and WSPath.
@@ -66,33 +100,53 @@ func Middleware(opts MiddlewareOptions) zoox.HandlerFunc {
 		wsPath = "/ws"
 	}
 
+	sharePath := opts.SharePath
+	if sharePath == "" {
+		sharePath = "/share"
+	}
+
+	commandsPath := opts.CommandsPath
+	if commandsPath == "" {
+		commandsPath = "/commands"
+	}
+
 	publicWS := effectivePublicWSPath(opts.BasePath, wsPath)
 
+	auth := newAuthenticator(opts.Username, opts.Password, opts.HTPasswdFile, opts.Token)
+	auth.lockout = newLockout(opts.Lockout)
+	auth.audit = cfg.audit
+
+	var csrf *csrfTokens
 	var pageFn zoox.HandlerFunc
 	if !opts.DisablePage {
+		csrf = newCSRFTokens(opts.CSRFSecret)
 		pageFn = PageHandler(PageConfig{
 			WSPath:         publicWS,
 			WelcomeMessage: opts.WelcomeMessage,
+			csrf:           csrf,
 		})
 	}
 
+	guard := newUpgradeGuard(opts.AllowedOrigins, csrf)
+	shareFn := shareHandler(cfg.sessions, cfg.ShareSecret, effectivePublicWSPath(opts.BasePath, pagePath))
+	commandsFn := commandsHandler(cfg.sessions)
+
 	return func(ctx *zoox.Context) {
-		if opts.Username != "" && opts.Password != "" {
-			if opts.Skip == nil || !opts.Skip(ctx) {
-				user, pass, ok := ctx.Request.BasicAuth()
-				if !ok {
-					ctx.Set("WWW-Authenticate", `Basic realm="go-zoox"`)
-					ctx.Status(401)
-					return
-				}
-				if user != opts.Username || pass != opts.Password {
-					ctx.Status(401)
-					return
-				}
+		if hasShareToken(ctx.Request) && ctx.Method == http.MethodGet && (ctx.Path == wsPath || ctx.Path == pagePath || ctx.Path == commandsPath) {
+			if !withShareIdentity(cfg.ShareSecret, ctx) {
+				return
+			}
+		} else if auth.Enabled() && (opts.Skip == nil || !opts.Skip(ctx)) {
+			if !auth.handle(ctx) {
+				return
 			}
 		}
 
 		if ctx.Method == http.MethodGet && ctx.Path == wsPath && isWebSocketUpgrade(ctx) {
+			if !guard.allow(ctx) {
+				return
+			}
+
 			wsSrv.ServeHTTP(ctx.Writer, ctx.Request)
 			return
 		}
@@ -102,6 +156,16 @@ func Middleware(opts MiddlewareOptions) zoox.HandlerFunc {
 			return
 		}
 
+		if ctx.Method == http.MethodPost && ctx.Path == sharePath {
+			shareFn(ctx)
+			return
+		}
+
+		if ctx.Method == http.MethodGet && ctx.Path == commandsPath {
+			commandsFn(ctx)
+			return
+		}
+
 		ctx.Next()
 	}
 }
@@ -131,15 +195,24 @@ func isWebSocketUpgrade(ctx *zoox.Context) bool {
 type BasicAuthConfig struct {
 	Username string
 	Password string
+	// HTPasswdFile checks credentials against an htpasswd file, see
+	// MiddlewareOptions.HTPasswdFile.
+	HTPasswdFile string
+	// Lockout configures the brute-force protection, enabled with its defaults
+	// when nil (see LockoutConfig).
+	Lockout *LockoutConfig
 
 	Skip func(ctx *zoox.Context) bool
 }
 
 // BasicAuth returns middleware that only enforces Basic Auth when both Username
-// and Password are set; otherwise it is a no-op and calls Next().
+// and Password or HTPasswdFile are set; otherwise it is a no-op and calls Next().
 func BasicAuth(cfg BasicAuthConfig) zoox.HandlerFunc {
+	auth := newAuthenticator(cfg.Username, cfg.Password, cfg.HTPasswdFile, nil)
+	auth.lockout = newLockout(cfg.Lockout)
+
 	return func(ctx *zoox.Context) {
-		if cfg.Username == "" || cfg.Password == "" {
+		if !auth.Enabled() {
 			ctx.Next()
 			return
 		}
@@ -148,18 +221,138 @@ func BasicAuth(cfg BasicAuthConfig) zoox.HandlerFunc {
 			return
 		}
 
-		user, pass, ok := ctx.Request.BasicAuth()
-		if !ok {
-			ctx.Set("WWW-Authenticate", `Basic realm="go-zoox"`)
-			ctx.Status(401)
-			return
+		if auth.handle(ctx) {
+			ctx.Next()
 		}
+	}
+}
 
-		if user != cfg.Username || pass != cfg.Password {
-			ctx.Status(401)
-			return
+// authenticator checks requests against the configured methods: a static Basic
+// Auth account, an htpasswd file and JWTs.
+type authenticator struct {
+	username string
+	password string
+	htpasswd *htpasswdFile
+	token    *tokenVerifier
+	lockout  *lockout
+	audit    *auditLog
+}
+
+func newAuthenticator(username, password, htpasswdPath string, token *TokenAuthConfig) *authenticator {
+	a := &authenticator{}
+	if username != "" && password != "" {
+		a.username = username
+		a.password = password
+	}
+	if htpasswdPath != "" {
+		a.htpasswd = newHTPasswdFile(htpasswdPath)
+	}
+	if token != nil {
+		a.token = newTokenVerifier(token)
+	}
+	return a
+}
+
+func (a *authenticator) Enabled() bool {
+	return a.basicEnabled() || a.token != nil
+}
+
+func (a *authenticator) basicEnabled() bool {
+	return a.username != "" || a.htpasswd != nil
+}
+
+// handle authenticates the request of ctx and attaches the Identity. Otherwise it
+// responds 401, or 429 while the client IP or username is locked out, and
+// returns false.
+func (a *authenticator) handle(ctx *zoox.Context) bool {
+	ip := clientIP(ctx.Request)
+	username, _, hasBasic := ctx.Request.BasicAuth()
+
+	if retryAfter := a.lockout.RetryAfter(ip, username); retryAfter > 0 {
+		a.audit.Log(&AuditEvent{
+			Type:       AuditEventAuthFailure,
+			Username:   username,
+			RemoteAddr: ctx.Request.RemoteAddr,
+			Reason:     fmt.Sprintf("locked out for %s", retryAfter.Round(time.Second)),
+		})
+		ctx.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		ctx.Status(429)
+		return false
+	}
+
+	id, challenge := a.Authenticate(ctx.Request)
+	if id == nil {
+		// an anonymous request only gets the challenge, it is not a failed attempt
+		if hasBasic || (a.token != nil && tokenFromRequest(ctx.Request, a.token.cfg.CookieName) != "") {
+			a.lockout.Fail(ip, username)
+			a.audit.Log(&AuditEvent{
+				Type:       AuditEventAuthFailure,
+				Username:   username,
+				RemoteAddr: ctx.Request.RemoteAddr,
+				Reason:     "invalid credentials",
+			})
 		}
 
-		ctx.Next()
+		if challenge != "" {
+			ctx.Set("WWW-Authenticate", challenge)
+		}
+		ctx.Status(401)
+		return false
+	}
+
+	a.lockout.Succeed(ip, username)
+	// only sessions are audited, not every page and asset request
+	if isWebSocketUpgrade(ctx) {
+		a.audit.Log((&AuditEvent{
+			Type:       AuditEventAuthSuccess,
+			RemoteAddr: ctx.Request.RemoteAddr,
+		}).withIdentity(id, true))
+	}
+	ctx.Request = requestWithIdentity(ctx.Request, id)
+	return true
+}
+
+// Authenticate checks r with token auth (when enabled and r carries a token) or
+// Basic Auth. On failure it returns a nil identity and the WWW-Authenticate
+// challenge to send, if any.
+func (a *authenticator) Authenticate(r *http.Request) (id *Identity, challenge string) {
+	if a.token != nil {
+		id, err := a.token.Authenticate(r)
+		if err == nil {
+			return id, ""
+		}
+		if err != errNoToken {
+			logger.Debugf("[token auth] %s", err)
+			return nil, `Bearer realm="go-zoox", error="invalid_token"`
+		}
+		if !a.basicEnabled() {
+			return nil, `Bearer realm="go-zoox"`
+		}
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, `Basic realm="go-zoox"`
 	}
+
+	// compare both, so a wrong username costs the same as a wrong password
+	userOK := secureCompare(user, a.username)
+	passOK := secureCompare(pass, a.password)
+	if a.username != "" && userOK && passOK {
+		return &Identity{Subject: user, Method: "basic"}, ""
+	}
+	if a.htpasswd != nil {
+		if id, ok := a.htpasswd.Authenticate(user, pass); ok {
+			return id, ""
+		}
+	}
+
+	return nil, ""
+}
+
+// secureCompare compares a and b in constant time, also hiding their lengths.
+func secureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
 }
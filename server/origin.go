@@ -0,0 +1,193 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-zoox/logger"
+	"github.com/go-zoox/zoox"
+)
+
+// csrfTokenTTL bounds how long an open page may keep (re)connecting.
+const csrfTokenTTL = 24 * time.Hour
+
+// csrfQueryParam carries the page's CSRF token on the WebSocket URL.
+const csrfQueryParam = "csrf_token"
+
+// upgradeGuard protects the WebSocket upgrade against cross-site use: browsers
+// send an Origin header on every WebSocket handshake, which must be allowed.
+// Upgrades from the server's own origin come from the embedded page and must
+// carry the CSRF token minted for it; allow-listed foreign origins run their own
+// frontend and are trusted by the allow-list. Requests without Origin (CLI,
+// scripts) are not browser requests and pass.
+type upgradeGuard struct {
+	allowedOrigins []string
+	csrf           *csrfTokens
+}
+
+func newUpgradeGuard(allowedOrigins []string, csrf *csrfTokens) *upgradeGuard {
+	return &upgradeGuard{
+		allowedOrigins: allowedOrigins,
+		csrf:           csrf,
+	}
+}
+
+// Check returns an error when the upgrade request r must be rejected.
+func (g *upgradeGuard) Check(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid origin %s", origin)
+	}
+
+	sameOrigin := isSameOrigin(r, u)
+	if !sameOrigin && !g.originAllowed(u) {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+
+	if sameOrigin && g.csrf != nil {
+		if err := g.csrf.Verify(r.URL.Query().Get(csrfQueryParam), identitySubject(r)); err != nil {
+			return fmt.Errorf("csrf: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// isSameOrigin reports whether the Origin host is the requested host.
+func isSameOrigin(r *http.Request, origin *url.URL) bool {
+	if strings.EqualFold(origin.Host, r.Host) {
+		return true
+	}
+
+	// behind a reverse proxy that rewrites Host
+	forwarded := r.Header.Get("X-Forwarded-Host")
+	return forwarded != "" && strings.EqualFold(origin.Host, strings.TrimSpace(strings.Split(forwarded, ",")[0]))
+}
+
+// originAllowed matches a foreign origin against the allowed list: "*", an
+// exact origin (https://app.example.com) or a subdomain wildcard
+// (https://*.example.com).
+func (g *upgradeGuard) originAllowed(u *url.URL) bool {
+	origin := u.Scheme + "://" + u.Host
+	for _, allowed := range g.allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+
+		a, err := url.Parse(allowed)
+		if err != nil || !strings.HasPrefix(a.Host, "*.") || !strings.EqualFold(a.Scheme, u.Scheme) {
+			continue
+		}
+		if suffix := a.Host[1:]; len(u.Host) > len(suffix) && strings.EqualFold(u.Host[len(u.Host)-len(suffix):], suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Middleware rejects disallowed upgrade requests with 403.
+func (g *upgradeGuard) Middleware(ctx *zoox.Context) {
+	if g.allow(ctx) {
+		ctx.Next()
+	}
+}
+
+// allow responds 403 and returns false when the upgrade request is rejected.
+func (g *upgradeGuard) allow(ctx *zoox.Context) bool {
+	if err := g.Check(ctx.Request); err != nil {
+		logger.Warnf("[websocket] rejected upgrade from %s: %s", ctx.Request.RemoteAddr, err)
+		ctx.Status(403)
+		return false
+	}
+	return true
+}
+
+// csrfTokens mints and verifies the per-page CSRF tokens: a timestamp and nonce
+// signed together with the identity the page was served to, so a token cannot be
+// replayed by another user or after csrfTokenTTL.
+type csrfTokens struct {
+	secret []byte
+}
+
+// newCSRFTokens uses secret when set (required when several replicas serve the
+// same page), otherwise a random per-process secret.
+func newCSRFTokens(secret string) *csrfTokens {
+	if secret != "" {
+		return &csrfTokens{secret: []byte(secret)}
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Errorf("terminal csrf: %w", err))
+	}
+	return &csrfTokens{secret: key}
+}
+
+// Mint returns a token for a page served to subject.
+func (c *csrfTokens) Mint(subject string) string {
+	payload := make([]byte, 8+16)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Unix()))
+	rand.Read(payload[8:])
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload, subject))
+}
+
+// Verify checks token was minted for subject and has not expired.
+func (c *csrfTokens) Verify(token, subject string) error {
+	if token == "" {
+		return fmt.Errorf("missing token")
+	}
+
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return fmt.Errorf("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != 8+16 {
+		return fmt.Errorf("malformed token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return fmt.Errorf("malformed token")
+	}
+
+	if subtle.ConstantTimeCompare(sig, c.sign(payload, subject)) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+
+	issued := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if time.Since(issued) > csrfTokenTTL {
+		return fmt.Errorf("token expired, reload the page")
+	}
+
+	return nil
+}
+
+func (c *csrfTokens) sign(payload []byte, subject string) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write(payload)
+	mac.Write([]byte(subject))
+	return mac.Sum(nil)
+}
+
+func identitySubject(r *http.Request) string {
+	if id, ok := IdentityFromRequest(r); ok {
+		return id.Method + ":" + id.Subject
+	}
+	return ""
+}
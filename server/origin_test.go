@@ -0,0 +1,156 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/zoox"
+)
+
+func TestUpgradeGuard_origins(t *testing.T) {
+	t.Parallel()
+
+	sameOrigin := newUpgradeGuard(nil, nil)
+	allowList := newUpgradeGuard([]string{"https://app.example.com", "https://*.corp.example.com"}, nil)
+
+	for _, tc := range []struct {
+		guard   *upgradeGuard
+		origin  string
+		headers map[string]string
+		allowed bool
+	}{
+		{sameOrigin, "", nil, true},
+		{sameOrigin, "http://terminal.local:8838", nil, true},
+		{sameOrigin, "https://evil.example", nil, false},
+		{sameOrigin, "null", nil, false},
+		{sameOrigin, "https://public.example.com", map[string]string{"X-Forwarded-Host": "public.example.com"}, true},
+		{allowList, "https://app.example.com", nil, true},
+		{allowList, "https://a.corp.example.com", nil, true},
+		{allowList, "https://corp.example.com", nil, false},
+		{allowList, "http://a.corp.example.com", nil, false},
+		{allowList, "https://evilcorp.example.com", nil, false},
+		{allowList, "http://terminal.local:8838", nil, true},
+		{newUpgradeGuard([]string{"*"}, nil), "https://anywhere.example", nil, true},
+	} {
+		req := httptest.NewRequest("GET", "http://terminal.local:8838/ws", nil)
+		if tc.origin != "" {
+			req.Header.Set("Origin", tc.origin)
+		}
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+
+		if err := tc.guard.Check(req); (err == nil) != tc.allowed {
+			t.Errorf("origin %q (%v): err = %v, want allowed %v", tc.origin, tc.guard.allowedOrigins, err, tc.allowed)
+		}
+	}
+}
+
+func TestCSRFTokens(t *testing.T) {
+	t.Parallel()
+
+	c := newCSRFTokens("")
+	token := c.Mint("basic:alice")
+
+	if err := c.Verify(token, "basic:alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Verify(token, "basic:bob"); err == nil {
+		t.Fatal("token accepted for another identity")
+	}
+	if err := newCSRFTokens("").Verify(token, "basic:alice"); err == nil {
+		t.Fatal("token accepted with another secret")
+	}
+	for _, bad := range []string{"", "x", "x.y", token + "x"} {
+		if err := c.Verify(bad, "basic:alice"); err == nil {
+			t.Errorf("Verify(%q): expected error", bad)
+		}
+	}
+
+	// a shared secret lets replicas verify each other's tokens
+	if err := newCSRFTokens("s3cret").Verify(newCSRFTokens("s3cret").Mint(""), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := make([]byte, 8+16)
+	binary.BigEndian.PutUint64(payload, uint64(time.Now().Add(-csrfTokenTTL-time.Minute).Unix()))
+	expired := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(c.sign(payload, ""))
+	if err := c.Verify(expired, ""); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("expired token: %v", err)
+	}
+}
+
+func TestMiddleware_upgradeRequiresPageToken(t *testing.T) {
+	t.Parallel()
+
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{
+		Username: "alice",
+		Password: "pw",
+	}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	do := func(path, origin string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.SetBasicAuth("alice", "pw")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	page := do("/", "")
+	body, _ := io.ReadAll(page.Body)
+	page.Body.Close()
+	m := regexp.MustCompile(`"csrfToken":"([^"]+)"`).FindStringSubmatch(string(body))
+	if m == nil {
+		t.Fatal("page does not embed a csrf token")
+	}
+	if cc := page.Header.Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("page Cache-Control = %q", cc)
+	}
+
+	if resp := do("/ws", "https://evil.example"); resp.StatusCode != 403 {
+		t.Errorf("cross-origin upgrade: status %d, want 403", resp.StatusCode)
+	}
+	if resp := do("/ws", srv.URL); resp.StatusCode != 403 {
+		t.Errorf("upgrade without token: status %d, want 403", resp.StatusCode)
+	}
+	if resp := do("/ws?csrf_token="+m[1], srv.URL); resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("upgrade with token: status %d, want 101", resp.StatusCode)
+	}
+}
+
+func TestUpgradeGuard_allowedOriginSkipsPageToken(t *testing.T) {
+	t.Parallel()
+
+	guard := newUpgradeGuard([]string{"https://app.example.com"}, newCSRFTokens(""))
+
+	req := httptest.NewRequest("GET", "http://terminal.local:8838/ws", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	if err := guard.Check(req); err != nil {
+		t.Fatalf("allow-listed origin: %v", err)
+	}
+
+	req.Header.Set("Origin", "http://terminal.local:8838")
+	if err := guard.Check(req); err == nil {
+		t.Fatal("same-origin upgrade without page token accepted")
+	}
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/command/errors"
+	"github.com/go-zoox/command/terminal"
+	"github.com/go-zoox/logger"
+	"github.com/go-zoox/terminal/message"
+	"github.com/go-zoox/terminal/plugin"
+)
+
+// Driver plugins get pluginConnectTimeout to accept the session and
+// pluginExitTimeout to exit once the session is closed before they are killed.
+const (
+	pluginConnectTimeout = 30 * time.Second
+	pluginExitTimeout    = 5 * time.Second
+)
+
+// pluginDriverName restricts plugin driver names to safe executable names.
+var pluginDriverName = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// findDriverPlugin returns the executable of the driver plugin name, searched in
+// Config.DriverPluginDirs or, when none are set, in PATH for the server's own
+// Driver only: clients do not get to run arbitrary executables from PATH.
+func (cfg *Config) findDriverPlugin(name string) (string, bool) {
+	if !pluginDriverName.MatchString(name) {
+		return "", false
+	}
+
+	file := plugin.ExecutablePrefix + name
+	if len(cfg.DriverPluginDirs) == 0 {
+		if name != cfg.Driver {
+			return "", false
+		}
+		path, err := exec.LookPath(file)
+		return path, err == nil
+	}
+	for _, dir := range cfg.DriverPluginDirs {
+		path := filepath.Join(dir, file)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode()&0111 != 0 {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// pluginDriver runs sessions in an external driver plugin, see package plugin.
+type pluginDriver struct {
+	name string
+	path string
+}
+
+func (d *pluginDriver) Connect(cc *ConnectConfig) (terminal.Terminal, error) {
+	cmd := exec.Command(d.path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start driver plugin %s: %s", d.name, err)
+	}
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			logger.Warnf("[driver %s] %s", d.name, scanner.Text())
+		}
+	}()
+
+	t := &pluginTerminal{
+		cmd:      cmd,
+		stdin:    stdin,
+		stdout:   bufio.NewReader(stdout),
+		readOnly: cc.ReadOnly,
+	}
+	if err := t.start(cc); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, fmt.Errorf("driver plugin %s: %s", d.name, err)
+	}
+	return t, nil
+}
+
+// pluginTerminal is a terminal.Terminal in a driver plugin process.
+type pluginTerminal struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	readOnly bool
+
+	writeMu sync.Mutex
+
+	// pending is output of the last frame that did not fit the Read buffer;
+	// exit is set when the plugin reports the exit. Both are only used by the
+	// reading goroutine.
+	pending []byte
+	exit    *message.Exit
+
+	closeOnce sync.Once
+	waitOnce  sync.Once
+	waitErr   error
+	exitCode  int
+}
+
+// start sends the connect request and waits for the plugin to accept it.
+func (t *pluginTerminal) start(cc *ConnectConfig) error {
+	connect := &message.Message{}
+	connect.SetType(message.TypeConnect)
+	connect.SetConnect(&message.Connect{
+		Driver:      cc.Driver,
+		Shell:       cc.Shell,
+		Environment: cc.Environment,
+		WorkDir:     cc.WorkDir,
+		User:        cc.User,
+		InitCommand: cc.InitCommand,
+		Image:       cc.Image,
+		ReadOnly:    cc.ReadOnly,
+	})
+	if err := t.send(connect); err != nil {
+		return err
+	}
+
+	timer := time.AfterFunc(pluginConnectTimeout, func() { t.cmd.Process.Kill() })
+	defer timer.Stop()
+
+	reply, err := plugin.ReadMessage(t.stdout)
+	if err != nil {
+		return fmt.Errorf("no connect reply: %s", err)
+	}
+	switch reply.Type() {
+	case message.TypeConnect:
+		return nil
+	case message.TypeError:
+		return fmt.Errorf("%s", reply.Error().Message)
+	default:
+		return fmt.Errorf("unexpected reply type %q to connect", reply.Type())
+	}
+}
+
+func (t *pluginTerminal) send(msg *message.Message) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return plugin.WriteMessage(t.stdin, msg)
+}
+
+func (t *pluginTerminal) Read(p []byte) (int, error) {
+	for len(t.pending) == 0 {
+		if t.exit != nil {
+			return 0, io.EOF
+		}
+
+		msg, err := plugin.ReadMessage(t.stdout)
+		if err != nil {
+			// the plugin exited (or broke the protocol) without an exit frame
+			return 0, io.EOF
+		}
+		switch msg.Type() {
+		case message.TypeOutput:
+			t.pending = msg.Output()
+		case message.TypeExit:
+			t.exit = msg.Exit()
+			// the session is over, make sure the plugin exits
+			t.Close()
+		}
+	}
+
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *pluginTerminal) Write(p []byte) (int, error) {
+	if t.readOnly {
+		return len(p), nil
+	}
+
+	msg := &message.Message{}
+	msg.SetType(message.TypeKey)
+	msg.SetKey(p)
+	if err := t.send(msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *pluginTerminal) Resize(rows, cols int) error {
+	msg := &message.Message{}
+	msg.SetType(message.TypeResize)
+	msg.SetResize(&message.Resize{Rows: rows, Columns: cols})
+	return t.send(msg)
+}
+
+// Close asks the plugin to end the session by closing its stdin, and kills it
+// when it does not exit within pluginExitTimeout.
+func (t *pluginTerminal) Close() error {
+	t.closeOnce.Do(func() {
+		t.writeMu.Lock()
+		t.stdin.Close()
+		t.writeMu.Unlock()
+
+		time.AfterFunc(pluginExitTimeout, func() { t.cmd.Process.Kill() })
+	})
+	return nil
+}
+
+// Wait waits for the plugin process and reports the exit code it sent; a plugin
+// that exits without one counts as exit code -1.
+func (t *pluginTerminal) Wait() error {
+	t.waitOnce.Do(func() {
+		err := t.cmd.Wait()
+		switch {
+		case t.exit != nil && t.exit.Code == 0:
+		case t.exit != nil:
+			t.exitCode = t.exit.Code
+			t.waitErr = &errors.ExitError{Code: t.exit.Code, Message: t.exit.Message}
+		default:
+			reason := "driver plugin exited without exit status"
+			if err != nil {
+				reason = fmt.Sprintf("%s: %s", reason, err)
+			}
+			t.exitCode = -1
+			t.waitErr = &errors.ExitError{Code: -1, Message: reason}
+		}
+	})
+	return t.waitErr
+}
+
+func (t *pluginTerminal) ExitCode() int {
+	return t.exitCode
+}
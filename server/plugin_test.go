@@ -0,0 +1,200 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/command/errors"
+	"github.com/go-zoox/command/terminal"
+	"github.com/go-zoox/terminal/message"
+	"github.com/go-zoox/terminal/plugin"
+)
+
+// TestDriverPluginHelperProcess is the driver plugin started by the plugin
+// tests, see writeTestDriverPlugin.
+func TestDriverPluginHelperProcess(t *testing.T) {
+	if os.Getenv("GO_TERMINAL_TEST_DRIVER_PLUGIN") != "1" {
+		return
+	}
+
+	plugin.Serve(func(cfg *message.Connect) (terminal.Terminal, error) {
+		switch cfg.InitCommand {
+		case "fail":
+			return nil, fmt.Errorf("no such container")
+		case "crash":
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				os.Exit(3)
+			}()
+		}
+
+		h := &helperTerminal{echoTerminal: newEchoTerminal()}
+		go fmt.Fprintf(h.w, "hello %s in %s\r\n", cfg.User, cfg.WorkDir)
+		return h, nil
+	})
+	os.Exit(0)
+}
+
+// helperTerminal echoes its input until it reads "bye", then exits with code 7.
+type helperTerminal struct {
+	*echoTerminal
+}
+
+func (h *helperTerminal) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte("bye")) {
+		h.w.Write(p)
+		h.w.Close()
+		return len(p), nil
+	}
+	return h.w.Write(p)
+}
+
+func (h *helperTerminal) Resize(rows, cols int) error {
+	_, err := fmt.Fprintf(h.w, "resized %dx%d\r\n", rows, cols)
+	return err
+}
+
+func (h *helperTerminal) Wait() error {
+	return &errors.ExitError{Code: 7, Message: "exit status 7"}
+}
+
+func writeTestDriverPlugin(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	script := fmt.Sprintf("#!/bin/sh\nGO_TERMINAL_TEST_DRIVER_PLUGIN=1 exec %s -test.run='^TestDriverPluginHelperProcess$'\n", shellQuote(os.Args[0]))
+	if err := os.WriteFile(filepath.Join(dir, plugin.ExecutablePrefix+"fake"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// readUntil reads from r until the output contains want.
+func readUntil(t *testing.T, r io.Reader, want string) string {
+	t.Helper()
+
+	done := make(chan string, 1)
+	go func() {
+		var out []byte
+		buf := make([]byte, 3)
+		for {
+			n, err := r.Read(buf)
+			out = append(out, buf[:n]...)
+			if strings.Contains(string(out), want) || err != nil {
+				done <- string(out)
+				return
+			}
+		}
+	}()
+
+	select {
+	case out := <-done:
+		if !strings.Contains(out, want) {
+			t.Fatalf("output = %q, want %q", out, want)
+		}
+		return out
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %q in the output", want)
+		return ""
+	}
+}
+
+func TestConfig_findDriverPlugin(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{DriverPluginDirs: []string{t.TempDir(), writeTestDriverPlugin(t)}}
+	if _, ok := cfg.findDriverPlugin("fake"); !ok {
+		t.Fatal("plugin not found")
+	}
+	for _, name := range []string{"missing", "../fake", "Fake", ""} {
+		if _, ok := cfg.findDriverPlugin(name); ok {
+			t.Errorf("found plugin %q", name)
+		}
+	}
+	if _, ok := cfg.driver("fake").(*pluginDriver); !ok {
+		t.Fatal("driver fake does not run the plugin")
+	}
+}
+
+func TestConfig_findDriverPlugin_path(t *testing.T) {
+	t.Setenv("PATH", writeTestDriverPlugin(t))
+
+	// only the server's own driver is looked up in PATH
+	if _, ok := (&Config{}).findDriverPlugin("fake"); ok {
+		t.Fatal("client driver found in PATH")
+	}
+	if _, ok := (&Config{Driver: "fake"}).findDriverPlugin("fake"); !ok {
+		t.Fatal("server driver not found in PATH")
+	}
+}
+
+func TestPluginDriver(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{DriverPluginDirs: []string{writeTestDriverPlugin(t)}}
+	session, err := connect(cfg, &ConnectConfig{Driver: "fake", User: "alice", WorkDir: "/data"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	readUntil(t, session, "hello alice in /data\r\n")
+
+	session.Write([]byte("ping"))
+	readUntil(t, session, "ping")
+
+	if err := session.Resize(40, 120); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, session, "resized 40x120")
+
+	session.Write([]byte("bye"))
+	readUntil(t, session, "bye")
+	if n, err := session.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+		t.Fatalf("Read after exit = %d, %v", n, err)
+	}
+
+	err = session.Wait()
+	if exitErr, ok := err.(*errors.ExitError); !ok || exitErr.ExitCode() != 7 || session.ExitCode() != 7 {
+		t.Fatalf("Wait() = %v, ExitCode() = %d", err, session.ExitCode())
+	}
+}
+
+func TestPluginDriver_errors(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{DriverPluginDirs: []string{writeTestDriverPlugin(t)}}
+	if _, err := connect(cfg, &ConnectConfig{Driver: "fake", InitCommand: "fail"}); err == nil || !strings.Contains(err.Error(), "no such container") {
+		t.Fatalf("connect error = %v", err)
+	}
+
+	session, err := connect(cfg, &ConnectConfig{Driver: "fake", InitCommand: "crash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	io.Copy(io.Discard, session)
+	if err := session.Wait(); session.ExitCode() != -1 || !strings.Contains(fmt.Sprint(err), "without exit status") {
+		t.Fatalf("Wait() = %v, ExitCode() = %d", err, session.ExitCode())
+	}
+}
+
+func TestConfig_driver_builtinNotFromPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, plugin.ExecutablePrefix+"host")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	if _, ok := (&Config{Driver: "host"}).driver("host").(*pluginDriver); ok {
+		t.Fatal("the host driver runs a plugin from PATH")
+	}
+}
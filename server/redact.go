@@ -0,0 +1,220 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// redactionMask replaces secrets in output.
+const redactionMask = "[REDACTED]"
+
+// knownSecretPatterns are the formats masked by Config.RedactSecrets. A pattern
+// with a capture group masks only the group.
+var knownSecretPatterns = []string{
+	// AWS access key ids
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	// GitHub tokens
+	`\bgh[pousr]_[A-Za-z0-9]{36,}\b`,
+	`\bgithub_pat_[A-Za-z0-9_]{22,}\b`,
+	// Slack tokens
+	`\bxox[abprs]-[A-Za-z0-9-]{10,}`,
+	// Google API keys
+	`\bAIza[0-9A-Za-z_\-]{35}\b`,
+	// Stripe live keys
+	`\b[rs]k_live_[0-9A-Za-z]{24,}\b`,
+	// JWTs
+	`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`,
+	// PEM private keys
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+	// password=..., api_key: ... assignments
+	`(?i)(?:password|passwd|secret|token|api[_-]?key|access[_-]?key)["']?\s*[=:]\s*["']?([^\s"']{8,})`,
+}
+
+// Redaction holds back output that may still grow into a secret: the last
+// redactHoldBytes of the output so far, whatever the size of the read, flushed
+// after redactFlushDelay when no more output arrives. Output held back for a
+// match still growing at redactMaxCarryBytes is flushed with what matched so
+// far masked.
+const (
+	redactHoldBytes     = 4096
+	redactMaxCarryBytes = 64 * 1024
+	redactFlushDelay    = 100 * time.Millisecond
+)
+
+// redactor masks secrets matching its patterns.
+type redactor struct {
+	patterns []*regexp.Regexp
+	// client is set when clients receive the redacted output too.
+	client bool
+}
+
+// newRedactor returns the redactor configured in cfg, nil when redaction is off.
+func newRedactor(cfg *Config) (*redactor, error) {
+	var sources []string
+	if cfg.RedactSecrets {
+		sources = append(sources, knownSecretPatterns...)
+	}
+	sources = append(sources, cfg.RedactPatterns...)
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	r := &redactor{client: cfg.RedactClientOutput}
+	for _, source := range sources {
+		re, err := regexp.Compile(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %s", source, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// span is a byte range [start, end) of a secret.
+type span struct {
+	start, end int
+}
+
+// matches returns the secret spans of p, sorted and merged. Unless cut is
+// len(p), cut moves back to the start of any match that crosses it or reaches
+// the end of p, where more output could still extend the match.
+func (r *redactor) matches(p []byte, cut int) ([]span, int) {
+	var spans []span
+	var whole []span
+	for _, re := range r.patterns {
+		for _, m := range re.FindAllSubmatchIndex(p, -1) {
+			whole = append(whole, span{m[0], m[1]})
+			if len(m) >= 4 && m[2] >= 0 {
+				spans = append(spans, span{m[2], m[3]})
+			} else {
+				spans = append(spans, span{m[0], m[1]})
+			}
+		}
+	}
+
+	for moved := cut < len(p); moved; {
+		moved = false
+		for _, m := range whole {
+			if m.start < cut && (m.end > cut || m.end == len(p)) {
+				cut, moved = m.start, true
+			}
+		}
+	}
+
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	merged := spans[:0]
+	for _, s := range spans {
+		if s.start == s.end {
+			continue
+		}
+		if n := len(merged); n > 0 && s.start <= merged[n-1].end {
+			if s.end > merged[n-1].end {
+				merged[n-1].end = s.end
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged, cut
+}
+
+// Redact returns p with its secrets masked.
+func (r *redactor) Redact(p []byte) []byte {
+	if r == nil {
+		return p
+	}
+	out, _ := r.redact(p, len(p))
+	return out
+}
+
+// RedactString is Redact for strings.
+func (r *redactor) RedactString(s string) string {
+	if r == nil {
+		return s
+	}
+	return string(r.Redact([]byte(s)))
+}
+
+// redact masks the secrets of p[:cut] and returns the masked output and the
+// final cut, see matches.
+func (r *redactor) redact(p []byte, cut int) ([]byte, int) {
+	spans, cut := r.matches(p, cut)
+
+	out := make([]byte, 0, cut)
+	last := 0
+	for _, s := range spans {
+		if s.end > cut {
+			break
+		}
+		out = append(out, p[last:s.start]...)
+		out = append(out, redactionMask...)
+		last = s.end
+	}
+	return append(out, p[last:cut]...), cut
+}
+
+// redactStream redacts one session's output, keeping secrets split across reads
+// together. emit is called with the redacted output in order, under the stream
+// lock.
+type redactStream struct {
+	r    *redactor
+	emit func(p []byte)
+
+	mu    sync.Mutex
+	carry []byte
+	timer *time.Timer
+}
+
+func newRedactStream(r *redactor, emit func(p []byte)) *redactStream {
+	return &redactStream{r: r, emit: emit}
+}
+
+// Write redacts p. Its tail could be the start of a secret, it is held back
+// until the next Write or Flush.
+func (s *redactStream) Write(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	data := append(s.carry, p...)
+	cut := len(data) - redactHoldBytes
+	if cut < 0 {
+		cut = 0
+	}
+
+	out, cut := s.r.redact(data, cut)
+	if len(data)-cut > redactMaxCarryBytes {
+		out, cut = s.r.redact(data, len(data))
+	}
+	s.carry = append(s.carry[:0:0], data[cut:]...)
+
+	if len(out) != 0 {
+		s.emit(out)
+	}
+	if len(s.carry) != 0 {
+		s.timer = time.AfterFunc(redactFlushDelay, s.Flush)
+	}
+}
+
+// Flush redacts and emits the held back output.
+func (s *redactStream) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	if len(s.carry) == 0 {
+		return
+	}
+
+	out := s.r.Redact(s.carry)
+	s.carry = nil
+	s.emit(out)
+}
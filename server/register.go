@@ -27,6 +27,27 @@ type RegisterOptions struct {
 
 	// DisablePage, if true, only registers the WebSocket route.
 	DisablePage bool
+
+	// AllowedOrigins lists the origins browsers may open the WebSocket from:
+	// exact origins ("https://app.example.com"), subdomain wildcards
+	// ("https://*.example.com") or "*". The server's own origin is always allowed
+	// (and needs the page's CSRF token); empty means same-origin only. Requests
+	// without an Origin header (CLI clients) are not affected.
+	AllowedOrigins []string
+	// CSRFSecret signs the CSRF token the page passes on the WebSocket upgrade.
+	// Set it when several replicas serve the same page; by default a random
+	// per-process secret is used.
+	CSRFSecret string
+
+	// SharePath is the POST route minting share links for the caller's own
+	// sessions (default "/share"). Share links open the page and WebSocket
+	// routes without other credentials: when g has its own auth middleware, let
+	// requests with a "share" query parameter through (e.g. BasicAuthConfig.Skip),
+	// the links are verified here. Keep SharePath behind the auth middleware.
+	SharePath string
+	// CommandsPath is the GET route listing the commands of a session tracked by
+	// shell integration (default "/commands", see MiddlewareOptions.CommandsPath).
+	CommandsPath string
 }
 
 // Register mounts the WebSocket handler and optionally the HTML page on g.
@@ -43,7 +64,23 @@ func Register(g *zoox.RouterGroup, opts RegisterOptions) error {
 		pagePath = "/"
 	}
 
-	if _, err := g.WebSocket(wsPath, WebSocketHandler(cfg)); err != nil {
+	sharePath := opts.SharePath
+	if sharePath == "" {
+		sharePath = "/share"
+	}
+
+	commandsPath := opts.CommandsPath
+	if commandsPath == "" {
+		commandsPath = "/commands"
+	}
+
+	var csrf *csrfTokens
+	if !opts.DisablePage {
+		csrf = newCSRFTokens(opts.CSRFSecret)
+	}
+
+	guard := newUpgradeGuard(opts.AllowedOrigins, csrf)
+	if _, err := g.WebSocket(wsPath, webSocketHandler(cfg, guard)); err != nil {
 		return err
 	}
 
@@ -52,9 +89,14 @@ func Register(g *zoox.RouterGroup, opts RegisterOptions) error {
 		g.Get(pagePath, PageHandler(PageConfig{
 			WSPath:         publicWS,
 			WelcomeMessage: opts.WelcomeMessage,
+			csrf:           csrf,
+			shareSecret:    cfg.ShareSecret,
 		}))
 	}
 
+	g.Post(sharePath, shareHandler(cfg.sessions, cfg.ShareSecret, effectivePublicWSPath(opts.BasePath, pagePath)))
+	g.Get(commandsPath, shareMiddleware(cfg.ShareSecret), commandsHandler(cfg.sessions))
+
 	return nil
 }
 
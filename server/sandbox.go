@@ -0,0 +1,41 @@
+package server
+
+import (
+	"time"
+)
+
+// SandboxConfig confines host driver sessions (Linux only). Each session runs in
+// fresh PID and mount namespaces with a private /tmp, its own /proc and the
+// resource limits below; a limit of zero is not set. The shell runs as a
+// non-root session user without capabilities and with no_new_privs, so setuid
+// binaries cannot raise its privileges. The server needs root (or
+// CAP_SYS_ADMIN) to create the namespaces. Other drivers are refused while the
+// sandbox is on.
+type SandboxConfig struct {
+	// CPUTime limits the CPU time of each process (RLIMIT_CPU).
+	CPUTime time.Duration
+	// Memory limits the address space of each process in bytes (RLIMIT_AS).
+	Memory int64
+	// OpenFiles limits the open files of each process (RLIMIT_NOFILE).
+	OpenFiles uint64
+	// Processes limits the processes of the session user (RLIMIT_NPROC); it
+	// counts all processes of the user and does not apply to root.
+	Processes uint64
+	//
+	// IsolateNetwork runs the session in a new network namespace with only the
+	// loopback interface.
+	IsolateNetwork bool
+	//
+	// ReadOnlyRoot makes the whole filesystem read-only except /tmp, /dev and
+	// WritablePaths (e.g. the home directories). WritablePaths, and the
+	// ephemeral session home, stay visible even under /tmp.
+	ReadOnlyRoot  bool
+	WritablePaths []string
+	//
+	// TmpSize is the size of the private /tmp in bytes, default: 64 MiB.
+	TmpSize int64
+}
+
+// defaultSandboxTmpSize is the size of the private /tmp when
+// SandboxConfig.TmpSize is zero.
+const defaultSandboxTmpSize = 64 << 20
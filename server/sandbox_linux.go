@@ -0,0 +1,404 @@
+//go:build linux
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"runtime"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/go-zoox/command/errors"
+	"github.com/go-zoox/command/terminal"
+	"golang.org/x/sys/unix"
+)
+
+// sandboxInitEnv carries the sandboxSpec to the sandbox init process: the
+// server binary re-executed inside the new namespaces, which sets up the mounts
+// and limits and then executes the shell (see init below).
+const sandboxInitEnv = "GO_ZOOX_TERMINAL_SANDBOX_INIT"
+
+// sandboxSpec is what the sandbox init process applies before executing Args.
+type sandboxSpec struct {
+	Args    []string `json:"args"`
+	WorkDir string   `json:"workdir"`
+	// Uid and Gid switch the user when Credential is set.
+	Credential bool     `json:"credential"`
+	Uid        int      `json:"uid"`
+	Gid        int      `json:"gid"`
+	Groups     []uint32 `json:"groups"`
+	//
+	CPUTime   uint64 `json:"cpu_time"`
+	Memory    uint64 `json:"memory"`
+	OpenFiles uint64 `json:"open_files"`
+	Processes uint64 `json:"processes"`
+	//
+	IsolateNetwork bool     `json:"isolate_network"`
+	ReadOnlyRoot   bool     `json:"read_only_root"`
+	WritablePaths  []string `json:"writable_paths"`
+	TmpSize        int64    `json:"tmp_size"`
+}
+
+func init() {
+	if spec := os.Getenv(sandboxInitEnv); spec != "" {
+		sandboxInit(spec)
+	}
+}
+
+// sandboxInit runs in the sandbox init process and never returns. Setup errors
+// are reported on file descriptor 3, which is closed on exec.
+func sandboxInit(encoded string) {
+	// capabilities and no_new_privs are per thread: set them on the thread
+	// that executes the shell
+	runtime.LockOSThread()
+
+	status := os.NewFile(3, "sandbox-status")
+	fail := func(format string, args ...interface{}) {
+		fmt.Fprintf(status, format, args...)
+		os.Exit(125)
+	}
+	unix.CloseOnExec(3)
+	os.Unsetenv(sandboxInitEnv)
+
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(encoded), &spec); err != nil {
+		fail("invalid sandbox spec: %s", err)
+	}
+	if err := spec.setup(); err != nil {
+		fail("%s", err)
+	}
+
+	err := syscall.Exec(spec.Args[0], spec.Args, os.Environ())
+	fail("exec %s: %s", spec.Args[0], err)
+}
+
+// setup prepares the sandbox from inside its namespaces.
+func (s *sandboxSpec) setup() error {
+	// keep the mounts below out of the server's namespace
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %s", err)
+	}
+
+	// hold on to the writable paths, the private /tmp may hide them
+	var keep []*os.File
+	for _, path := range s.WritablePaths {
+		f, err := os.OpenFile(path, unix.O_PATH|unix.O_DIRECTORY, 0)
+		if err != nil {
+			return fmt.Errorf("open writable path: %s", err)
+		}
+		keep = append(keep, f)
+	}
+
+	if s.ReadOnlyRoot {
+		if err := unix.MountSetattr(-1, "/", unix.AT_RECURSIVE, &unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}); err != nil {
+			return fmt.Errorf("make / read-only: %s", err)
+		}
+		// device nodes (/dev/null, /dev/pts, /dev/shm) stay usable
+		if err := unix.MountSetattr(-1, "/dev", unix.AT_RECURSIVE, &unix.MountAttr{Attr_clr: unix.MOUNT_ATTR_RDONLY}); err != nil {
+			return fmt.Errorf("make /dev writable: %s", err)
+		}
+	}
+
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, fmt.Sprintf("mode=1777,size=%d", s.TmpSize)); err != nil {
+		return fmt.Errorf("mount private /tmp: %s", err)
+	}
+
+	for i, path := range s.WritablePaths {
+		// the session user must reach the path through the parents created
+		// in the private /tmp, whatever the server's umask; the path itself is
+		// covered by the bind mount
+		umask := unix.Umask(0)
+		err := os.MkdirAll(path, 0755)
+		unix.Umask(umask)
+		if err != nil {
+			return fmt.Errorf("create writable path: %s", err)
+		}
+		source := fmt.Sprintf("/proc/self/fd/%d", keep[i].Fd())
+		if err := unix.Mount(source, path, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind writable path %s: %s", path, err)
+		}
+		keep[i].Close()
+		if err := unix.MountSetattr(-1, path, unix.AT_RECURSIVE, &unix.MountAttr{Attr_clr: unix.MOUNT_ATTR_RDONLY}); err != nil {
+			return fmt.Errorf("make %s writable: %s", path, err)
+		}
+	}
+
+	// a /proc of the new PID namespace, hiding the server's processes
+	if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("mount /proc: %s", err)
+	}
+
+	if s.IsolateNetwork {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("bring up loopback: %s", err)
+		}
+	}
+
+	for _, limit := range []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_CPU, s.CPUTime},
+		{unix.RLIMIT_AS, s.Memory},
+		{unix.RLIMIT_NOFILE, s.OpenFiles},
+		{unix.RLIMIT_NPROC, s.Processes},
+	} {
+		if limit.value == 0 {
+			continue
+		}
+		if err := unix.Setrlimit(limit.resource, &unix.Rlimit{Cur: limit.value, Max: limit.value}); err != nil {
+			return fmt.Errorf("set resource limit %d: %s", limit.resource, err)
+		}
+	}
+
+	// the shell cannot regain capabilities on exec; the user switch below
+	// still needs CAP_SETUID
+	if err := dropBoundingCapabilities(); err != nil {
+		return fmt.Errorf("drop capability bounding set: %s", err)
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("clear ambient capabilities: %s", err)
+	}
+
+	if s.Credential {
+		// syscall applies these to all threads of the process
+		if err := syscall.Setgroups(intGroups(s.Groups)); err != nil {
+			return fmt.Errorf("set groups: %s", err)
+		}
+		if err := syscall.Setgid(s.Gid); err != nil {
+			return fmt.Errorf("set gid: %s", err)
+		}
+		if err := syscall.Setuid(s.Uid); err != nil {
+			return fmt.Errorf("set uid: %s", err)
+		}
+	}
+
+	if s.WorkDir != "" {
+		if err := os.Chdir(s.WorkDir); err != nil {
+			return fmt.Errorf("change to workdir: %s", err)
+		}
+	}
+
+	var caps [2]unix.CapUserData
+	if err := unix.Capset(&unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}, &caps[0]); err != nil {
+		return fmt.Errorf("drop capabilities: %s", err)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %s", err)
+	}
+	return nil
+}
+
+// dropBoundingCapabilities empties the capability bounding set of the thread.
+func dropBoundingCapabilities() error {
+	for c := 0; ; c++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if err == unix.EINVAL {
+			// past the last capability of the kernel
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func intGroups(groups []uint32) []int {
+	out := make([]int, len(groups))
+	for i, g := range groups {
+		out[i] = int(g)
+	}
+	return out
+}
+
+// loopbackUp brings up lo in a new network namespace.
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+// sandboxPath is the PATH of sandboxed sessions, which do not inherit the
+// server's environment.
+const sandboxPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// connectSandbox runs a host session in a sandbox, see SandboxConfig.
+func connectSandbox(cc *ConnectConfig, sb *SandboxConfig) (terminal.Terminal, error) {
+	shell := cc.Shell
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	shellPath, err := exec.LookPath(shell)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &sandboxSpec{
+		Args:           []string{shellPath},
+		WorkDir:        cc.WorkDir,
+		CPUTime:        uint64(sb.CPUTime.Seconds()),
+		Memory:         uint64(sb.Memory),
+		OpenFiles:      sb.OpenFiles,
+		Processes:      sb.Processes,
+		IsolateNetwork: sb.IsolateNetwork,
+		ReadOnlyRoot:   sb.ReadOnlyRoot,
+		WritablePaths:  append([]string(nil), sb.WritablePaths...),
+		TmpSize:        sb.TmpSize,
+	}
+	if sb.CPUTime > 0 && spec.CPUTime == 0 {
+		spec.CPUTime = 1
+	}
+	if spec.TmpSize == 0 {
+		spec.TmpSize = defaultSandboxTmpSize
+	}
+	if cc.EphemeralHome != "" {
+		spec.WritablePaths = append(spec.WritablePaths, cc.EphemeralHome)
+	}
+	if cc.InitCommand != "" {
+		spec.Args = append(spec.Args, "-c", cc.InitCommand)
+	}
+
+	// the environment of the host driver; cc.Environment may replace the
+	// user's HOME and PATH
+	env := []string{"TERM=xterm", "PATH=" + sandboxPath}
+	uid := os.Getuid()
+	if cc.User != "" {
+		u, err := user.Lookup(cc.User)
+		if err != nil {
+			return nil, err
+		}
+		spec.Credential = true
+		spec.Uid, _ = strconv.Atoi(u.Uid)
+		spec.Gid, _ = strconv.Atoi(u.Gid)
+		uid = spec.Uid
+		groups, _ := u.GroupIds()
+		for _, g := range groups {
+			if id, err := strconv.Atoi(g); err == nil {
+				spec.Groups = append(spec.Groups, uint32(id))
+			}
+		}
+		env = append(env, "USER="+cc.User, "HOME="+u.HomeDir, "LOGNAME="+cc.User, "UID="+u.Uid, "GID="+u.Gid)
+	}
+	// root could leave the namespaces, e.g. through /proc or device nodes
+	if uid == 0 {
+		return nil, fmt.Errorf("sandbox: sessions must run as a non-root user, set the session user")
+	}
+	for k, v := range cc.Environment {
+		env = append(env, k+"="+v)
+	}
+	if cc.IsHistoryDisabled {
+		env = append(env, "HISTFILE=/dev/null")
+	}
+
+	encoded, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer statusR.Close()
+
+	cmd := exec.Command("/proc/self/exe")
+	cmd.Args = []string{"terminal-sandbox"}
+	cmd.Env = append(env, sandboxInitEnv+"="+string(encoded))
+	cmd.ExtraFiles = []*os.File{statusW}
+
+	cloneflags := uintptr(unix.CLONE_NEWPID | unix.CLONE_NEWNS)
+	if sb.IsolateNetwork {
+		cloneflags |= unix.CLONE_NEWNET
+	}
+	f, err := pty.StartWithAttrs(cmd, nil, &syscall.SysProcAttr{
+		Setsid:     true,
+		Setctty:    true,
+		Cloneflags: cloneflags,
+	})
+	statusW.Close()
+	if err != nil {
+		return nil, fmt.Errorf("sandbox: %s", err)
+	}
+
+	// the status pipe closes without a message once the shell runs
+	if msg, _ := io.ReadAll(statusR); len(msg) != 0 {
+		f.Close()
+		cmd.Wait()
+		return nil, fmt.Errorf("sandbox: %s", msg)
+	}
+
+	return &sandboxTerminal{File: f, cmd: cmd, readOnly: cc.ReadOnly}, nil
+}
+
+// sandboxTerminal is a terminal.Terminal on the PTY of a sandboxed session.
+type sandboxTerminal struct {
+	*os.File
+	cmd      *exec.Cmd
+	readOnly bool
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	waitOnce  sync.Once
+	waitErr   error
+}
+
+func (t *sandboxTerminal) Write(p []byte) (int, error) {
+	if t.readOnly {
+		return len(p), nil
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	return t.File.Write(p)
+}
+
+func (t *sandboxTerminal) Resize(rows, cols int) error {
+	return pty.Setsize(t.File, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+}
+
+// Close closes the PTY and kills the sandbox: the shell is PID 1 of its PID
+// namespace, so every process of the session goes with it.
+func (t *sandboxTerminal) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		err = t.File.Close()
+		t.cmd.Process.Kill()
+	})
+	return err
+}
+
+// Wait waits for the shell, reporting a non-zero exit status as
+// *errors.ExitError like the other drivers.
+func (t *sandboxTerminal) Wait() error {
+	t.waitOnce.Do(func() {
+		if err := t.cmd.Wait(); err != nil {
+			t.waitErr = &errors.ExitError{Code: t.ExitCode(), Message: err.Error()}
+		}
+	})
+	return t.waitErr
+}
+
+func (t *sandboxTerminal) ExitCode() int {
+	if t.cmd.ProcessState == nil {
+		return -1
+	}
+	return t.cmd.ProcessState.ExitCode()
+}
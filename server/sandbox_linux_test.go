@@ -0,0 +1,184 @@
+//go:build linux
+
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-zoox/command/errors"
+)
+
+// runSandboxed runs script with sh in a sandbox and returns its output.
+func runSandboxed(t *testing.T, sb *SandboxConfig, script string) (string, error) {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("the sandbox needs root")
+	}
+
+	session, err := connect(&Config{Sandbox: sb}, &ConnectConfig{Driver: "host", Shell: "/bin/sh", User: "nobody", WorkDir: "/", InitCommand: script})
+	if err != nil {
+		if strings.Contains(err.Error(), "operation not permitted") {
+			t.Skipf("no namespaces here: %s", err)
+		}
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	// the PTY reports EIO once the shell is gone
+	out, _ := io.ReadAll(session)
+	return strings.ReplaceAll(string(out), "\r\n", "\n"), session.Wait()
+}
+
+func TestSandbox(t *testing.T) {
+	t.Parallel()
+
+	// under the server's /tmp, which the sandbox replaces
+	writable, err := os.MkdirTemp("", "sandbox-writable-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(writable)
+	os.Chmod(writable, 0777)
+	hostTmp := filepath.Join(os.TempDir(), "terminal-sandbox-test")
+	os.Remove(hostTmp)
+
+	out, err := runSandboxed(t, &SandboxConfig{
+		OpenFiles:      64,
+		IsolateNetwork: true,
+		ReadOnlyRoot:   true,
+		WritablePaths:  []string{writable},
+	}, strings.Join([]string{
+		`echo "pid $$"`,
+		`echo "nofile $(ulimit -n)"`,
+		`touch /etc/terminal-sandbox-test 2>/dev/null || echo "root read-only"`,
+		`touch ` + writable + `/file && echo "writable ok"`,
+		`touch ` + hostTmp + ` && echo "tmp ok"`,
+		`echo "interfaces $(grep -c : /proc/net/dev)"`,
+		`echo hi > /dev/null && echo "dev ok"`,
+		`exit 3`,
+	}, "; "))
+
+	for _, want := range []string{"pid 1\n", "nofile 64\n", "root read-only\n", "writable ok\n", "tmp ok\n", "interfaces 1\n", "dev ok\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output misses %q:\n%s", want, out)
+		}
+	}
+	if exitErr, ok := err.(*errors.ExitError); !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("Wait() = %v, want exit status 3", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(writable, "file")); err != nil {
+		t.Error("write to the writable path was lost")
+	}
+	if _, err := os.Stat(hostTmp); err == nil {
+		t.Error("the sandbox wrote to the server's /tmp")
+	}
+}
+
+func TestSandbox_nestedWritablePath(t *testing.T) {
+	t.Parallel()
+
+	// the parents of the path do not exist in the sandbox's /tmp
+	dir, err := os.MkdirTemp("", "sandbox-nested-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writable := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(writable, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(writable, 0777)
+
+	out, err := runSandboxed(t, &SandboxConfig{ReadOnlyRoot: true, WritablePaths: []string{writable}}, strings.Join([]string{
+		`touch ` + writable + `/file && echo "writable ok"`,
+		`echo "path $PATH"`,
+	}, "; "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"writable ok\n", "path " + sandboxPath + "\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output misses %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSandbox_limits(t *testing.T) {
+	t.Parallel()
+
+	out, err := runSandboxed(t, &SandboxConfig{Memory: 256 << 20}, `ulimit -v`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(out) != "262144" {
+		t.Fatalf("ulimit -v = %q", out)
+	}
+
+	cfg := &Config{Sandbox: &SandboxConfig{ReadOnlyRoot: true, WritablePaths: []string{"/nonexistent"}}}
+	if _, err := connect(cfg, &ConnectConfig{Driver: "host", Shell: "/bin/sh", User: "nobody"}); err == nil || !strings.Contains(err.Error(), "/nonexistent") {
+		t.Fatalf("connect with a missing writable path: %v", err)
+	}
+}
+
+func TestSandbox_privileges(t *testing.T) {
+	t.Parallel()
+
+	out, err := runSandboxed(t, &SandboxConfig{}, `grep -E '^(Uid|CapInh|CapPrm|CapEff|CapBnd|CapAmb|NoNewPrivs):' /proc/self/status`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Uid:\t65534\t65534", "CapPrm:\t0000000000000000", "CapEff:\t0000000000000000", "CapBnd:\t0000000000000000", "CapAmb:\t0000000000000000", "NoNewPrivs:\t1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output misses %q:\n%s", want, out)
+		}
+	}
+
+	cfg := &Config{Sandbox: &SandboxConfig{}}
+	if _, err := connect(cfg, &ConnectConfig{Driver: "host", Shell: "/bin/sh", User: "root"}); err == nil || !strings.Contains(err.Error(), "non-root") {
+		t.Fatalf("root session: %v", err)
+	}
+	if _, err := connect(cfg, &ConnectConfig{Driver: "docker", User: "nobody"}); err == nil || !strings.Contains(err.Error(), "sandbox") {
+		t.Fatalf("docker session: %v", err)
+	}
+}
+
+func TestSandbox_ephemeralHome(t *testing.T) {
+	t.Parallel()
+
+	if os.Geteuid() != 0 {
+		t.Skip("the sandbox needs root")
+	}
+
+	// the home is under the server's /tmp, which the sandbox replaces
+	cfg := &Config{Sandbox: &SandboxConfig{ReadOnlyRoot: true}}
+	home, err := newSessionHome(cfg, "nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+
+	cc := &ConnectConfig{Driver: "host", Shell: "/bin/sh", User: "nobody", InitCommand: `echo "$(pwd) $HOME"; touch file`}
+	cc.useEphemeralHome(home)
+	session, err := connect(cfg, cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	out, _ := io.ReadAll(session)
+	if err := session.Wait(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if want := home + " " + home; !strings.Contains(string(out), want) {
+		t.Fatalf("output = %q, want %q", out, want)
+	}
+	if _, err := os.Stat(filepath.Join(home, "file")); err != nil {
+		t.Fatal("write to the session home was lost")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/go-zoox/command/terminal"
+)
+
+// connectSandbox fails: the sandbox is built on Linux namespaces.
+func connectSandbox(cc *ConnectConfig, sb *SandboxConfig) (terminal.Terminal, error) {
+	return nil, fmt.Errorf("the host sandbox requires Linux")
+}
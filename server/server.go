@@ -34,13 +34,29 @@ func (s *server) Serve() (server websocket.Server, err error) {
 }
 
 func Serve(cfg *Config) (server websocket.Server, err error) {
-	idleRetention := cfg.SessionIdleRetention
-	if idleRetention == 0 {
-		idleRetention = 60 * time.Second
+	sessions := cfg.sessions
+	if sessions == nil {
+		sessions = newConfigSessionRegistry(cfg)
+	}
+
+	audit := cfg.audit
+	if audit == nil {
+		if audit, err = newAuditLog(cfg); err != nil {
+			return nil, err
+		}
+	}
+	sessions.audit = audit
+
+	if sessions.commands, err = newCommandPolicy(cfg); err != nil {
+		return nil, err
+	}
+	if sessions.redact, err = newRedactor(cfg); err != nil {
+		return nil, err
+	}
+	sessionEnv, err := newSessionEnv(cfg)
+	if err != nil {
+		return nil, err
 	}
-	sessions := newSessionRegistry(SessionRegistryConfig{
-		TTL: idleRetention,
-	})
 
 	server, err = websocket.NewServer()
 	if err != nil {
@@ -102,10 +118,20 @@ func Serve(cfg *Config) (server websocket.Server, err error) {
 	})
 
 	server.OnClose(func(conn conn.Conn, code int, message string) error {
+		if sid, ok := conn.Get("terminal_shared_session_id").(string); ok {
+			if t, ok := conn.Get("share_expiry").(*time.Timer); ok {
+				t.Stop()
+			}
+			logger.Infof("[ID: %s] WebSocket closed (shared session_id=%s, code=%d, message=%s)", conn.ID(), sid, code, message)
+			sessions.DetachViewer(sid, conn)
+			auditDisconnect(audit, sessions, conn, sid, code, message)
+			return nil
+		}
 		if sid := conn.Get("terminal_session_id"); sid != nil {
 			if id, ok := sid.(string); ok {
 				logger.Infof("[ID: %s] WebSocket closed (session_id=%s, code=%d, message=%s)", conn.ID(), id, code, message)
 				sessions.noteDisconnected(id)
+				auditDisconnect(audit, sessions, conn, id, code, message)
 				return nil
 			}
 		}
@@ -123,14 +149,28 @@ func Serve(cfg *Config) (server websocket.Server, err error) {
 		switch msg.Type() {
 		case message.TypeConnect:
 			data := msg.Connect()
+
+			// a share link only opens the session it was minted for
+			if identity, ok := IdentityFromRequest(conn.Request()); ok && identity.Share != nil {
+				joinSharedSession(conn, sessions, identity.Share)
+				return nil
+			}
+
 			if data.SessionID != "" {
 				if session, ok := sessions.LookupSession(data.SessionID); ok {
+					if identity, _ := IdentityFromRequest(conn.Request()); !sessions.Accessible(data.SessionID, identity) {
+						rejectReconnect(conn, audit, data.SessionID)
+						return nil
+					}
+
+					readOnly := connReadOnly(cfg, conn, data)
 					conn.Set("session", session)
 					conn.Set("terminal_session_id", data.SessionID)
+					conn.Set("read_only", readOnly)
 
 					msg := &message.Message{}
 					msg.SetType(message.TypeConnect)
-					msg.SetConnect(&message.Connect{SessionID: data.SessionID})
+					msg.SetConnect(&message.Connect{SessionID: data.SessionID, ReadOnly: readOnly})
 					if err := msg.Serialize(); err != nil {
 						logger.Errorf("ID: %s] failed to serialize message: %s", conn.ID(), err)
 						return nil
@@ -140,11 +180,21 @@ func Serve(cfg *Config) (server websocket.Server, err error) {
 						logger.Errorf("[ID: %s] session replay: %s", conn.ID(), err)
 					}
 					sessions.AttachWriter(data.SessionID, conn)
+					audit.Log(newConnAuditEvent(AuditEventReconnect, conn, data.SessionID))
 					logger.Infof("[session %s] WebSocket reconnected: session restored, idle eviction timer reset [conn %s]", data.SessionID, conn.ID())
 					return nil
 				}
 			}
 
+			// the ssh driver only changes to a directory the client asked for,
+			// the defaults below name directories of this server
+			sshWorkDir := data.WorkDir
+
+			identity, hasIdentity := IdentityFromRequest(conn.Request())
+			if hasIdentity && identity.Defaults != nil {
+				identity.Defaults.apply(data)
+			}
+
 			if data.Driver == "" {
 				data.Driver = cfg.Driver
 			}
@@ -177,16 +227,60 @@ func Serve(cfg *Config) (server websocket.Server, err error) {
 				Image:             data.Image,
 				IsHistoryDisabled: cfg.IsHistoryDisabled,
 				ReadOnly:          cfg.ReadOnly,
+				//
+				SSHHost:       data.SSHHost,
+				SSHPort:       data.SSHPort,
+				SSHUser:       data.SSHUser,
+				SSHPassword:   data.SSHPassword,
+				SSHPrivateKey: data.SSHPrivateKey,
+				SSHWorkDir:    sshWorkDir,
 			}
 
 			// @TODO
 			withQuery(&zoox.Context{Request: conn.Request()}, connectCfg)
 
-			logger.Debugf("connect cfg: %v", connectCfg)
+			logger.Debugf("connect cfg: %+v", newAuditConnect(connectCfg))
 
-			session, err := connect(connectCfg)
+			if reason := sessions.commands.checkInitCommand(connectCfg.InitCommand); reason != "" {
+				err = fmt.Errorf("init command blocked by policy: %s", reason)
+			} else if connectCfg.Driver == "ssh" && cfg.isBuiltinDriver("ssh") {
+				err = applySSHConfig(cfg, connectCfg)
+			}
+
+			// the id is known before the session starts so its shell can see it
+			sessionID := randomSessionID()
+			if err == nil {
+				err = sessionEnv.apply(connectCfg, newSessionContext(conn, sessionID, connectCfg))
+			}
+
+			if err == nil && cfg.EphemeralHome && connectCfg.Driver == "host" {
+				var home string
+				if connectCfg.User != "" && cfg.Sandbox == nil {
+					// the host engine would replace HOME with the user's home
+					err = fmt.Errorf("ephemeral home for user %s requires the sandbox", connectCfg.User)
+				} else if home, err = newSessionHome(cfg, connectCfg.User); err == nil {
+					connectCfg.useEphemeralHome(home)
+				}
+			}
+
+			var session terminal.Terminal
+			if err == nil {
+				if cfg.ShellIntegration {
+					session, err = connect(cfg, withShellIntegration(connectCfg))
+				} else {
+					session, err = connect(cfg, connectCfg)
+				}
+			}
 			if err != nil {
 				logger.Errorf("[ID: %s] failed to connect: %s", conn.ID(), err)
+				if connectCfg.EphemeralHome != "" {
+					removeSessionHome(cfg, connectCfg.EphemeralHome, true)
+				}
+
+				event := newConnAuditEvent(AuditEventConnect, conn, "")
+				event.Connect = newAuditConnect(connectCfg)
+				event.Reason = fmt.Sprintf("failed to connect: %s", err)
+				audit.Log(event)
 
 				msg := &message.Message{}
 				msg.SetType(message.TypeExit)
@@ -205,13 +299,27 @@ func Serve(cfg *Config) (server websocket.Server, err error) {
 				return nil
 			}
 
-			sessionID := sessions.Register(session)
+			readOnly := connReadOnly(cfg, conn, data)
+			event := newConnAuditEvent(AuditEventConnect, conn, sessionID)
+			event.Connect = newAuditConnect(connectCfg)
+			event.Connect.ReadOnly = readOnly
+			sessions.RegisterID(sessionID, session, event)
 			conn.Set("session", session)
 			conn.Set("terminal_session_id", sessionID)
+			conn.Set("read_only", readOnly)
+			if hasIdentity {
+				logger.Infof("[session %s] started by %s (%s) [conn %s]", sessionID, identity.Subject, identity.Method, conn.ID())
+			}
+			audit.Log(event)
+			if home := connectCfg.EphemeralHome; home != "" {
+				sessions.SetCleanup(sessionID, func(failed bool) {
+					removeSessionHome(cfg, home, failed)
+				})
+			}
 
 			msg := &message.Message{}
 			msg.SetType(message.TypeConnect)
-			msg.SetConnect(&message.Connect{SessionID: sessionID})
+			msg.SetConnect(&message.Connect{SessionID: sessionID, ReadOnly: readOnly})
 			if err := msg.Serialize(); err != nil {
 				logger.Errorf("ID: %s] failed to serialize message: %s", conn.ID(), err)
 				return nil
@@ -227,17 +335,38 @@ func Serve(cfg *Config) (server websocket.Server, err error) {
 				return nil
 			}
 			session := v.(terminal.Terminal)
+			if readOnly, _ := conn.Get("read_only").(bool); readOnly {
+				logger.Debugf("[ID: %s] ignored input on read-only connection", conn.ID())
 
-			if _, err := session.Write(msg.Key()); err != nil {
+				msg := &message.Message{}
+				msg.SetType(message.TypeError)
+				msg.SetError(&message.Error{Message: "read-only connection, input ignored"})
+				if err := msg.Serialize(); err != nil {
+					logger.Errorf("[ID: %s] failed to serialize message: %s", conn.ID(), err)
+					return nil
+				}
+				conn.WriteBinaryMessage(msg.Msg())
+				return nil
+			}
+
+			sid, owner := conn.Get("terminal_session_id").(string)
+			if !owner {
+				sid, _ = conn.Get("terminal_shared_session_id").(string)
+			}
+			key, lines := sessions.FilterInput(sid, msg.Key())
+			for _, line := range lines {
+				logCommand(conn, sessions, sid, line)
+			}
+
+			if _, err := session.Write(key); err != nil {
 				logger.Errorf("[ID: %s] session write: %s", conn.ID(), err)
 				conn.Close()
 				return nil
 			}
-			if sid := conn.Get("terminal_session_id"); sid != nil {
-				if id, ok := sid.(string); ok {
-					sessions.RecordKeyTail(id, msg.Key())
-				}
+			if owner {
+				sessions.RecordKeyTail(sid, key)
 			}
+			sessions.CountInput(sid, len(msg.Key()))
 		case message.TypeResize:
 			v := conn.Get("session")
 			if v == nil {
@@ -246,11 +375,22 @@ func Serve(cfg *Config) (server websocket.Server, err error) {
 				return nil
 			}
 			session := v.(terminal.Terminal)
+			if readOnly, _ := conn.Get("read_only").(bool); readOnly {
+				return nil
+			}
 			resize := msg.Resize()
 			err = session.Resize(resize.Rows, resize.Columns)
 			if err != nil {
 				logger.Errorf("ID: %s] Failed to resize terminal: %s", conn.ID(), err)
 			}
+
+			sid, _ := conn.Get("terminal_session_id").(string)
+			if sid == "" {
+				sid, _ = conn.Get("terminal_shared_session_id").(string)
+			}
+			event := newConnAuditEvent(AuditEventResize, conn, sid)
+			event.Columns, event.Rows = resize.Columns, resize.Rows
+			audit.Log(event)
 		case message.TypeHeartBeat:
 			logger.Debugf("[ID: %s][heartbeat] receive ...", conn.ID())
 		default:
@@ -266,6 +406,126 @@ func Serve(cfg *Config) (server websocket.Server, err error) {
 	return
 }
 
+// joinSharedSession attaches a share link connection to its session as an
+// additional viewer, read-only unless the link grants read-write, and closes the
+// connection when the link expires.
+func joinSharedSession(conn websocket.Conn, sessions *sessionRegistry, share *Share) {
+	session, ok := sessions.LookupSession(share.SessionID)
+	if !ok {
+		logger.Infof("[ID: %s] share link for ended session %s", conn.ID(), share.SessionID)
+
+		msg := &message.Message{}
+		msg.SetType(message.TypeExit)
+		msg.SetExit(&message.Exit{
+			Code:    1,
+			Message: "the shared session has ended",
+		})
+		if err := msg.Serialize(); err != nil {
+			logger.Errorf("[ID: %s] failed to serialize message: %s", conn.ID(), err)
+			return
+		}
+		conn.WriteBinaryMessage(msg.Msg())
+		conn.Close()
+		return
+	}
+
+	conn.Set("session", session)
+	conn.Set("terminal_shared_session_id", share.SessionID)
+	conn.Set("read_only", share.ReadOnly())
+	conn.Set("share_expiry", time.AfterFunc(time.Until(share.ExpiresAt), func() {
+		logger.Infof("[session %s] share link expired [conn %s]", share.SessionID, conn.ID())
+		conn.Close()
+	}))
+
+	msg := &message.Message{}
+	msg.SetType(message.TypeConnect)
+	msg.SetConnect(&message.Connect{SessionID: share.SessionID, ReadOnly: share.ReadOnly()})
+	if err := msg.Serialize(); err != nil {
+		logger.Errorf("ID: %s] failed to serialize message: %s", conn.ID(), err)
+		return
+	}
+	conn.WriteBinaryMessage(msg.Msg())
+	if err := sessions.WriteViewerReplay(share.SessionID, conn); err != nil {
+		logger.Errorf("[ID: %s] session replay: %s", conn.ID(), err)
+	}
+	sessions.AttachViewer(share.SessionID, conn)
+	sessions.audit.Log(newConnAuditEvent(AuditEventShareJoin, conn, share.SessionID))
+	logger.Infof("[session %s] joined via share link (%s, expires %s) [conn %s]", share.SessionID, share.Role, share.ExpiresAt.Format(time.RFC3339), conn.ID())
+}
+
+// rejectReconnect refuses conn the session id started by another user.
+func rejectReconnect(conn websocket.Conn, audit *auditLog, id string) {
+	logger.Warnf("[ID: %s] refused reconnect to session %s of another user", conn.ID(), id)
+
+	event := newConnAuditEvent(AuditEventReconnect, conn, id)
+	event.Reason = "the session belongs to another user"
+	audit.Log(event)
+
+	msg := &message.Message{}
+	msg.SetType(message.TypeExit)
+	msg.SetExit(&message.Exit{
+		Code:    1,
+		Message: "the session belongs to another user",
+	})
+	if err := msg.Serialize(); err != nil {
+		logger.Errorf("[ID: %s] failed to serialize message: %s", conn.ID(), err)
+		return
+	}
+	conn.WriteBinaryMessage(msg.Msg())
+	conn.Close()
+}
+
+// connReadOnly reports whether conn may only watch its session: on a read-only
+// server, for a read-only identity, or when the client asked for it in the
+// connect message or the read_only query parameter. Clients cannot lift it.
+func connReadOnly(cfg *Config, conn websocket.Conn, data *message.Connect) bool {
+	if cfg.ReadOnly || data.ReadOnly {
+		return true
+	}
+	if identity, ok := IdentityFromRequest(conn.Request()); ok && identity.ReadOnly() {
+		return true
+	}
+	ctx := &zoox.Context{Request: conn.Request()}
+	return ctx.Query().Get("read_only").Bool()
+}
+
+// newConfigSessionRegistry returns the session registry of cfg, evicting
+// sessions after Config.SessionIdleRetention.
+func newConfigSessionRegistry(cfg *Config) *sessionRegistry {
+	idleRetention := cfg.SessionIdleRetention
+	if idleRetention == 0 {
+		idleRetention = 60 * time.Second
+	}
+	return newSessionRegistry(SessionRegistryConfig{
+		TTL: idleRetention,
+	})
+}
+
+// newConnAuditEvent returns an event of typ about the connection and its identity.
+func newConnAuditEvent(typ string, conn websocket.Conn, sessionID string) *AuditEvent {
+	event := &AuditEvent{
+		Type:       typ,
+		SessionID:  sessionID,
+		ConnID:     conn.ID(),
+		RemoteAddr: conn.Request().RemoteAddr,
+	}
+	return event.withIdentity(IdentityFromRequest(conn.Request()))
+}
+
+// auditDisconnect logs the disconnect of conn from session sessionID with the
+// session totals.
+func auditDisconnect(audit *auditLog, sessions *sessionRegistry, conn websocket.Conn, sessionID string, code int, message string) {
+	event := newConnAuditEvent(AuditEventDisconnect, conn, sessionID)
+	if totals := sessions.AuditEvent(sessionID, AuditEventDisconnect); totals != nil {
+		event.BytesIn, event.BytesOut = totals.BytesIn, totals.BytesOut
+	}
+	event.Reason = fmt.Sprintf("code %d", code)
+	if message != "" {
+		event.Reason += ": " + message
+	}
+	audit.Log(event)
+}
+
 // bridgeWSConn is the subset of websocket.Conn used by the PTY bridge (tests provide a small mock).
 type bridgeWSConn interface {
 	WriteBinaryMessage(msg []byte) error
@@ -369,8 +629,13 @@ func withQuery(ctx *zoox.Context, cfg *ConnectConfig) {
 		cfg.Driver = v
 	}
 
-	if v := ctx.Query().Get("workdir").String(); cfg.WorkDir == "" && v != "" {
-		cfg.WorkDir = v
+	if v := ctx.Query().Get("workdir").String(); v != "" {
+		if cfg.WorkDir == "" {
+			cfg.WorkDir = v
+		}
+		if cfg.SSHWorkDir == "" {
+			cfg.SSHWorkDir = v
+		}
 	}
 
 	if v := ctx.Query().Get("user").String(); cfg.User == "" && v != "" {
@@ -400,4 +665,16 @@ func withQuery(ctx *zoox.Context, cfg *ConnectConfig) {
 	if v := ctx.Query().Get("wait_until_finished").Bool(); !cfg.WaitUntilFinished && v {
 		cfg.WaitUntilFinished = v
 	}
+
+	if v := ctx.Query().Get("ssh_host").String(); cfg.SSHHost == "" && v != "" {
+		cfg.SSHHost = v
+	}
+
+	if v := ctx.Query().Get("ssh_port").Int(); cfg.SSHPort == 0 && v > 0 {
+		cfg.SSHPort = int(v)
+	}
+
+	if v := ctx.Query().Get("ssh_user").String(); cfg.SSHUser == "" && v != "" {
+		cfg.SSHUser = v
+	}
 }
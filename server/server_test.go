@@ -27,6 +27,7 @@ func TestWithQuery(t *testing.T) {
 		Shell:             "/bin/zsh",
 		Driver:            "docker",
 		WorkDir:           "/tmp",
+		SSHWorkDir:        "/tmp",
 		User:              "nobody",
 		Image:             "alpine",
 		Environment:       map[string]string{"FOO": "bar", "EMPTY": ""},
@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-zoox/command/errors"
@@ -37,6 +38,7 @@ type sessionEntry struct {
 
 	mu       sync.Mutex
 	ws       bridgeWSConn
+	viewers  map[bridgeWSConn]struct{} // share link connections, receive output besides ws
 	pumpOnce sync.Once
 	// idleDeadline is non-zero only while no WebSocket is attached (or after transport loss);
 	// the session is removed when now passes idleDeadline. Cleared in attachWriter on reconnect.
@@ -47,6 +49,47 @@ type sessionEntry struct {
 
 	keyMu   sync.Mutex
 	keyTail []byte // recent TypeKey payloads (for no-echo lines not present in replay)
+
+	// owner is who started the session, for audit events without a connection.
+	owner    AuditEvent
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+
+	// lines reconstructs submitted command lines when command rules are on;
+	// altScreen pauses it while a full-screen program runs.
+	lineMu    sync.Mutex
+	lines     lineEditor
+	altScreen atomic.Bool
+
+	// cleanup runs when the session is over; failed reports a non-zero exit
+	// that is not the result of an idle eviction.
+	cleanup func(failed bool)
+	evicted atomic.Bool
+
+	// shell follows the commands marked by shell integration in the output.
+	shell *commandTracker
+}
+
+// auditEvent returns an event of typ about the session with its owner and byte
+// counts.
+func (e *sessionEntry) auditEvent(typ string) *AuditEvent {
+	owner := e.sessionOwner()
+	return &AuditEvent{
+		Type:       typ,
+		SessionID:  e.id,
+		Subject:    owner.Subject,
+		AuthMethod: owner.AuthMethod,
+		RemoteAddr: owner.RemoteAddr,
+		BytesIn:    e.bytesIn.Load(),
+		BytesOut:   e.bytesOut.Load(),
+	}
+}
+
+// sessionOwner returns who started the session, see sessionRegistry.SetOwner.
+func (e *sessionEntry) sessionOwner() AuditEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.owner
 }
 
 func (e *sessionEntry) appendReplay(p []byte) {
@@ -107,10 +150,48 @@ func (e *sessionEntry) closeAttachedWebSocket() {
 	e.mu.Lock()
 	w := e.ws
 	e.ws = nil
+	viewers := e.viewers
+	e.viewers = nil
 	e.mu.Unlock()
 	if w != nil {
 		_ = w.Close()
 	}
+	for v := range viewers {
+		_ = v.Close()
+	}
+}
+
+func (e *sessionEntry) attachViewer(ws bridgeWSConn) {
+	e.mu.Lock()
+	if e.viewers == nil {
+		e.viewers = map[bridgeWSConn]struct{}{}
+	}
+	e.viewers[ws] = struct{}{}
+	e.mu.Unlock()
+	e.startPump()
+}
+
+func (e *sessionEntry) detachViewer(ws bridgeWSConn) {
+	e.mu.Lock()
+	delete(e.viewers, ws)
+	e.mu.Unlock()
+}
+
+// writeViewers sends msg to the share link connections, dropping those that fail.
+func (e *sessionEntry) writeViewers(msg []byte) {
+	e.mu.Lock()
+	viewers := make([]bridgeWSConn, 0, len(e.viewers))
+	for v := range e.viewers {
+		viewers = append(viewers, v)
+	}
+	e.mu.Unlock()
+
+	for _, v := range viewers {
+		if err := v.WriteBinaryMessage(msg); err != nil {
+			e.detachViewer(v)
+			_ = v.Close()
+		}
+	}
 }
 
 func (e *sessionEntry) attachWriter(ws bridgeWSConn) {
@@ -136,55 +217,66 @@ func (e *sessionEntry) runPump() {
 			time.Sleep(closeDelay)
 		}
 		e.session.Close()
-		e.mu.Lock()
-		ws := e.ws
-		e.ws = nil
-		e.mu.Unlock()
-		if ws != nil {
-			ws.Close()
-		}
+		e.closeAttachedWebSocket()
 		e.reg.deleteID(e.id)
+		if e.cleanup != nil {
+			e.cleanup(!e.evicted.Load() && e.session.ExitCode() != 0)
+		}
 	}()
 
+	// with redaction, the replay (and with RedactClientOutput the clients) get
+	// the output through the redaction stream
+	var redacted *redactStream
+	redact := e.reg.redact
+	if redact != nil {
+		redacted = newRedactStream(redact, func(p []byte) {
+			e.appendReplay(p)
+			if redact.client {
+				if err := e.writeOutput(p); err != nil {
+					logger.Errorf("failed to serialize message: %s", err)
+				}
+			}
+		})
+	}
+
 	buf := make([]byte, 1024)
-readLoop:
 	for {
 		n, err := e.session.Read(buf)
 		if err != nil {
 			break
 		}
-		e.appendReplay(buf[:n])
-
-		msg := &message.Message{}
-		msg.SetType(message.TypeOutput)
-		msg.SetOutput(buf[:n])
-		if err := msg.Serialize(); err != nil {
-			logger.Errorf("failed to serialize message: %s", err)
-			break readLoop
+		e.bytesOut.Add(int64(n))
+		e.shell.Feed(buf[:n])
+		if e.reg.commands != nil {
+			e.noteAltScreen(buf[:n])
 		}
 
-		e.mu.Lock()
-		ws := e.ws
-		e.mu.Unlock()
-		if ws == nil {
+		if redacted != nil {
+			if !redact.client {
+				if err := e.writeOutput(buf[:n]); err != nil {
+					logger.Errorf("failed to serialize message: %s", err)
+					break
+				}
+			}
+			redacted.Write(buf[:n])
 			continue
 		}
-		if err = ws.WriteBinaryMessage(msg.Msg()); err != nil {
-			e.mu.Lock()
-			if e.ws == ws {
-				e.ws = nil
-			}
-			e.mu.Unlock()
-			e.reg.noteDisconnected(e.id)
-			// Tear down the half-dead socket; otherwise the browser stays "open" while the pump
-			// no longer writes and idle TTL may later Close the PTY, leaving Write broken.
-			_ = ws.Close()
+
+		e.appendReplay(buf[:n])
+		if err := e.writeOutput(buf[:n]); err != nil {
+			logger.Errorf("failed to serialize message: %s", err)
+			break
 		}
 	}
+	if redacted != nil {
+		redacted.Flush()
+	}
 
 	if err := e.session.Wait(); err != nil {
 		if exitErr, ok := err.(*errors.ExitError); ok {
 			logger.Errorf("[session] exit status: %d", exitErr.ExitCode())
+			e.shell.End(exitErr.ExitCode())
+			e.auditExit(exitErr.ExitCode(), exitErr.Error())
 
 			msg := &message.Message{}
 			msg.SetType(message.TypeExit)
@@ -197,6 +289,7 @@ readLoop:
 				return
 			}
 
+			e.writeViewers(msg.Msg())
 			e.mu.Lock()
 			ws := e.ws
 			e.mu.Unlock()
@@ -212,6 +305,9 @@ readLoop:
 		}
 	}
 
+	e.shell.End(e.session.ExitCode())
+	e.auditExit(e.session.ExitCode(), "")
+
 	msg := &message.Message{}
 	msg.SetType(message.TypeExit)
 	msg.SetExit(&message.Exit{
@@ -222,6 +318,7 @@ readLoop:
 		return
 	}
 
+	e.writeViewers(msg.Msg())
 	e.mu.Lock()
 	ws := e.ws
 	e.mu.Unlock()
@@ -230,10 +327,97 @@ readLoop:
 	}
 }
 
+// noteAltScreen tracks full-screen programs in output; keys typed into them are
+// not command lines.
+func (e *sessionEntry) noteAltScreen(output []byte) {
+	switch altScreenSwitch(output) {
+	case 1:
+		e.altScreen.Store(true)
+	case -1:
+		e.altScreen.Store(false)
+	default:
+		return
+	}
+	e.lineMu.Lock()
+	e.lines.reset()
+	e.lineMu.Unlock()
+}
+
+// filterInput feeds client input to the line editor, see lineEditor.Feed.
+func (e *sessionEntry) filterInput(p []byte, policy *commandPolicy) ([]byte, []submittedLine) {
+	if e.altScreen.Load() {
+		return p, nil
+	}
+
+	check := func(string, bool) string { return "" }
+	if policy.enforcing() {
+		check = policy.Check
+	}
+
+	e.lineMu.Lock()
+	defer e.lineMu.Unlock()
+	return e.lines.Feed(p, check)
+}
+
+// writeOutput sends p as TypeOutput to the viewers and the attached WebSocket,
+// dropping a WebSocket that fails.
+func (e *sessionEntry) writeOutput(p []byte) error {
+	msg := &message.Message{}
+	msg.SetType(message.TypeOutput)
+	msg.SetOutput(p)
+	if err := msg.Serialize(); err != nil {
+		return err
+	}
+
+	e.writeViewers(msg.Msg())
+
+	e.mu.Lock()
+	ws := e.ws
+	e.mu.Unlock()
+	if ws == nil {
+		return nil
+	}
+	if err := ws.WriteBinaryMessage(msg.Msg()); err != nil {
+		e.mu.Lock()
+		if e.ws == ws {
+			e.ws = nil
+		}
+		e.mu.Unlock()
+		e.reg.noteDisconnected(e.id)
+		// Tear down the half-dead socket; otherwise the browser stays "open" while the pump
+		// no longer writes and idle TTL may later Close the PTY, leaving Write broken.
+		_ = ws.Close()
+	}
+	return nil
+}
+
+// auditShellCommand logs a command finished in the session, see commandTracker.
+func (e *sessionEntry) auditShellCommand(c ShellCommand) {
+	logger.Debugf("[session %s] command %d finished (exit code %v, %dms): %q", e.id, c.Seq, c.ExitCode, c.DurationMS, c.Command)
+
+	event := e.auditEvent(AuditEventShellCommand)
+	event.Command = c.Command
+	event.ExitCode = c.ExitCode
+	event.DurationMS = c.DurationMS
+	event.Cwd = c.Cwd
+	e.reg.audit.Log(event)
+}
+
+func (e *sessionEntry) auditExit(code int, reason string) {
+	event := e.auditEvent(AuditEventExit)
+	event.ExitCode = &code
+	event.Reason = reason
+	e.reg.audit.Log(event)
+}
+
 type sessionRegistry struct {
 	mu   sync.RWMutex
 	byID map[string]*sessionEntry
 	cfg  SessionRegistryConfig
+
+	audit    *auditLog
+	commands *commandPolicy
+	redact   *redactor
 }
 
 func newSessionRegistry(cfg SessionRegistryConfig) *sessionRegistry {
@@ -310,20 +494,42 @@ func (r *sessionRegistry) noteDisconnected(id string) {
 // so the browser runs term.open before any TypeOutput frames.
 func (r *sessionRegistry) Register(session terminal.Terminal) string {
 	id := randomSessionID()
+	r.RegisterID(id, session, nil)
+	return id
+}
+
+// RegisterID is Register with an id from randomSessionID, for a session that
+// knows its id before it starts. owner (Subject, AuthMethod, RemoteAddr) is who
+// started it, nil without authentication; it is set before the session can be
+// found, so no other user can reconnect to it in between.
+func (r *sessionRegistry) RegisterID(id string, session terminal.Terminal, owner *AuditEvent) {
 	e := &sessionEntry{
 		id:      id,
 		session: session,
 		reg:     r,
 	}
+	if owner != nil {
+		e.owner = *owner
+	}
+	e.shell = newCommandTracker(r.redact, e.auditShellCommand)
 	r.mu.Lock()
 	r.byID[id] = e
 	r.mu.Unlock()
-	return id
 }
 
 // WriteSessionReplay sends a snapshot of buffered PTY output as TypeOutput frames (for xterm after reconnect).
 // Call after the Connect ack and before AttachWriter so the client opens the terminal before replay.
 func (r *sessionRegistry) WriteSessionReplay(id string, ws bridgeWSConn) error {
+	return r.writeReplay(id, ws, true)
+}
+
+// WriteViewerReplay is WriteSessionReplay for a share link connection: it leaves
+// the key tail to the session's own client.
+func (r *sessionRegistry) WriteViewerReplay(id string, ws bridgeWSConn) error {
+	return r.writeReplay(id, ws, false)
+}
+
+func (r *sessionRegistry) writeReplay(id string, ws bridgeWSConn, withKeys bool) error {
 	if id == "" || ws == nil {
 		return nil
 	}
@@ -351,7 +557,11 @@ func (r *sessionRegistry) WriteSessionReplay(id string, ws bridgeWSConn) error {
 		}
 	}
 
-	keys := e.snapshotKeyTail()
+	if !withKeys {
+		return nil
+	}
+
+	keys := r.redact.Redact(e.snapshotKeyTail())
 	if len(keys) > 0 && !bytes.HasSuffix(data, keys) {
 		msg := &message.Message{}
 		msg.SetType(message.TypeOutput)
@@ -397,6 +607,119 @@ func (r *sessionRegistry) AttachWriter(id string, ws bridgeWSConn) bool {
 	return true
 }
 
+// AttachViewer adds ws as a share link connection of session id: it receives the
+// output alongside the session's own client without replacing it.
+func (r *sessionRegistry) AttachViewer(id string, ws bridgeWSConn) bool {
+	if id == "" || ws == nil {
+		return false
+	}
+	r.mu.RLock()
+	e := r.byID[id]
+	r.mu.RUnlock()
+	if e == nil {
+		return false
+	}
+	e.attachViewer(ws)
+	return true
+}
+
+// DetachViewer removes a share link connection added by AttachViewer.
+func (r *sessionRegistry) DetachViewer(id string, ws bridgeWSConn) {
+	r.mu.RLock()
+	e := r.byID[id]
+	r.mu.RUnlock()
+	if e != nil {
+		e.detachViewer(ws)
+	}
+}
+
+// SetOwner records who started session id (Subject, AuthMethod, RemoteAddr of
+// owner) for its later audit events.
+func (r *sessionRegistry) SetOwner(id string, owner *AuditEvent) {
+	if e := r.entry(id); e != nil {
+		e.mu.Lock()
+		e.owner = *owner
+		e.mu.Unlock()
+	}
+}
+
+// Accessible reports whether identity may use session id started by another
+// connection (reconnect, list its commands, share it), identity being nil for
+// anonymous requests: only the identity that started it, or an admin. A share
+// link only reaches its own session. Sessions started without authentication
+// are open to everyone.
+func (r *sessionRegistry) Accessible(id string, identity *Identity) bool {
+	e := r.entry(id)
+	if e == nil {
+		return false
+	}
+	owner := e.sessionOwner()
+	if owner.AuthMethod == "" {
+		return true
+	}
+	if identity == nil {
+		return false
+	}
+	if identity.Share != nil {
+		return identity.Share.SessionID == id
+	}
+	if identity.Admin() {
+		return true
+	}
+	return identity.Method == owner.AuthMethod && identity.Subject == owner.Subject
+}
+
+// ShellCommands returns the commands tracked in session id, see commandTracker.
+func (r *sessionRegistry) ShellCommands(id string) ([]ShellCommand, bool) {
+	e := r.entry(id)
+	if e == nil {
+		return nil, false
+	}
+	return e.shell.Commands(), true
+}
+
+// SetCleanup sets a function to run when the session is over, see
+// sessionEntry.cleanup.
+func (r *sessionRegistry) SetCleanup(id string, cleanup func(failed bool)) {
+	if e := r.entry(id); e != nil {
+		e.cleanup = cleanup
+	}
+}
+
+// CountInput adds n bytes of client input to the session totals.
+func (r *sessionRegistry) CountInput(id string, n int) {
+	if e := r.entry(id); e != nil {
+		e.bytesIn.Add(int64(n))
+	}
+}
+
+// AuditEvent returns an event of typ about session id, nil when it is gone.
+func (r *sessionRegistry) AuditEvent(id, typ string) *AuditEvent {
+	if e := r.entry(id); e != nil {
+		return e.auditEvent(typ)
+	}
+	return nil
+}
+
+// FilterInput returns the part of client input p to write to session id and the
+// command lines it submits. Input passes unchanged when command rules are off.
+func (r *sessionRegistry) FilterInput(id string, p []byte) ([]byte, []submittedLine) {
+	if r.commands == nil {
+		return p, nil
+	}
+	e := r.entry(id)
+	if e == nil {
+		return p, nil
+	}
+	return e.filterInput(p, r.commands)
+}
+
+func (r *sessionRegistry) entry(id string) *sessionEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byID[id]
+}
+
 // registerSessionOnly stores a session without starting the pump (tests / idle entries until Bind).
 func (r *sessionRegistry) registerSessionOnly(session terminal.Terminal) string {
 	id := randomSessionID()
@@ -405,6 +728,7 @@ func (r *sessionRegistry) registerSessionOnly(session terminal.Terminal) string
 		session: session,
 		reg:     r,
 	}
+	e.shell = newCommandTracker(r.redact, e.auditShellCommand)
 	r.mu.Lock()
 	r.byID[id] = e
 	r.mu.Unlock()
@@ -463,6 +787,8 @@ func (r *sessionRegistry) Get(id string) terminal.Terminal {
 // idleEvictSessionEntry logs, closes transport and PTY after idle deadline (sweep or lazy check).
 func idleEvictSessionEntry(en *sessionEntry, id string, deadline time.Time) {
 	logger.Infof("[session %s] idle deadline reached without reconnect: closing session and releasing PTY (scheduled eviction %s)", id, deadline.Format(time.RFC3339))
+	en.reg.audit.Log(en.auditEvent(AuditEventIdleEvict))
+	en.evicted.Store(true)
 	en.closeAttachedWebSocket()
 	if err := en.session.Close(); err != nil {
 		logger.Errorf("[session %s] failed to close session: %v", id, err)
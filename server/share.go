@@ -0,0 +1,255 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-zoox/logger"
+	"github.com/go-zoox/zoox"
+)
+
+// Share roles: a read-only share only watches the session, a read-write share
+// may also type and resize.
+const (
+	ShareRoleReadOnly  = "ro"
+	ShareRoleReadWrite = "rw"
+)
+
+// shareQueryParam carries the share token on the page and WebSocket URLs.
+const shareQueryParam = "share"
+
+// maxShareTTL caps share links minted by the share endpoint.
+const maxShareTTL = 24 * time.Hour
+
+// Share is a verified share token: access to one existing session with a role
+// until ExpiresAt.
+type Share struct {
+	SessionID string
+	Role      string
+	ExpiresAt time.Time
+}
+
+// ReadOnly reports whether the share only allows watching the session.
+func (s *Share) ReadOnly() bool {
+	return s.Role != ShareRoleReadWrite
+}
+
+type shareClaims struct {
+	SessionID string `json:"sid"`
+	Role      string `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// NewShareToken mints a token granting role access to the session sessionID for
+// ttl, signed with secret (Config.ShareSecret of the server serving the session).
+// Opening the page with ?share=<token> attaches to the session without other
+// credentials.
+func NewShareToken(secret, sessionID, role string, ttl time.Duration) (string, error) {
+	if secret == "" {
+		return "", fmt.Errorf("share secret is required")
+	}
+	if sessionID == "" {
+		return "", fmt.Errorf("session id is required")
+	}
+	if role != ShareRoleReadOnly && role != ShareRoleReadWrite {
+		return "", fmt.Errorf("invalid share role %q, options: %s, %s", role, ShareRoleReadOnly, ShareRoleReadWrite)
+	}
+	if ttl <= 0 {
+		return "", fmt.Errorf("share ttl must be positive")
+	}
+
+	payload, err := json.Marshal(&shareClaims{
+		SessionID: sessionID,
+		Role:      role,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signShare(secret, encoded)), nil
+}
+
+// ParseShareToken verifies a token minted by NewShareToken with secret.
+func ParseShareToken(secret, token string) (*Share, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("share secret is required")
+	}
+
+	encoded, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed share token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, fmt.Errorf("malformed share token")
+	}
+	if subtle.ConstantTimeCompare(sig, signShare(secret, encoded)) != 1 {
+		return nil, fmt.Errorf("invalid share token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed share token")
+	}
+	var claims shareClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed share token: %s", err)
+	}
+	if claims.SessionID == "" || (claims.Role != ShareRoleReadOnly && claims.Role != ShareRoleReadWrite) {
+		return nil, fmt.Errorf("invalid share token claims")
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0)
+	if !time.Now().Before(expiresAt) {
+		return nil, fmt.Errorf("share token expired at %s", expiresAt.Format(time.RFC3339))
+	}
+
+	return &Share{
+		SessionID: claims.SessionID,
+		Role:      claims.Role,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func signShare(secret, encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("go-zoox/terminal share\x00"))
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
+
+// hasShareToken reports whether r carries a share token in its query.
+func hasShareToken(r *http.Request) bool {
+	return r.URL.Query().Get(shareQueryParam) != ""
+}
+
+// withShareIdentity attaches the identity of the share link in the request query
+// of ctx. It responds 403 and returns false when the link is invalid or expired;
+// requests without a link pass unchanged.
+func withShareIdentity(secret string, ctx *zoox.Context) bool {
+	token := ctx.Request.URL.Query().Get(shareQueryParam)
+	if token == "" {
+		return true
+	}
+
+	share, err := ParseShareToken(secret, token)
+	if err != nil {
+		logger.Warnf("[share] rejected share link from %s: %s", ctx.Request.RemoteAddr, err)
+		ctx.String(403, "share link is invalid or expired")
+		return false
+	}
+
+	ctx.Request = requestWithIdentity(ctx.Request, &Identity{
+		Subject: share.SessionID,
+		Method:  "share",
+		Share:   share,
+	})
+	return true
+}
+
+// shareMiddleware is withShareIdentity as middleware.
+func shareMiddleware(secret string) zoox.HandlerFunc {
+	return func(ctx *zoox.Context) {
+		if withShareIdentity(secret, ctx) {
+			ctx.Next()
+		}
+	}
+}
+
+// shareRequest is the body of the share endpoint.
+type shareRequest struct {
+	SessionID string `json:"session_id"`
+	Role      string `json:"role"`
+	// TTL is a duration like "30m" (default 1h, max 24h).
+	TTL string `json:"ttl"`
+}
+
+// shareHandler returns a handler minting share links (POST, JSON body
+// {"session_id": "...", "role": "ro"|"rw", "ttl": "30m"}). It answers with the
+// token and the page URL to hand out. Only the owner of a session or an admin
+// may share it, read-only users only read-only, and share links cannot mint
+// further links. pagePath is the
+// public path of the terminal page.
+func shareHandler(sessions *sessionRegistry, secret, pagePath string) zoox.HandlerFunc {
+	return func(ctx *zoox.Context) {
+		if id, ok := IdentityFromRequest(ctx.Request); ok && id.Share != nil {
+			ctx.JSON(403, zoox.H{"message": "share links cannot create share links"})
+			return
+		}
+
+		var req shareRequest
+		if err := json.NewDecoder(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, 4096)).Decode(&req); err != nil {
+			ctx.JSON(400, zoox.H{"message": fmt.Sprintf("invalid request body: %s", err)})
+			return
+		}
+		if req.Role == "" {
+			req.Role = ShareRoleReadOnly
+		}
+		if id, ok := IdentityFromRequest(ctx.Request); ok && id.ReadOnly() && req.Role == ShareRoleReadWrite {
+			ctx.JSON(403, zoox.H{"message": "read-only users cannot create read-write share links"})
+			return
+		}
+
+		ttl := time.Hour
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil {
+				ctx.JSON(400, zoox.H{"message": fmt.Sprintf("invalid ttl: %s", err)})
+				return
+			}
+			ttl = d
+		}
+		if ttl > maxShareTTL {
+			ctx.JSON(400, zoox.H{"message": fmt.Sprintf("ttl must not exceed %s", maxShareTTL)})
+			return
+		}
+
+		token, err := NewShareToken(secret, req.SessionID, req.Role, ttl)
+		if err != nil {
+			ctx.JSON(400, zoox.H{"message": err.Error()})
+			return
+		}
+
+		if sessions.entry(req.SessionID) == nil {
+			ctx.JSON(404, zoox.H{"message": "session not found"})
+			return
+		}
+		if identity, _ := IdentityFromRequest(ctx.Request); !sessions.Accessible(req.SessionID, identity) {
+			ctx.JSON(403, zoox.H{"message": "the session belongs to another user"})
+			return
+		}
+
+		if id, ok := IdentityFromRequest(ctx.Request); ok {
+			logger.Infof("[share] %s (%s) shared session %s (%s) for %s", id.Subject, id.Method, req.SessionID, req.Role, ttl)
+		} else {
+			logger.Infof("[share] session %s shared (%s) for %s", req.SessionID, req.Role, ttl)
+		}
+
+		ctx.JSON(200, zoox.H{
+			"token":      token,
+			"url":        pagePath + "?" + shareQueryParam + "=" + url.QueryEscape(token),
+			"expires_at": time.Now().Add(ttl).UTC().Format(time.RFC3339),
+		})
+	}
+}
+
+// randomShareSecret is the per-process share secret used when none is configured.
+func randomShareSecret() string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Errorf("terminal share: %w", err))
+	}
+	return hex.EncodeToString(key)
+}
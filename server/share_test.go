@@ -0,0 +1,254 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/command/terminal"
+	"github.com/go-zoox/zoox"
+)
+
+func TestShareToken(t *testing.T) {
+	t.Parallel()
+
+	token, err := NewShareToken("s3cret", "abc123", ShareRoleReadOnly, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	share, err := ParseShareToken("s3cret", token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if share.SessionID != "abc123" || !share.ReadOnly() || time.Until(share.ExpiresAt) > time.Minute {
+		t.Fatalf("share = %+v", share)
+	}
+
+	if _, err := ParseShareToken("other", token); err == nil {
+		t.Fatal("token accepted with another secret")
+	}
+
+	// a tampered payload (another session) breaks the signature
+	rw, _ := NewShareToken("s3cret", "xyz", ShareRoleReadWrite, time.Minute)
+	forged := strings.SplitN(rw, ".", 2)[0] + "." + strings.SplitN(token, ".", 2)[1]
+	if _, err := ParseShareToken("s3cret", forged); err == nil {
+		t.Fatal("forged token accepted")
+	}
+
+	expired, _ := NewShareToken("s3cret", "abc123", ShareRoleReadOnly, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, err := ParseShareToken("s3cret", expired); err == nil {
+		t.Fatal("expired token accepted")
+	}
+
+	if _, err := NewShareToken("s3cret", "abc123", "admin", time.Minute); err == nil {
+		t.Fatal("invalid role accepted")
+	}
+}
+
+func TestMiddleware_shareLinks(t *testing.T) {
+	t.Parallel()
+
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{
+		Config: &Config{
+			ShareSecret: "s3cret",
+			Driver:      "echo",
+			Drivers: map[string]Driver{
+				"echo": DriverFunc(func(cc *ConnectConfig) (terminal.Terminal, error) { return newEchoTerminal(), nil }),
+			},
+		},
+		Username: "admin",
+		Password: "admin",
+	}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	id := openSession(t, srv, "admin", "admin", "").Connect().SessionID
+
+	// minting requires the server credentials
+	mint := func(auth bool, body string) *http.Response {
+		req, _ := http.NewRequest("POST", srv.URL+"/share", strings.NewReader(body))
+		if auth {
+			req.SetBasicAuth("admin", "admin")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	if resp := mint(false, `{"session_id":"`+id+`"}`); resp.StatusCode != 401 {
+		t.Fatalf("anonymous mint: %d", resp.StatusCode)
+	}
+	if resp := mint(true, `{"session_id":"`+id+`","ttl":"48h"}`); resp.StatusCode != 400 {
+		t.Fatalf("mint beyond max ttl: %d", resp.StatusCode)
+	}
+	if resp := mint(true, `{"session_id":"abc123"}`); resp.StatusCode != 404 {
+		t.Fatalf("mint for unknown session: %d, want 404", resp.StatusCode)
+	}
+
+	resp := mint(true, `{"session_id":"`+id+`","role":"ro","ttl":"10m"}`)
+	var minted struct {
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&minted); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("mint: %d %v", resp.StatusCode, err)
+	}
+	if !strings.HasPrefix(minted.URL, "/?share=") {
+		t.Fatalf("url = %q", minted.URL)
+	}
+
+	// the link opens the page without credentials
+	page, err := http.Get(srv.URL + minted.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(page.Body)
+	page.Body.Close()
+	if page.StatusCode != 200 || !strings.Contains(string(body), `"shareSessionId":"`+id+`"`) || !strings.Contains(string(body), `"readOnly":true`) {
+		t.Fatalf("share page: %d", page.StatusCode)
+	}
+
+	bad, err := http.Get(srv.URL + "/?share=" + minted.Token + "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.Body.Close()
+	if bad.StatusCode != 403 {
+		t.Fatalf("invalid share link: %d, want 403", bad.StatusCode)
+	}
+
+	// a share link does not grant other routes
+	other, err := http.Get(srv.URL + "/hi?share=" + minted.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Body.Close()
+	if other.StatusCode != 401 {
+		t.Fatalf("share link on other route: %d, want 401", other.StatusCode)
+	}
+}
+
+func TestMiddleware_shareOnlyOwner(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	os.WriteFile(path, []byte("alice:"+bcryptHash(t, "pw")+"\n"+
+		"bob:"+bcryptHash(t, "pw")+"\n"+
+		"carol:"+bcryptHash(t, "pw")+":admin=true\n"+
+		"dave:"+bcryptHash(t, "pw")+":read_only=true\n"), 0600)
+
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{
+		HTPasswdFile: path,
+		Config: &Config{
+			ShareSecret: "s3cret",
+			Driver:      "echo",
+			Drivers: map[string]Driver{
+				"echo": DriverFunc(func(cc *ConnectConfig) (terminal.Terminal, error) { return newEchoTerminal(), nil }),
+			},
+		},
+	}))
+	srv := httptest.NewServer(app)
+	defer srv.Close()
+
+	share := func(user, sessionID, role string) int {
+		req, _ := http.NewRequest("POST", srv.URL+"/share", strings.NewReader(`{"session_id":"`+sessionID+`","role":"`+role+`"}`))
+		req.SetBasicAuth(user, "pw")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	id := openSession(t, srv, "alice", "pw", "").Connect().SessionID
+	for user, want := range map[string]int{"alice": 200, "bob": 403, "carol": 200} {
+		if code := share(user, id, "rw"); code != want {
+			t.Errorf("%s: share: %d, want %d", user, code, want)
+		}
+	}
+
+	// read-only users cannot hand out input to their sessions
+	id = openSession(t, srv, "dave", "pw", "").Connect().SessionID
+	if code := share("dave", id, "rw"); code != 403 {
+		t.Errorf("read-only rw share: %d, want 403", code)
+	}
+	if code := share("dave", id, "ro"); code != 200 {
+		t.Errorf("read-only ro share: %d, want 200", code)
+	}
+}
+
+// chanTerminal is a terminal whose output is fed by the test.
+type chanTerminal struct {
+	mockTerminal
+	out chan []byte
+}
+
+func (c *chanTerminal) Read(p []byte) (int, error) {
+	b, ok := <-c.out
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(p, b), nil
+}
+
+type syncBridgeConn struct {
+	mu     sync.Mutex
+	writes int
+}
+
+func (c *syncBridgeConn) WriteBinaryMessage(msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes++
+	return nil
+}
+
+func (c *syncBridgeConn) Close() error { return nil }
+
+func (c *syncBridgeConn) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes
+}
+
+func TestSessionRegistry_viewersReceiveOutput(t *testing.T) {
+	t.Parallel()
+
+	reg := newSessionRegistry(SessionRegistryConfig{TTL: time.Hour})
+	sess := &chanTerminal{out: make(chan []byte)}
+	owner, viewer := &syncBridgeConn{}, &syncBridgeConn{}
+
+	id := reg.Register(sess)
+	reg.AttachWriter(id, owner)
+	if !reg.AttachViewer(id, viewer) {
+		t.Fatal("AttachViewer failed")
+	}
+
+	sess.out <- []byte("hello")
+	time.Sleep(50 * time.Millisecond)
+	if owner.count() != 1 || viewer.count() != 1 {
+		t.Fatalf("writes: owner %d, viewer %d", owner.count(), viewer.count())
+	}
+
+	reg.DetachViewer(id, viewer)
+	sess.out <- []byte("again")
+	time.Sleep(50 * time.Millisecond)
+	if owner.count() != 2 || viewer.count() != 1 {
+		t.Fatalf("writes after detach: owner %d, viewer %d", owner.count(), viewer.count())
+	}
+	close(sess.out)
+}
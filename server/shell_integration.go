@@ -0,0 +1,69 @@
+package server
+
+import (
+	"path/filepath"
+
+	"github.com/go-zoox/logger"
+)
+
+// bashIntegration marks the prompt (OSC 133 A/B), the command start (C) with
+// the command line (OSC 633 E) and its end with the exit status (D) in an
+// interactive bash that read the user's ~/.bashrc first. PS0 runs in a
+// subshell: the command line is only reported when the command went into the
+// history, i.e. when HISTCMD moved since the prompt.
+const bashIntegration = `exec 3<&-
+[ -f ~/.bashrc ] && . ~/.bashrc
+__terminal_escape() {
+	local v=${1//\\/\\\\}
+	v=${v//;/\\x3b}
+	v=${v//$'\n'/\\x0a}
+	v=${v//$'\a'/\\x07}
+	v=${v//$'\e'/\\x1b}
+	printf '%s' "$v"
+}
+__terminal_precmd() {
+	__terminal_status=$?
+	printf '\e]133;D;%s\a\e]633;P;Cwd=%s\a' "$__terminal_status" "$(__terminal_escape "$PWD")"
+	__terminal_histcmd=$HISTCMD
+	return $__terminal_status
+}
+__terminal_prompt() {
+	case "$PS1" in
+	*'133;B'*) ;;
+	*) PS1="\[\e]133;A\a\]$PS1\[\e]133;B\a\]" ;;
+	esac
+	return $__terminal_status
+}
+__terminal_preexec() {
+	if [ "$HISTCMD" != "$__terminal_histcmd" ]; then
+		local line
+		line=$(HISTTIMEFORMAT= builtin history 1)
+		printf '\e]633;E;%s\a' "$(__terminal_escape "${line#*[0-9][ *] }")"
+	fi
+	printf '\e]133;C\a'
+}
+PROMPT_COMMAND=$'__terminal_precmd\n'"$PROMPT_COMMAND"$'\n__terminal_prompt'
+PS0='$(__terminal_preexec)'"$PS0"
+`
+
+// shellIntegrationHeredoc ends the integration script in the init command.
+const shellIntegrationHeredoc = "__TERMINAL_SHELL_INTEGRATION__"
+
+// withShellIntegration returns cc starting an interactive shell with the shell
+// integration of Config.ShellIntegration, or cc itself when it does not apply:
+// the session runs a command, or the shell is not bash.
+func withShellIntegration(cc *ConnectConfig) *ConnectConfig {
+	if cc.InitCommand != "" || (cc.Driver != "host" && cc.Driver != "docker") {
+		return cc
+	}
+	if filepath.Base(cc.Shell) != "bash" {
+		logger.Debugf("no shell integration for shell %q", cc.Shell)
+		return cc
+	}
+
+	// the shell reads the script as its rcfile from a here-document on fd 3
+	c := *cc
+	c.InitCommand = "exec " + shellQuote(cc.Shell) + " --rcfile /dev/fd/3 -i 3<<'" + shellIntegrationHeredoc + "'\n" +
+		bashIntegration + shellIntegrationHeredoc + "\n"
+	return &c
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShellIntegration_bash(t *testing.T) {
+	t.Parallel()
+
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash not found")
+	}
+
+	// the user's PROMPT_COMMAND and PS1 stay in place
+	home := t.TempDir()
+	os.WriteFile(filepath.Join(home, ".bashrc"), []byte("PROMPT_COMMAND='history -a;'\nPS1='\\$ '\nHISTCONTROL=ignorespace\n"), 0644)
+
+	session, err := connect(&Config{}, withShellIntegration(&ConnectConfig{
+		Driver:      "host",
+		Shell:       bash,
+		WorkDir:     home,
+		Environment: map[string]string{"HOME": home},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	tracker := newCommandTracker(nil, nil)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := session.Read(buf)
+			if err != nil {
+				return
+			}
+			tracker.Feed(buf[:n])
+		}
+	}()
+
+	waitFor := func(n int) []ShellCommand {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			commands := tracker.Commands()
+			if len(commands) == n && commands[n-1].FinishedAt != nil {
+				return commands
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("commands = %+v, want %d", commands, n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// one line at a time, each after the prompt
+	time.Sleep(200 * time.Millisecond)
+	n := 0
+	for _, line := range []string{"cd /; (exit 3)\r", "\r", " echo x\x7fok\r", "sleep 0.2\r"} {
+		session.Write([]byte(line))
+		if line != "\r" {
+			n++
+			waitFor(n)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	session.Write([]byte("exit\r"))
+	session.Wait()
+
+	commands := tracker.Commands()
+	if len(commands) < 3 {
+		t.Fatalf("commands = %+v", commands)
+	}
+	for i, want := range []struct {
+		command, cwd string
+		code         int
+	}{
+		{"cd /; (exit 3)", home, 3},
+		// not in the history: the echoed input
+		{"echo ok", "/", 0},
+		{"sleep 0.2", "/", 0},
+	} {
+		c := commands[i]
+		if c.Command != want.command || c.Cwd != want.cwd || c.ExitCode == nil || *c.ExitCode != want.code {
+			t.Errorf("command %d = %+v, exit code %v, want %+v", i, c, c.ExitCode, want)
+		}
+	}
+	if commands[2].DurationMS < 200 {
+		t.Errorf("sleep 0.2 took %dms", commands[2].DurationMS)
+	}
+}
+
+func TestWithShellIntegration(t *testing.T) {
+	t.Parallel()
+
+	for _, cc := range []*ConnectConfig{
+		{Driver: "host", Shell: "/bin/bash", InitCommand: "make"},
+		{Driver: "host", Shell: "/bin/sh"},
+		{Driver: "ssh", Shell: "/bin/bash"},
+	} {
+		if withShellIntegration(cc) != cc {
+			t.Errorf("%+v: shell integration applied", cc)
+		}
+	}
+
+	cc := &ConnectConfig{Driver: "docker", Shell: "/bin/bash"}
+	if c := withShellIntegration(cc); c == cc || c.InitCommand == "" || cc.InitCommand != "" {
+		t.Errorf("docker bash: %+v", c)
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/command/errors"
+	"github.com/go-zoox/command/terminal"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshDialTimeout bounds the TCP connect and handshake with each SSH hop.
+const sshDialTimeout = 10 * time.Second
+
+// applySSHConfig completes the SSH target of a connect request with the server
+// defaults. Clients may pick another host only when it matches
+// Config.SSHAllowedHosts; known_hosts, host key checking and the jump host are
+// server settings. Credentials the client sends replace the server's, which
+// only log in the default user on the default host.
+func applySSHConfig(cfg *Config, cc *ConnectConfig) error {
+	defaultPort := cfg.SSHPort
+	if defaultPort == 0 {
+		defaultPort = 22
+	}
+
+	chosen := cc.SSHHost != "" || cc.SSHPort != 0
+	if cc.SSHHost == "" {
+		cc.SSHHost = cfg.SSHHost
+	}
+	if cc.SSHPort == 0 {
+		cc.SSHPort = defaultPort
+	}
+	if cc.SSHHost == "" {
+		return fmt.Errorf("ssh host is required")
+	}
+	isDefault := cc.SSHHost == cfg.SSHHost && cc.SSHPort == defaultPort
+	if chosen && !isDefault && !sshHostAllowed(cfg.SSHAllowedHosts, cc.SSHHost, cc.SSHPort) {
+		return fmt.Errorf("ssh host %s is not allowed", net.JoinHostPort(cc.SSHHost, strconv.Itoa(cc.SSHPort)))
+	}
+
+	if cc.SSHUser == "" {
+		cc.SSHUser = cfg.SSHUser
+	}
+	if cc.SSHUser == "" {
+		cc.SSHUser = cc.User
+	}
+
+	if cc.SSHPassword == "" && cc.SSHPrivateKey == "" {
+		defaultUser := cfg.SSHUser
+		if defaultUser == "" {
+			defaultUser = cfg.User
+		}
+		if !isDefault || cc.SSHUser != defaultUser {
+			return fmt.Errorf("ssh credentials are required for %s", cc.sshTarget())
+		}
+
+		cc.SSHPassword = cfg.SSHPassword
+		if cfg.SSHPrivateKeyFile != "" {
+			key, err := os.ReadFile(cfg.SSHPrivateKeyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read ssh private key: %s", err)
+			}
+			cc.SSHPrivateKey = string(key)
+			cc.SSHPrivateKeyPassphrase = cfg.SSHPrivateKeyPassphrase
+		}
+	}
+
+	cc.SSHKnownHostsFile = cfg.SSHKnownHostsFile
+	cc.SSHInsecureIgnoreHostKey = cfg.SSHInsecureIgnoreHostKey
+	cc.SSHJumpHost = cfg.SSHJumpHost
+	return nil
+}
+
+// sshHostAllowed reports whether host:port matches one of the patterns: a host
+// or host:port, with path.Match wildcards, e.g. *.internal or 10.0.0.*:2222. A
+// pattern without a port allows any port.
+func sshHostAllowed(patterns []string, host string, port int) bool {
+	for _, pattern := range patterns {
+		pHost, pPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			pHost, pPort = pattern, ""
+		}
+		if pPort != "" && pPort != strconv.Itoa(port) {
+			continue
+		}
+		if ok, _ := path.Match(pHost, host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sshTarget returns user@host:port of the connect request.
+func (cc *ConnectConfig) sshTarget() string {
+	return cc.SSHUser + "@" + net.JoinHostPort(cc.SSHHost, strconv.Itoa(cc.SSHPort))
+}
+
+// connectSSH opens a remote login shell (or InitCommand) with a PTY on the
+// target of cc, through the jump host when one is set.
+func connectSSH(cc *ConnectConfig) (terminal.Terminal, error) {
+	auth, err := sshAuthMethods(cc.SSHPassword, cc.SSHPrivateKey, cc.SSHPrivateKeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := sshHostKeyCallback(cc.SSHKnownHostsFile, cc.SSHInsecureIgnoreHostKey)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig := func(user string) *ssh.ClientConfig {
+		return &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         sshDialTimeout,
+		}
+	}
+
+	t := &sshTerminal{readOnly: cc.ReadOnly}
+	addr := net.JoinHostPort(cc.SSHHost, strconv.Itoa(cc.SSHPort))
+
+	var client *ssh.Client
+	if cc.SSHJumpHost != "" {
+		jumpUser, jumpAddr := parseSSHJumpHost(cc.SSHJumpHost, cc.SSHUser)
+		jump, err := ssh.Dial("tcp", jumpAddr, clientConfig(jumpUser))
+		if err != nil {
+			return nil, fmt.Errorf("ssh jump host %s: %s", jumpAddr, err)
+		}
+		t.clients = append(t.clients, jump)
+
+		conn, err := jump.Dial("tcp", addr)
+		if err != nil {
+			t.closeClients()
+			return nil, fmt.Errorf("ssh %s via %s: %s", addr, jumpAddr, err)
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig(cc.SSHUser))
+		if err != nil {
+			conn.Close()
+			t.closeClients()
+			return nil, fmt.Errorf("ssh %s via %s: %s", addr, jumpAddr, err)
+		}
+		client = ssh.NewClient(c, chans, reqs)
+	} else {
+		if client, err = ssh.Dial("tcp", addr, clientConfig(cc.SSHUser)); err != nil {
+			return nil, fmt.Errorf("ssh %s: %s", addr, err)
+		}
+	}
+	t.clients = append(t.clients, client)
+
+	if err := t.start(cc); err != nil {
+		t.closeClients()
+		return nil, err
+	}
+	return t, nil
+}
+
+// parseSSHJumpHost splits [user@]host[:port], defaulting to user and port 22.
+func parseSSHJumpHost(jump, user string) (string, string) {
+	if u, host, ok := strings.Cut(jump, "@"); ok {
+		user, jump = u, host
+	}
+	if _, _, err := net.SplitHostPort(jump); err != nil {
+		jump = net.JoinHostPort(jump, "22")
+	}
+	return user, jump
+}
+
+func sshAuthMethods(password, privateKey, passphrase string) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if privateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(privateKey), []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(privateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh private key: %s", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		methods = append(methods, ssh.Password(password), ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = password
+			}
+			return answers, nil
+		}))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("ssh password or private key is required")
+	}
+	return methods, nil
+}
+
+// sshHostKeyCallback verifies host keys against knownHostsFile, by default
+// ~/.ssh/known_hosts. Unknown hosts are rejected unless insecure.
+func sshHostKeyCallback(knownHostsFile string, insecure bool) (ssh.HostKeyCallback, error) {
+	if insecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("ssh known_hosts file is required: %s", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ssh known_hosts: %s", err)
+	}
+	return callback, nil
+}
+
+// sshTerminal is a terminal.Terminal on a remote PTY.
+type sshTerminal struct {
+	clients  []*ssh.Client // jump host first, target last
+	session  *ssh.Session
+	stdin    io.WriteCloser
+	stdout   io.Reader
+	readOnly bool
+
+	waitOnce sync.Once
+	waitErr  error
+	exitCode int
+}
+
+func (t *sshTerminal) start(cc *ConnectConfig) error {
+	client := t.clients[len(t.clients)-1]
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("ssh session: %s", err)
+	}
+	t.session = session
+
+	for k, v := range cc.Environment {
+		// servers only accept the variables listed in their AcceptEnv
+		session.Setenv(k, v)
+	}
+
+	if t.stdin, err = session.StdinPipe(); err != nil {
+		return err
+	}
+	if t.stdout, err = session.StdoutPipe(); err != nil {
+		return err
+	}
+
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm-256color", 24, 80, modes); err != nil {
+		return fmt.Errorf("ssh pty: %s", err)
+	}
+
+	command := cc.InitCommand
+	if cc.SSHWorkDir != "" {
+		if command == "" {
+			command = `exec "${SHELL:-/bin/sh}" -l`
+		}
+		command = "cd " + shellQuote(cc.SSHWorkDir) + " && " + command
+	}
+	if command == "" {
+		err = session.Shell()
+	} else {
+		err = session.Start(command)
+	}
+	if err != nil {
+		return fmt.Errorf("ssh start: %s", err)
+	}
+	return nil
+}
+
+func (t *sshTerminal) Read(p []byte) (int, error) {
+	return t.stdout.Read(p)
+}
+
+func (t *sshTerminal) Write(p []byte) (int, error) {
+	if t.readOnly {
+		return len(p), nil
+	}
+	return t.stdin.Write(p)
+}
+
+func (t *sshTerminal) Resize(rows, cols int) error {
+	return t.session.WindowChange(rows, cols)
+}
+
+func (t *sshTerminal) Close() error {
+	t.session.Close()
+	t.closeClients()
+	return nil
+}
+
+func (t *sshTerminal) closeClients() {
+	for i := len(t.clients) - 1; i >= 0; i-- {
+		t.clients[i].Close()
+	}
+}
+
+// Wait waits for the remote command, reporting a non-zero exit status as
+// *errors.ExitError like the other drivers.
+func (t *sshTerminal) Wait() error {
+	t.waitOnce.Do(func() {
+		err := t.session.Wait()
+		switch e := err.(type) {
+		case nil:
+		case *ssh.ExitError:
+			t.exitCode = e.ExitStatus()
+			t.waitErr = &errors.ExitError{Code: e.ExitStatus(), Message: e.Error()}
+		case *ssh.ExitMissingError:
+			t.exitCode = -1
+			t.waitErr = &errors.ExitError{Code: -1, Message: e.Error()}
+		default:
+			t.exitCode = -1
+			t.waitErr = err
+		}
+	})
+	return t.waitErr
+}
+
+func (t *sshTerminal) ExitCode() int {
+	return t.exitCode
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
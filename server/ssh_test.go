@@ -0,0 +1,357 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/command/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testSSHServer is an in-process SSH server: exec requests print the command and
+// exit with status 3, shells echo their input; direct-tcpip channels are
+// forwarded, so it can serve as jump host.
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.Signer
+
+	mu    sync.Mutex
+	sizes [][2]uint32
+}
+
+func newTestSSHServer(t *testing.T, user, password string) *testSSHServer {
+	t.Helper()
+
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == user && string(pass) == password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("access denied")
+		},
+	}
+	cfg.AddHostKey(hostKey)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &testSSHServer{addr: ln.Addr().String(), hostKey: hostKey}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, cfg)
+		}
+	}()
+	return s
+}
+
+func (s *testSSHServer) serve(conn net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				continue
+			}
+			go s.session(ch, reqs)
+		case "direct-tcpip":
+			var target struct {
+				Host     string
+				Port     uint32
+				OrigHost string
+				OrigPort uint32
+			}
+			ssh.Unmarshal(nc.ExtraData(), &target)
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+			if err != nil {
+				nc.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			go ssh.DiscardRequests(reqs)
+			go func() {
+				io.Copy(ch, upstream)
+				ch.Close()
+			}()
+			go func() {
+				io.Copy(upstream, ch)
+				upstream.Close()
+			}()
+		default:
+			nc.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *testSSHServer) session(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case "pty-req", "env":
+			req.Reply(true, nil)
+		case "window-change":
+			s.mu.Lock()
+			s.sizes = append(s.sizes, [2]uint32{binary.BigEndian.Uint32(req.Payload[4:]), binary.BigEndian.Uint32(req.Payload)})
+			s.mu.Unlock()
+		case "exec":
+			var payload struct{ Command string }
+			ssh.Unmarshal(req.Payload, &payload)
+			req.Reply(true, nil)
+
+			fmt.Fprintf(ch, "ran: %s\r\n", payload.Command)
+			ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{3}))
+			ch.Close()
+			return
+		case "shell":
+			req.Reply(true, nil)
+			go func() {
+				io.Copy(ch, ch)
+				ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				ch.Close()
+			}()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func writeKnownHosts(t *testing.T, servers ...*testSSHServer) string {
+	t.Helper()
+
+	var lines []string
+	for _, s := range servers {
+		lines = append(lines, knownhosts.Line([]string{s.addr}, s.hostKey.PublicKey()))
+	}
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func splitTestAddr(t *testing.T, addr string) (string, int) {
+	t.Helper()
+
+	host, port, _ := net.SplitHostPort(addr)
+	var p int
+	fmt.Sscan(port, &p)
+	return host, p
+}
+
+func TestConnectSSH_exec(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestSSHServer(t, "alice", "s3cret")
+	host, port := splitTestAddr(t, srv.addr)
+
+	session, err := connectSSH(&ConnectConfig{
+		InitCommand:       "uptime",
+		SSHWorkDir:        "/srv/it's",
+		SSHHost:           host,
+		SSHPort:           port,
+		SSHUser:           "alice",
+		SSHPassword:       "s3cret",
+		SSHKnownHostsFile: writeKnownHosts(t, srv),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	out, _ := io.ReadAll(session)
+	if want := `ran: cd '/srv/it'\''s' && uptime`; !strings.Contains(string(out), want) {
+		t.Fatalf("output = %q, want %q", out, want)
+	}
+
+	err = session.Wait()
+	if exitErr, ok := err.(*errors.ExitError); !ok || exitErr.ExitCode() != 3 || session.ExitCode() != 3 {
+		t.Fatalf("Wait() = %v, ExitCode() = %d", err, session.ExitCode())
+	}
+}
+
+func TestConnectSSH_shellThroughJumpHost(t *testing.T) {
+	t.Parallel()
+
+	target := newTestSSHServer(t, "alice", "s3cret")
+	jump := newTestSSHServer(t, "alice", "s3cret")
+	host, port := splitTestAddr(t, target.addr)
+
+	session, err := connectSSH(&ConnectConfig{
+		SSHHost:           host,
+		SSHPort:           port,
+		SSHUser:           "alice",
+		SSHPassword:       "s3cret",
+		SSHKnownHostsFile: writeKnownHosts(t, target, jump),
+		SSHJumpHost:       jump.addr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if _, err := session.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(session, buf)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil || string(buf) != "hello" {
+			t.Fatalf("read %q: %v", buf, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no echo from the remote shell")
+	}
+
+	if err := session.Resize(40, 120); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		target.mu.Lock()
+		sizes := target.sizes
+		target.mu.Unlock()
+		if len(sizes) == 1 && sizes[0] == [2]uint32{40, 120} {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("window changes = %v", sizes)
+		}
+	}
+}
+
+func TestConnectSSH_rejectsUnknownHostKey(t *testing.T) {
+	t.Parallel()
+
+	srv := newTestSSHServer(t, "alice", "s3cret")
+	other := newTestSSHServer(t, "alice", "s3cret")
+	host, port := splitTestAddr(t, srv.addr)
+
+	// known_hosts lists another key for the address
+	line := knownhosts.Line([]string{srv.addr}, other.hostKey.PublicKey())
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	os.WriteFile(path, []byte(line+"\n"), 0600)
+
+	_, err := connectSSH(&ConnectConfig{
+		SSHHost:           host,
+		SSHPort:           port,
+		SSHUser:           "alice",
+		SSHPassword:       "s3cret",
+		SSHKnownHostsFile: path,
+	})
+	if err == nil || !strings.Contains(err.Error(), "key mismatch") {
+		t.Fatalf("connect with mismatched host key: %v", err)
+	}
+
+	if _, err := connectSSH(&ConnectConfig{SSHHost: host, SSHPort: port, SSHUser: "alice", SSHPassword: "wrong", SSHKnownHostsFile: writeKnownHosts(t, srv)}); err == nil {
+		t.Fatal("wrong password accepted")
+	}
+}
+
+func TestApplySSHConfig(t *testing.T) {
+	t.Parallel()
+
+	keyFile := filepath.Join(t.TempDir(), "id")
+	os.WriteFile(keyFile, []byte("KEY"), 0600)
+	cfg := &Config{
+		User:              "ops",
+		SSHHost:           "bastion.internal",
+		SSHPrivateKeyFile: keyFile,
+		SSHKnownHostsFile: "/etc/ssh/known_hosts",
+		SSHJumpHost:       "jump@gw.example.com",
+		SSHAllowedHosts:   []string{"*.db.internal", "10.0.0.5:2222"},
+	}
+
+	cc := &ConnectConfig{User: "ops"}
+	if err := applySSHConfig(cfg, cc); err != nil {
+		t.Fatal(err)
+	}
+	if cc.sshTarget() != "ops@bastion.internal:22" || cc.SSHPrivateKey != "KEY" || cc.SSHKnownHostsFile != "/etc/ssh/known_hosts" || cc.SSHJumpHost != "jump@gw.example.com" {
+		t.Fatalf("defaults = %+v", cc)
+	}
+
+	// the client's own credentials replace the server key
+	cc = &ConnectConfig{SSHHost: "pg1.db.internal", SSHUser: "dba", SSHPassword: "pw"}
+	if err := applySSHConfig(cfg, cc); err != nil {
+		t.Fatal(err)
+	}
+	if cc.sshTarget() != "dba@pg1.db.internal:22" || cc.SSHPrivateKey != "" || cc.SSHPassword != "pw" {
+		t.Fatalf("override = %+v", cc)
+	}
+
+	for _, denied := range []*ConnectConfig{
+		{SSHHost: "evil.example.com"},
+		{SSHHost: "10.0.0.5"},
+		{SSHPort: 2200},
+	} {
+		if err := applySSHConfig(cfg, denied); err == nil {
+			t.Errorf("%s allowed", denied.sshTarget())
+		}
+	}
+	if err := applySSHConfig(cfg, &ConnectConfig{SSHHost: "10.0.0.5", SSHPort: 2222, SSHPassword: "pw"}); err != nil {
+		t.Error(err)
+	}
+
+	// the server credentials only log in the default user on the default host
+	for _, other := range []*ConnectConfig{
+		{SSHHost: "pg1.db.internal", User: "ops"},
+		{SSHUser: "root"},
+		{User: "root"},
+	} {
+		if err := applySSHConfig(cfg, other); err == nil || other.SSHPrivateKey != "" {
+			t.Errorf("server credentials used for %s", other.sshTarget())
+		}
+	}
+	if err := applySSHConfig(&Config{}, &ConnectConfig{}); err == nil {
+		t.Error("no ssh host accepted")
+	}
+}
+
+func TestParseSSHJumpHost(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct{ in, user, addr string }{
+		{"gw.example.com", "alice", "gw.example.com:22"},
+		{"jump@gw.example.com:2222", "jump", "gw.example.com:2222"},
+	} {
+		if user, addr := parseSSHJumpHost(tc.in, "alice"); user != tc.user || addr != tc.addr {
+			t.Errorf("parseSSHJumpHost(%q) = %s, %s", tc.in, user, addr)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/logger"
+)
+
+// tlsReloader serves the certificate and client CA pool from files, re-reading
+// them on the next handshake after they change, so renewed certificates (e.g. by
+// certbot or cert-manager) are picked up without a restart. When a reload fails
+// the previous certificate stays in use.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	caPool   *x509.CertPool
+	caTime   time.Time
+}
+
+// newTLSConfig returns a server TLS config for certFile / keyFile that requires
+// and verifies client certificates against clientCAFile when it is set.
+func newTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("tls requires both a certificate and a key file")
+	}
+
+	r := &tlsReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if _, err := r.certificate(); err != nil {
+		return nil, err
+	}
+	if clientCAFile != "" {
+		if _, err := r.clientCAs(); err != nil {
+			return nil, err
+		}
+	}
+
+	// gorilla/websocket cannot upgrade HTTP/2 streams, keep browsers on HTTP/1.1
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.certificate()
+		},
+	}
+	if clientCAFile == "" {
+		return base, nil
+	}
+
+	base.ClientAuth = tls.RequireAndVerifyClientCert
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		pool, err := r.clientCAs()
+		if err != nil {
+			return nil, err
+		}
+
+		c := base.Clone()
+		c.ClientCAs = pool
+		c.GetConfigForClient = nil
+		return c, nil
+	}
+	return base, nil
+}
+
+func (r *tlsReloader) certificate() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			logger.Errorf("[tls] %s", err)
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	if r.cert != nil && modTime.Equal(r.certTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// e.g. the certificate was written but the key not yet
+			logger.Errorf("[tls] failed to reload certificate: %s", err)
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load tls certificate: %s", err)
+	}
+
+	if r.cert != nil {
+		logger.Infof("[tls] reloaded certificate %s", r.certFile)
+	}
+	r.cert = &cert
+	r.certTime = modTime
+	return r.cert, nil
+}
+
+func (r *tlsReloader) clientCAs() (*x509.CertPool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := latestModTime(r.clientCAFile)
+	if err != nil {
+		if r.caPool != nil {
+			logger.Errorf("[tls] %s", err)
+			return r.caPool, nil
+		}
+		return nil, err
+	}
+	if r.caPool != nil && modTime.Equal(r.caTime) {
+		return r.caPool, nil
+	}
+
+	pem, err := os.ReadFile(r.clientCAFile)
+	if err != nil {
+		if r.caPool != nil {
+			logger.Errorf("[tls] failed to reload client CAs: %s", err)
+			return r.caPool, nil
+		}
+		return nil, fmt.Errorf("failed to read tls client ca file: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		if r.caPool != nil {
+			logger.Errorf("[tls] no certificate found in %s, keeping the previous client CAs", r.clientCAFile)
+			return r.caPool, nil
+		}
+		return nil, fmt.Errorf("no certificate found in tls client ca file %s", r.clientCAFile)
+	}
+
+	if r.caPool != nil {
+		logger.Infof("[tls] reloaded client CAs %s", r.clientCAFile)
+	}
+	r.caPool = pool
+	r.caTime = modTime
+	return pool, nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to read %s: %s", file, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	pem    []byte
+	keyPEM []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:   cert,
+		key:    key,
+		pem:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	cert, err := tls.X509KeyPair(c.pem, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeTestFile(t *testing.T, path string, data []byte, mtime time.Time) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, mtime, mtime)
+}
+
+func TestTLSConfig_mutualTLSAndReload(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", nil, true)
+	serverCert := newTestCert(t, "server-1", ca, false)
+	clientCert := newTestCert(t, "alice", ca, false)
+
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server-key.pem")
+	caFile := filepath.Join(dir, "ca.pem")
+	now := time.Now()
+	writeTestFile(t, certFile, serverCert.pem, now)
+	writeTestFile(t, keyFile, serverCert.keyPEM, now)
+	writeTestFile(t, caFile, ca.pem, now)
+
+	tlsConfig, err := newTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromRequest(r)
+		if !ok {
+			w.WriteHeader(401)
+			return
+		}
+		io.WriteString(w, id.Method+":"+id.Subject)
+	}))
+	srv.TLS = tlsConfig
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (string, string, error) {
+		c := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+		}}
+		resp, err := c.Get(srv.URL)
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	body, serverCN, err := get(clientCert.tlsCertificate(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "mtls:alice" || serverCN != "server-1" {
+		t.Fatalf("got %q from %s", body, serverCN)
+	}
+
+	if _, _, err := get(); err == nil {
+		t.Fatal("expected handshake without client certificate to fail")
+	}
+
+	// a renewed certificate is served on the next handshake
+	renewed := newTestCert(t, "server-2", ca, false)
+	writeTestFile(t, certFile, renewed.pem, now.Add(time.Second))
+	writeTestFile(t, keyFile, renewed.keyPEM, now.Add(time.Second))
+
+	if _, serverCN, err = get(clientCert.tlsCertificate(t)); err != nil {
+		t.Fatal(err)
+	}
+	if serverCN != "server-2" {
+		t.Fatalf("served %s after reload, want server-2", serverCN)
+	}
+
+	// a half-written renewal keeps the previous certificate
+	writeTestFile(t, keyFile, []byte("garbage"), now.Add(2*time.Second))
+	if _, serverCN, err = get(clientCert.tlsCertificate(t)); err != nil || serverCN != "server-2" {
+		t.Fatalf("served %s (%v) after broken reload, want server-2", serverCN, err)
+	}
+}
+
+func TestNewTLSConfig_requiresKeyPair(t *testing.T) {
+	t.Parallel()
+
+	if _, err := newTLSConfig("cert.pem", "", ""); err == nil {
+		t.Fatal("expected error without key file")
+	}
+}
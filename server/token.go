@@ -0,0 +1,369 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zoox/logger"
+	"github.com/go-zoox/zoox"
+)
+
+// TokenAuthConfig configures TokenAuth. At least one of Secret and JWKSFile must
+// be set; tokens are accepted from the Authorization header (Bearer), the cookie
+// named CookieName or the token query parameter (for browsers, which cannot set
+// headers on WebSocket requests). Tokens must carry an exp claim.
+type TokenAuthConfig struct {
+	// Secret verifies HS256 tokens.
+	Secret string
+	// JWKSFile is a local JSON Web Key Set verifying RS256 (RSA keys) and ES256
+	// (P-256 keys) tokens. It is re-read when the file changes, for key rotation.
+	JWKSFile string
+
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration
+
+	// CookieName is the cookie carrying the token (default "token").
+	CookieName string
+
+	Skip func(ctx *zoox.Context) bool
+}
+
+// TokenAuth returns middleware that requires a valid JWT and exposes its claims as
+// the request Identity. Failed auth returns 401.
+func TokenAuth(cfg TokenAuthConfig) zoox.HandlerFunc {
+	verifier := newTokenVerifier(&cfg)
+
+	return func(ctx *zoox.Context) {
+		if cfg.Skip != nil && cfg.Skip(ctx) {
+			ctx.Next()
+			return
+		}
+
+		id, err := verifier.Authenticate(ctx.Request)
+		if err != nil {
+			logger.Debugf("[token auth] %s", err)
+			ctx.Set("WWW-Authenticate", `Bearer realm="go-zoox"`)
+			ctx.Status(401)
+			return
+		}
+
+		ctx.Request = requestWithIdentity(ctx.Request, id)
+		ctx.Next()
+	}
+}
+
+var errNoToken = fmt.Errorf("no token")
+
+type tokenVerifier struct {
+	cfg *TokenAuthConfig
+
+	mu       sync.Mutex
+	keys     []*jwk
+	keysTime time.Time
+}
+
+func newTokenVerifier(cfg *TokenAuthConfig) *tokenVerifier {
+	if cfg.Secret == "" && cfg.JWKSFile == "" {
+		panic("terminal TokenAuth: Secret or JWKSFile is required")
+	}
+
+	return &tokenVerifier{cfg: cfg}
+}
+
+// Authenticate verifies the token of r. It returns errNoToken when r carries none.
+func (v *tokenVerifier) Authenticate(r *http.Request) (*Identity, error) {
+	token := tokenFromRequest(r, v.cfg.CookieName)
+	if token == "" {
+		return nil, errNoToken
+	}
+
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	return &Identity{
+		Subject: subject,
+		Method:  "jwt",
+		Claims:  claims,
+	}, nil
+}
+
+// tokenFromRequest returns the bearer token, token cookie or token query parameter.
+func tokenFromRequest(r *http.Request, cookieName string) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+
+	if cookieName == "" {
+		cookieName = "token"
+	}
+	if cookie, err := r.Cookie(cookieName); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+// Verify checks the signature and the registered claims of a compact JWT and
+// returns its claims.
+func (v *tokenVerifier) Verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %s", err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature encoding: %s", err)
+	}
+
+	switch header.Alg {
+	case "HS256":
+		if v.cfg.Secret == "" {
+			return nil, fmt.Errorf("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, []byte(v.cfg.Secret))
+		mac.Write(signed)
+		if subtle.ConstantTimeCompare(mac.Sum(nil), signature) != 1 {
+			return nil, fmt.Errorf("invalid token signature")
+		}
+	case "RS256", "ES256":
+		if err := v.verifyWithJWKS(header.Alg, header.Kid, signed, signature); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %s", err)
+	}
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *tokenVerifier) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.cfg.Leeway)) {
+		return fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(v.cfg.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return fmt.Errorf("token not valid yet")
+		}
+	}
+
+	if v.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.cfg.Issuer {
+			return fmt.Errorf("unexpected token issuer %q", iss)
+		}
+	}
+
+	if v.cfg.Audience != "" {
+		matched := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			matched = aud == v.cfg.Audience
+		case []interface{}:
+			for _, a := range aud {
+				if a == v.cfg.Audience {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return fmt.Errorf("token audience does not match")
+		}
+	}
+
+	return nil
+}
+
+func (v *tokenVerifier) verifyWithJWKS(alg, kid string, signed, signature []byte) error {
+	if v.cfg.JWKSFile == "" {
+		return fmt.Errorf("%s tokens are not accepted", alg)
+	}
+
+	keys, err := v.loadKeys()
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(signed)
+	for _, key := range keys {
+		if kid != "" && key.Kid != kid {
+			continue
+		}
+		if key.Alg != "" && key.Alg != alg {
+			continue
+		}
+
+		switch pub := key.publicKey.(type) {
+		case *rsa.PublicKey:
+			if alg == "RS256" && rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			// JWS encodes an ES256 signature as r || s, 32 bytes each
+			if alg == "ES256" && len(signature) == 64 {
+				r := new(big.Int).SetBytes(signature[:32])
+				s := new(big.Int).SetBytes(signature[32:])
+				if ecdsa.Verify(pub, digest[:], r, s) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("invalid token signature (kid %q)", kid)
+}
+
+// jwk is one key of a JSON Web Key Set.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+
+	publicKey crypto.PublicKey
+}
+
+// loadKeys returns the JWKS keys, re-reading the file when its mtime changed.
+func (v *tokenVerifier) loadKeys() ([]*jwk, error) {
+	info, err := os.Stat(v.cfg.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwks file: %s", err)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.keys != nil && info.ModTime().Equal(v.keysTime) {
+		return v.keys, nil
+	}
+
+	data, err := os.ReadFile(v.cfg.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwks file: %s", err)
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid jwks file %s: %s", v.cfg.JWKSFile, err)
+	}
+
+	v.keys = keys
+	v.keysTime = info.ModTime()
+	return keys, nil
+}
+
+func parseJWKS(data []byte) ([]*jwk, error) {
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*jwk, 0, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+
+		switch key.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(key.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid n: %s", key.Kid, err)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(key.E)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid e: %s", key.Kid, err)
+			}
+			key.publicKey = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "EC":
+			if key.Crv != "P-256" {
+				continue
+			}
+			x, err := base64.RawURLEncoding.DecodeString(key.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid x: %s", key.Kid, err)
+			}
+			y, err := base64.RawURLEncoding.DecodeString(key.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid y: %s", key.Kid, err)
+			}
+			if len(x) != 32 || len(y) != 32 {
+				return nil, fmt.Errorf("key %q: invalid P-256 coordinates", key.Kid)
+			}
+			pub, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %s", key.Kid, err)
+			}
+			key.publicKey = pub
+		default:
+			continue
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-zoox/zoox"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signToken(t *testing.T, header, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	t.Helper()
+
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := b64(h) + "." + b64(c)
+	return signed + "." + b64(sign([]byte(signed)))
+}
+
+func hs256(secret string) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func TestTokenVerifier_HS256(t *testing.T) {
+	t.Parallel()
+
+	v := newTokenVerifier(&TokenAuthConfig{Secret: "s3cret", Issuer: "idp", Audience: "terminal"})
+	header := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	valid := signToken(t, header, map[string]interface{}{"sub": "alice", "iss": "idp", "aud": []string{"other", "terminal"}, "exp": exp}, hs256("s3cret"))
+	claims, err := v.Verify(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "alice" {
+		t.Fatalf("claims = %v", claims)
+	}
+
+	cases := map[string]string{
+		"wrong secret":   signToken(t, header, map[string]interface{}{"sub": "alice", "iss": "idp", "aud": "terminal"}, hs256("other")),
+		"expired":        signToken(t, header, map[string]interface{}{"sub": "alice", "iss": "idp", "aud": "terminal", "exp": float64(time.Now().Add(-time.Minute).Unix())}, hs256("s3cret")),
+		"wrong issuer":   signToken(t, header, map[string]interface{}{"sub": "alice", "iss": "evil", "aud": "terminal"}, hs256("s3cret")),
+		"wrong audience": signToken(t, header, map[string]interface{}{"sub": "alice", "iss": "idp", "aud": "other"}, hs256("s3cret")),
+		"no exp":         signToken(t, header, map[string]interface{}{"sub": "alice", "iss": "idp", "aud": "terminal"}, hs256("s3cret")),
+		"alg none":       signToken(t, map[string]interface{}{"alg": "none"}, map[string]interface{}{"sub": "alice", "iss": "idp", "aud": "terminal"}, func([]byte) []byte { return nil }),
+		"malformed":      "not-a-token",
+	}
+	for name, token := range cases {
+		if _, err := v.Verify(token); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestTokenVerifier_JWKS(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPub, err := ecKey.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "alg": "RS256", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecPub[1:33]), "y": b64(ecPub[33:])},
+		},
+	})
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(jwksFile, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	v := newTokenVerifier(&TokenAuthConfig{JWKSFile: jwksFile})
+	claims := map[string]interface{}{"sub": "bob", "exp": float64(time.Now().Add(time.Hour).Unix())}
+
+	rs256 := signToken(t, map[string]interface{}{"alg": "RS256", "kid": "rsa-1"}, claims, func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	})
+	if _, err := v.Verify(rs256); err != nil {
+		t.Fatalf("RS256: %s", err)
+	}
+
+	es256 := signToken(t, map[string]interface{}{"alg": "ES256", "kid": "ec-1"}, claims, func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	})
+	if _, err := v.Verify(es256); err != nil {
+		t.Fatalf("ES256: %s", err)
+	}
+
+	// HS256 must not be accepted with only a JWKS (no key confusion)
+	if _, err := v.Verify(signToken(t, map[string]interface{}{"alg": "HS256"}, claims, hs256(""))); err == nil {
+		t.Fatal("expected HS256 to be rejected without a secret")
+	}
+	// a key is only used for its own algorithm
+	if _, err := v.Verify(signToken(t, map[string]interface{}{"alg": "ES256", "kid": "rsa-1"}, claims, func([]byte) []byte { return make([]byte, 64) })); err == nil {
+		t.Fatal("expected ES256 with an RSA kid to be rejected")
+	}
+}
+
+func TestMiddleware_tokenAuth(t *testing.T) {
+	t.Parallel()
+
+	token := signToken(t, map[string]interface{}{"alg": "HS256"}, map[string]interface{}{"sub": "carol", "exp": float64(time.Now().Add(time.Hour).Unix())}, hs256("s3cret"))
+
+	app := zoox.New()
+	app.Use(Middleware(MiddlewareOptions{
+		Config:      &Config{Shell: "/bin/sh"},
+		DisablePage: true,
+		Username:    "admin",
+		Password:    "admin",
+		Token:       &TokenAuthConfig{Secret: "s3cret"},
+	}))
+	app.Get("/whoami", func(ctx *zoox.Context) {
+		id, _ := IdentityFromRequest(ctx.Request)
+		ctx.String(200, id.Method+":"+id.Subject)
+	})
+
+	cases := []struct {
+		name   string
+		setup  func(r *http.Request)
+		status int
+		body   string
+	}{
+		{"bearer header", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, 200, "jwt:carol"},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "token", Value: token}) }, 200, "jwt:carol"},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "token=" + token }, 200, "jwt:carol"},
+		{"basic fallback", func(r *http.Request) { r.SetBasicAuth("admin", "admin") }, 200, "basic:admin"},
+		{"invalid token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token+"x") }, 401, ""},
+		{"anonymous", func(r *http.Request) {}, 401, ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/whoami", nil)
+		tc.setup(req)
+		rec := httptest.NewRecorder()
+		app.ServeHTTP(rec, req)
+
+		if rec.Code != tc.status || (tc.body != "" && rec.Body.String() != tc.body) {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, rec.Code, rec.Body.String(), tc.status, tc.body)
+		}
+	}
+}